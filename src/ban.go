@@ -43,6 +43,9 @@ type banRequest struct {
 	MessageRemoved bool `json:"removed"`
 	// Admin who removed the message.
 	RemovedBy int64 `json:"handler"`
+	// File ID of the photo in the message, if any, so admins can add it to
+	// the image blocklist.
+	PhotoID string `json:"photo,omitempty"`
 }
 
 // banRequestList stores the list of bans requested alongside the threshold for
@@ -113,6 +116,7 @@ func (b *bans) banRequestHandler(bot tgbotInterface, update tgbotapi.Update) err
 			Notifications:  map[int64]int64{},
 			RemovedBy:      0,
 			Reporters:      map[int64]int64{},
+			PhotoID:        hashPhotoID(update.Message.ReplyToMessage),
 		}
 		b.Requests.Bans[key] = report
 		if err := safeWriteJSON(b.Requests, b.requestedBansDB); err != nil {
@@ -183,7 +187,8 @@ func (b *bans) loadBanRequestsInfo() error {
 // options:
 // - go to message (if in a public group/channel);
 // - remove the offending message;
-// - remove the offending message and ban its author.
+// - remove the offending message and ban its author;
+// - add the photo in the offending message to the image blocklist (if any).
 // It returns the id of the notification message sent.
 func notifyAdmin(bot tgbotInterface, admin *tgbotapi.User, update tgbotapi.Update) (int64, error) {
 	offendingMessageID := update.Message.ReplyToMessage.MessageID
@@ -194,20 +199,18 @@ func notifyAdmin(bot tgbotInterface, admin *tgbotapi.User, update tgbotapi.Updat
 	removeMessageButton := button(T("remove_message"), fmt.Sprintf("delete-message-%s", requestID))
 	removeMessageAndBanUserButton := button(T("remove_message_and_ban"), fmt.Sprintf("ban-user-%s", requestID))
 
-	var markup tgbotapi.InlineKeyboardMarkup
+	var rows [][]tgbotapi.InlineKeyboardButton
 
 	// Links won't work if there is no username.
 	if len(update.Message.Chat.UserName) > 0 {
 		goToMessageButton := buttonURL(T("go_to_notification"), fmt.Sprintf("https://t.me/%s/%d", update.Message.Chat.UserName, update.Message.ReplyToMessage.MessageID))
-		markup = tgbotapi.NewInlineKeyboardMarkup(
-			tgbotapi.NewInlineKeyboardRow(goToMessageButton),
-			tgbotapi.NewInlineKeyboardRow(removeMessageButton, removeMessageAndBanUserButton),
-		)
-	} else {
-		markup = tgbotapi.NewInlineKeyboardMarkup(
-			tgbotapi.NewInlineKeyboardRow(removeMessageButton, removeMessageAndBanUserButton),
-		)
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(goToMessageButton))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(removeMessageButton, removeMessageAndBanUserButton))
+	if hashPhotoID(update.Message.ReplyToMessage) != "" {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(button(T("block_image"), fmt.Sprintf("block-image-%s", requestID))))
 	}
+	markup := tgbotapi.NewInlineKeyboardMarkup(rows...)
 
 	notificationText := fmt.Sprintf(T("notify_admin"), formatName(*update.Message.From), update.Message.From.ID, update.Message.Chat.Title, update.Message.ReplyToMessage.Text)
 	// We also replace literal newline `\n` with "\n", so that the lines will
@@ -263,6 +266,14 @@ func (b *bans) deleteMessageFromBanRequest(bot tgbotInterface, admin *tgbotapi.U
 	return b.updateBanRequestNotification(bot, requestID, admin, T("notification_update_delete_and_ban"))
 }
 
+// banRequestInfo returns the ban request with the given ID, if any.
+func (b *bans) banRequestInfo(requestID string) (banRequest, bool) {
+	b.RLock()
+	defer b.RUnlock()
+	report, ok := b.Requests.Bans[requestID]
+	return report, ok
+}
+
 // deleteMessage deletes the message indicated by requestID and updates the
 // information on disk relative to it.
 func (b *bans) deleteMessage(bot tgbotInterface, admin *tgbotapi.User, requestID string) error {
@@ -335,3 +346,19 @@ func kickUserUntil(bot kickChatMemberer, chatID int64, userID int, until time.Ti
 	})
 	return err
 }
+
+// muteUserUntil prevents a user from sending any messages until a specific
+// time.
+func muteUserUntil(bot restrictChatMemberer, chatID int64, userID int, until time.Time) error {
+	deny := false
+	memberConfig := tgbotapi.ChatMemberConfig{ChatID: chatID, UserID: userID}
+	_, err := bot.RestrictChatMember(tgbotapi.RestrictChatMemberConfig{
+		ChatMemberConfig:      memberConfig,
+		UntilDate:             until.Unix(),
+		CanSendMessages:       &deny,
+		CanSendMediaMessages:  &deny,
+		CanSendOtherMessages:  &deny,
+		CanAddWebPagePreviews: &deny,
+	})
+	return err
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"slices"
 	"strings"
 	"time"
 
@@ -28,7 +29,13 @@ type opBot struct {
 	commands map[string]botCommand
 
 	// New users must follow certain restrictions.
-	newUserCache *cache.Cache
+	probation *probation
+
+	// Reputation of users, by chat.
+	reputation *reputation
+
+	// Sources of forwards added to the allowlist by the admins.
+	forwardAllowlist *forwardAllowlist
 
 	// List of users not yet validated by captcha.
 	pendingCaptcha *pendingCaptchaType
@@ -39,6 +46,9 @@ type opBot struct {
 	// Don't send warning messages to new users on every infraction.
 	newUserWarningCache *cache.Cache
 
+	// Number of banned bots added by each user, by chat.
+	botInviters *cache.Cache
+
 	// Time to live for welcome messages
 	welcomeMessageTTL time.Duration
 
@@ -53,14 +63,72 @@ type opBot struct {
 	// statsWriter holds handler to write stats to disk.
 	statsWriter io.WriteCloser
 
+	// Stats of messages sent on behalf of chats are kept apart.
+	senderChatStatsWriter io.WriteCloser
+
 	// List of ban patterns.
 	patterns opPatterns
+
+	// Error from the last load of the patterns file, if any. The file is not
+	// rewritten by the pattern commands while it fails to load.
+	patternsLoadErr error
+
+	// Hit statistics for the ban patterns.
+	patternStats *patternStats
+
+	// Users banned from all managed chats.
+	globalBans *globalBans
+
+	// Images blocked in all chats.
+	images *imageBlocklist
+
+	// Spam classifier, shared by all chats.
+	classifier *classifier
+
+	// Messages removed automatically, for review by the admins.
+	quarantine *quarantine
+
+	// Recent messages from each user, for flood control, and users already
+	// warned about flooding.
+	floodCache        *cache.Cache
+	floodWarningCache *cache.Cache
+
+	// Fingerprints of recent messages, to detect spam waves.
+	duplicates dupIndex
+
+	// Join rate and lockdown state of each chat.
+	raids *raids
+
+	// Messages posted by users who joined recently, to detect users who
+	// join, post and leave.
+	recentJoins *cache.Cache
+
+	// Admins of each chat, and names of the users seen in each chat, to
+	// detect users impersonating the admins.
+	impostorAdmins *cache.Cache
+	knownNames     *cache.Cache
+
+	// Channel linked to each chat, for the sender_chat policy.
+	linkedChats *cache.Cache
+
+	// Joins and leaves in each chat since the last daily count.
+	serviceCounts *serviceCounts
+
+	// Users with and without profile photos, and users under probation who
+	// already posted in each chat, used by the spam scoring.
+	profilePhotoCache *cache.Cache
+	postedCache       *cache.Cache
+
+	// Recently moderated messages in scoring mode.
+	scoreLog   []scoredMessage
+	scoreLogID int
 }
 
 // botCommands holds the commands accepted by the bot, their description and a handler function.
 type botCommand struct {
 	desc      string
 	adminOnly bool
+	ownerOnly bool
 	pvtOnly   bool
 	enabled   bool
 	handler   func(tgbotInterface, tgbotapi.Update) error
@@ -68,10 +136,14 @@ type botCommand struct {
 
 // newOpBot returns a new OpBot.
 func newOpBot(config botConfig) (opBot, error) {
-	sw, err := initStats()
+	sw, err := initStats(statsDB)
 	if err != nil {
 		return opBot{}, fmt.Errorf("error initializing stats: %v", err)
 	}
+	scw, err := initStats(senderChatStatsDB)
+	if err != nil {
+		return opBot{}, fmt.Errorf("error initializing sender chat stats: %v", err)
+	}
 
 	// Convert from parsed duration to time.Duration.
 	duration := config.NewUserProbationTime.Duration
@@ -84,25 +156,57 @@ func newOpBot(config botConfig) (opBot, error) {
 		geolocations:  newGeolocations(config.LocationKey),
 		statsWriter:   sw,
 
-		newUserCache:   cache.New(duration, duration),
+		senderChatStatsWriter: scw,
+
+		probation:      newProbation(),
+		reputation:     newReputation(),
 		pendingCaptcha: newPendingCaptchaType(),
 		captchaFails:   newCaptchaFailures(),
 
 		// How often will re-send warning messages to offending new users.
 		newUserWarningCache: cache.New(30*time.Minute, time.Hour),
 
+		botInviters: cache.New(defaultBotInviterWindow, time.Hour),
+
 		// By default welcome messages will last for 30 minutes.
 		welcomeMessageTTL: 30 * time.Minute,
 
 		// Enable captcha by default.
 		captchaTime: 1 * time.Minute,
 		patterns:    opPatterns{},
+
+		patternStats: newPatternStats(),
+		globalBans:   newGlobalBans(),
+		images:       newImageBlocklist(),
+		classifier:   newClassifier(),
+		quarantine:   newQuarantine(),
+
+		forwardAllowlist: newForwardAllowlist(),
+
+		floodCache:        cache.New(time.Minute, 10*time.Minute),
+		floodWarningCache: cache.New(time.Minute, 10*time.Minute),
+		duplicates:        dupIndex{},
+		raids:             newRaids(),
+		recentJoins:       cache.New(10*time.Minute, 10*time.Minute),
+		impostorAdmins:    cache.New(impostorAdminCacheTime, time.Hour),
+		knownNames:        cache.New(24*time.Hour, time.Hour),
+		linkedChats:       cache.New(linkedChatCacheTime, time.Hour),
+		serviceCounts:     newServiceCounts(),
+
+		profilePhotoCache: cache.New(time.Hour, time.Hour),
+		postedCache:       cache.New(duration, duration),
 	}, nil
 }
 
 // Close performs cleanup functions on the bot.
 func (x *opBot) Close() {
 	x.statsWriter.Close()
+	x.senderChatStatsWriter.Close()
+	x.reputation.flush()
+	x.patternStats.flush()
+	x.probation.flush()
+	x.classifier.flush()
+	x.quarantine.flush()
 }
 
 // Run is the main message dispatcher for the bot.
@@ -113,10 +217,27 @@ func (x *opBot) Run(bot *tgbotapi.BotAPI) {
 	// Initialize the join patterns list.
 	x.reloadMatchPatterns(bot, tgbotapi.Update{})
 
+	// Message counts, pattern hits, probations, the classifier model and the
+	// quarantine are saved periodically.
+	x.reputation.autosave(time.Minute)
+	x.patternStats.autosave(time.Minute)
+	x.probation.autosave(time.Minute)
+	x.classifier.autosave(time.Minute)
+	x.quarantine.autosave(time.Minute)
+	x.classifier.autoExpireReports(time.Hour)
+
+	// Report stale patterns to the admins periodically.
+	x.patternReporter(bot)
+
+	// Post the daily join and leave counts.
+	x.serviceCountReporter(bot)
+
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
 
-	updates, _ := bot.GetUpdatesChan(u)
+	// Our own version of GetUpdatesChan keeps the message fields the
+	// Telegram library drops (see updates.go).
+	updates := updatesChan(bot, u)
 
 	for update := range updates {
 		//s, _ := json.MarshalIndent(update, "", "  ")
@@ -128,6 +249,20 @@ func (x *opBot) Run(bot *tgbotapi.BotAPI) {
 
 		log.Println("NOTICE: user is new: ", isNewUser, update.ChatMember)
 
+		// Users unbanned by an admin may have been false positives.
+		if unbannedByAdmin(update, bot.Self.ID) {
+			uid := update.ChatMember.NewChatMember.User.ID
+			x.patternStats.unbanned(uid)
+			if x.globalBans.remove(uid) {
+				log.Printf("User %d unbanned by an admin, removed from the global banlist", uid)
+			}
+		}
+
+		// Users who post and leave right after joining are banned.
+		if x.handledJoinSpamLeave(bot, update) {
+			continue
+		}
+
 		switch {
 		case isNewUser:
 			// The API sets ChatMember.NewChatMember if we have a new user joining.
@@ -139,9 +274,39 @@ func (x *opBot) Run(bot *tgbotapi.BotAPI) {
 
 			log.Printf("Processing new user request for user %q, uid=%d\n", formatName(newUser), newUser.ID)
 
+			// Known spammers are banned before anything else happens.
+			if x.handledGlobalBan(bot, newChatID, newUser) {
+				continue
+			}
+
+			// New users must follow certain restrictions for a while.
+			if !newUser.IsBot {
+				x.startProbation(newChatID, newUser.ID)
+				x.reputation.joined(newChatID, newUser, time.Now())
+				x.trackJoin(newChatID, newUser.ID, time.Now())
+			}
+
+			// Nicknames written in unwanted scripts.
+			if x.handledScriptNickname(bot, newChatID, newUser) {
+				continue
+			}
+
+			// Users impersonating the admins are restricted until the admins
+			// decide what to do. Later name changes are checked as well.
+			x.nameChanged(newChatID, newUser)
+			if x.handledImpostor(bot, update.ChatMember.Chat, newUser, "join") {
+				continue
+			}
+
 			// Ban bots. Move on to next user.
-			if newUser.IsBot {
-				x.banNewBots(bot, update, newUser)
+			if x.banNewBots(bot, update, newUser) {
+				continue
+			}
+
+			// During a raid, new users are handled without posting anything
+			// in the chat.
+			if x.watchRaid(bot, newChatID, newUser) {
+				continue
 			}
 
 			// At this point we probably have a real user. Send the captcha and
@@ -159,19 +324,37 @@ func (x *opBot) Run(bot *tgbotapi.BotAPI) {
 		case update.CallbackQuery != nil:
 			x.handleCallbackQuery(bot, update)
 
+		case update.EditedMessage != nil:
+			promEditedMessageCount.Inc()
+			x.moderateEditedMessage(bot, update)
+
 		case update.Message != nil:
 			promMessageCount.Inc()
 
+			// Join and leave service messages.
+			if x.handledServiceMessage(bot, update.Message) {
+				continue
+			}
+
+			// Messages sent on behalf of channels follow the chat policy.
+			if x.handledSenderChat(bot, update.Message) {
+				continue
+			}
+			senderChat := senderChatKind(update.Message)
+			anonymousAdmin := senderChat == senderAnonymousAdmin
+
 			// Is user an admin?
 			var admin bool
 			admin, err := isAdmin(bot, update.Message.Chat.ID, update.Message.From.ID)
-			if err != nil {
+			if err != nil && !anonymousAdmin {
 				log.Printf("Unable to determine if user (id: %d) is an admin in chat (id: %d). Assuming not.", update.Message.From.ID, update.Message.Chat.ID)
 			}
+			admin = admin || anonymousAdmin
 			log.Println("NOTICE: user is admin: ", admin)
 
-			// Remove messages from bots.
-			if update.Message.From != nil && update.Message.From.IsBot {
+			// Remove messages from bots (but not from the placeholder bots
+			// used for messages sent on behalf of chats).
+			if update.Message.From != nil && update.Message.From.IsBot && senderChat == "" {
 				deleteMessage(bot, update.Message.Chat.ID, update.Message.MessageID)
 				log.Printf("Removed message sent by bot. ChatID: %v, MessageID: %v", update.Message.Chat.ID, update.Message.MessageID)
 				continue
@@ -183,17 +366,65 @@ func (x *opBot) Run(bot *tgbotapi.BotAPI) {
 			// Notifications.
 			x.notifications.manageNotifications(bot, update)
 
-			if !admin {
+			// Messages sent on behalf of channels are moderated as if the
+			// channel were the author, so each channel has its own state.
+			if senderChat == senderChannel {
+				if user, ok := senderChatUser(update.Message, senderChat); ok {
+					update.Message.From = &user
+				}
+			}
+
+			// Messages sent to the bot in private (commands, captcha
+			// answers) are not moderated.
+			moderated := !admin && !isPrivateChat(update.Message.Chat)
+
+			if moderated {
+				// Users changing their names to impersonate the admins.
+				if x.handledImpostorMessage(bot, update.Message) {
+					continue
+				}
+
+				// Too many messages in a short time.
+				if x.handledFlood(bot, update.Message) {
+					continue
+				}
+
 				// Handle ban patterns before proceeding.
 				match, err := x.handledPatternMatching(bot, update)
 				if err != nil {
 					log.Printf("Error handling pattern matching: %v\n", err)
-				} else if match == opBan || match == opKick {
-					// For these cases, there is no need to send a captcha.
-					log.Printf("Kick/Ban pattern match for userID %d, action %q\n", update.Message.From.ID, match.String())
+				} else if match.deletesMessage() {
+					// Messages banned by the patterns are spam samples.
+					if match == opBan {
+						x.classifier.learn(classifierText(update.Message), classSpam, 1)
+					}
+					// The message is gone, so there is no need to handle captchas.
+					log.Printf("Pattern match for userID %d, action %q\n", update.Message.From.ID, match.String())
 					continue
 				}
 
+				// Messages written in unwanted scripts.
+				if x.handledScriptFilter(bot, update.Message) {
+					continue
+				}
+
+				// Similar messages posted by many users.
+				if x.handledDuplicates(bot, update.Message) {
+					continue
+				}
+
+				// Spam according to the classifier, for users with low reputation.
+				if x.handledClassifier(bot, update.Message) {
+					continue
+				}
+
+				// Links to blocked domains, and most links from new users.
+				if x.handledLinkFiltering(bot, update.Message) {
+					continue
+				}
+			}
+
+			if !admin {
 				// Handle messages from users who are yet to validate the captcha.
 				captcha := userCaptcha(x, bot, update.Message.Chat.ID, update.Message.From.ID)
 				if captcha != nil {
@@ -203,14 +434,34 @@ func (x *opBot) Run(bot *tgbotapi.BotAPI) {
 					msgid := update.Message.MessageID
 					chatid := update.Message.Chat.ID
 
+					// The captcha may have been sent in private (E.g. during a
+					// raid), but it applies to the chat the user joined.
+					groupID := captcha.chatID
+					if groupID == 0 {
+						groupID = chatid
+					}
+
 					// Remove all messages, validate text later (see below).
 					log.Printf("Removing message %d from non-captcha validated user %s (id=%d), want captcha=%04.4d: %q", msgid, name, userid, captcha.code, text)
+					x.quarantineMessage(update.Message, "pending captcha")
 					deleteMessage(bot, update.Message.Chat.ID, msgid)
 
+					// Users restricted during a raid get their captcha on
+					// their first message.
+					if captcha.code == captchaNotSent {
+						x.sendCaptcha(bot, chatid, msgid, *update.Message.From)
+						x.pendingCaptcha.setChat(userid, groupID)
+						x.captchaReaper(bot, groupID, *update.Message.From)
+						continue
+					}
+
 					// If the user requested another captcha, reset the code and
 					// send another captcha.
 					if captchaResendRequest(text) {
+						// Keep the chat the user joined, not the one where
+						// the captcha was sent.
 						x.sendCaptcha(bot, chatid, msgid, *update.Message.From)
+						x.pendingCaptcha.setChat(userid, groupID)
 						continue
 					}
 
@@ -224,30 +475,41 @@ func (x *opBot) Run(bot *tgbotapi.BotAPI) {
 						// started to kick this user at join time will find nothing
 						// and exit normally.
 						promCaptchaValidatedCount.Inc()
+						x.restartProbation(groupID, userid)
 						x.pendingCaptcha.del(userid)
 						x.captchaFails.reset(userid)
 						x.sendWelcome(bot, update, *update.Message.From)
 					} else {
 						promCaptchaFailedCount.Inc()
-						x.handleCaptchaFailure(bot, chatid, msgid, *update.Message.From)
+						x.handleCaptchaFailure(bot, groupID, msgid, *update.Message.From)
 					}
 					continue
 				}
+			}
 
-				// Block many types of rich media from new users (but always allows admins).
-				if removeBadRichMessages(bot, update) != 0 {
+			if moderated {
+				// Content denied to new users, or to everyone (but always
+				// allowed to admins).
+				if _, ok := x.handledProbationRules(bot, update.Message); ok {
 					continue
 				}
 
-				if x.config.NewUserProbationTime.Hours() > 0 {
-					x.processNewUsers(bot, update)
+				// Photos in the image blocklist are checked in the
+				// background, and only counted once found clean.
+				if !x.checkBlockedImage(bot, update.Message) {
+					x.countSurvivingMessage(update.Message)
 				}
 			}
 
 			switch {
 			// Forward message handling.
-			case x.config.DeleteFwd && isForwarded(update.Message):
+			case x.config.DeleteFwd && isForwarded(update.Message) && x.forwardAllowed(update.Message):
+				promForwardAllowedCount.Inc()
+				log.Printf("Kept forwarded message from an allowed source. ChatID: %v, MessageID: %v", update.Message.Chat.ID, update.Message.MessageID)
+
+			case x.deletesForward(update.Message):
 				// Remove forwarded message and log.
+				x.quarantineMessage(update.Message, "forwarded message")
 				bot.DeleteMessage(tgbotapi.DeleteMessageConfig{
 					ChatID:    update.Message.Chat.ID,
 					MessageID: update.Message.MessageID,
@@ -266,41 +528,64 @@ func (x *opBot) Run(bot *tgbotapi.BotAPI) {
 	}
 }
 
-// updateMessageStats updates the message statistics for all messages from a
-// specific username.  Emits an error message to output in case of errors.
-func updateMessageStats(w io.Writer, update tgbotapi.Update, username string) {
-	if update.Message.From != nil && update.Message.Chat.UserName == username {
-		if saved, err := saveStats(w, &update); err != nil {
-			log.Println(T("stats_error_saving"), err.Error(), saved)
-		}
+// moderateEditedMessage applies the same checks used for new messages to
+// edited messages. Otherwise, spammers could post something harmless and
+// edit it into spam later.
+func (x *opBot) moderateEditedMessage(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	msg := update.EditedMessage
+	if msg.From == nil || msg.Chat == nil || isPrivateChat(msg.Chat) || senderChatKind(msg) != "" {
+		return
 	}
-}
 
-// banNewBots bans the user if it is a bot and not in our bot whitelist.
-// Returns true if a bot was banned, false otherwise. Due to the way telegram
-// works, this only works for supergroups.
-func (x *opBot) banNewBots(bot kickChatMemberer, update tgbotapi.Update, user tgbotapi.User) {
-	// Only if configured.
-	if !x.config.KickBots {
-		return
+	admin, err := isAdmin(bot, msg.Chat.ID, msg.From.ID)
+	if err != nil {
+		log.Printf("Unable to determine if user (id: %d) is an admin in chat (id: %d). Assuming not.", msg.From.ID, msg.Chat.ID)
 	}
-	// Bots only.
-	if !user.IsBot {
+	if admin {
 		return
 	}
 
-	// Note: It's safe to use user.UsernName here as bots should always have a name.
+	// Pattern matching works on update.Message, so hand it the edited
+	// message as if it were a new one.
+	match, err := x.handledPatternMatching(bot, tgbotapi.Update{UpdateID: update.UpdateID, Message: msg})
+	if err != nil {
+		log.Printf("Error handling pattern matching on edited message: %v\n", err)
+	}
+	if match != opNoAction {
+		promEditedMessageModeratedCount.WithLabelValues("pattern").Inc()
+		log.Printf("Pattern match on edited message for userID %d, action %q\n", msg.From.ID, match.String())
+		if match.deletesMessage() {
+			return
+		}
+	}
+
+	if x.handledScriptFilter(bot, msg) {
+		promEditedMessageModeratedCount.WithLabelValues("script").Inc()
+		return
+	}
 
-	// Skip whitelisted bots.
-	if stringInSlice(user.UserName, x.config.BotWhitelist) {
-		log.Printf("Whitelisted bot %q has joined. Doing nothing.", user.UserName)
+	if x.handledLinkFiltering(bot, msg) {
+		promEditedMessageModeratedCount.WithLabelValues("link").Inc()
 		return
 	}
-	// Ban!
-	if err := banUser(bot, update.Message.Chat.ID, user.ID); err != nil {
-		log.Printf("Error attempting to ban bot named %q: %v", user.UserName, err)
+
+	if rule, ok := x.handledProbationRules(bot, msg); ok {
+		label := "probation"
+		if rule.Everyone {
+			label = "rich_media"
+		}
+		promEditedMessageModeratedCount.WithLabelValues(label).Inc()
+	}
+}
+
+// updateMessageStats updates the message statistics for all messages from a
+// specific username.  Emits an error message to output in case of errors.
+func updateMessageStats(w io.Writer, update tgbotapi.Update, username string) {
+	if update.Message.From != nil && update.Message.Chat.UserName == username && senderChatKind(update.Message) == "" {
+		if saved, err := saveStats(w, &update); err != nil {
+			log.Println(T("stats_error_saving"), err.Error(), saved)
+		}
 	}
-	log.Printf("Banned bot %q. Hasta la vista, baby...", user.UserName)
 }
 
 // sendWelcome sends a new message to newly joined users.
@@ -309,14 +594,6 @@ func (x *opBot) sendWelcome(bot sendDeleteMessager, update tgbotapi.Update, user
 	if user.IsBot {
 		return
 	}
-	// New users get flagged as such. If new user restrictions are
-	// enabled, only text messages will be allowed.
-	strID := fmt.Sprintf("%d", user.ID)
-	if _, found := x.newUserCache.Get(strID); !found {
-		log.Printf("User %s marked as a new user.", formatName(user))
-		x.newUserCache.Set(strID, time.Now(), cache.DefaultExpiration)
-	}
-
 	markup := tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(buttonURL(T("visit_our_group_website"), osProgramadoresURL)),
 		tgbotapi.NewInlineKeyboardRow(buttonURL(T("read_the_rules"), osProgramadoresRulesURL)),
@@ -331,50 +608,9 @@ func (x *opBot) sendWelcome(bot sendDeleteMessager, update tgbotapi.Update, user
 	selfDestructMessage(bot, welcome.Chat.ID, welcome.MessageID, x.welcomeMessageTTL)
 }
 
-// processNewUsers verifies if the user has been on the list for less than a
-// pre-determined amount of time. If so, delete any non-text messages from the
-// user and send a self-destructing warning message.
-func (x *opBot) processNewUsers(bot sendDeleteMessager, update tgbotapi.Update) {
-	strID := fmt.Sprintf("%d", update.Message.From.ID)
-
-	// Return immediately if user not in probation list.
-	if _, found := x.newUserCache.Get(strID); !found {
-		return
-	}
-
-	// Blocks non-text messages. Checks messages and edited messages.
-	for _, msg := range []*tgbotapi.Message{update.Message, update.EditedMessage} {
-		if richMessage(msg) {
-			promRichMessageDeletedCount.Inc()
-
-			// Log and delete message.
-			log.Printf("Deleting rich message message from new user %s.", formatName(*msg.From))
-
-			// We only send a reply message if the user does not appear in the
-			// newUserWarningCache (which has a expiration of minutes). The
-			// idea is to prevent a repeat offender from causing the bot to
-			// flood the group.
-			if _, found := x.newUserWarningCache.Get(strID); !found {
-				markup := tgbotapi.NewInlineKeyboardMarkup(
-					tgbotapi.NewInlineKeyboardRow(buttonURL(T("read_the_rules"), osProgramadoresRulesURL)),
-				)
-				reply, err := sendReplyWithMarkup(bot, msg.Chat.ID, msg.MessageID, T("only_text_messages"), markup)
-				// We log errors but try to move ahead and still block the offending message.
-				if err != nil {
-					log.Printf("Error sending rules message: %v", err)
-				}
-				// Delete warning message.
-				selfDestructMessage(bot, reply.Chat.ID, reply.MessageID, 0)
-			}
-
-			// Delete original message.
-			bot.DeleteMessage(tgbotapi.DeleteMessageConfig{
-				ChatID:    msg.Chat.ID,
-				MessageID: msg.MessageID,
-			})
-			x.newUserWarningCache.Set(strID, time.Now(), cache.DefaultExpiration)
-		}
-	}
+// onProbation returns true if the user is still under probation in the chat.
+func (x *opBot) onProbation(chatID int64, userID int) bool {
+	return x.probation.active(chatID, userID)
 }
 
 // processUserCommands processes all user to bot commands (usually starting with a slash) by
@@ -392,9 +628,14 @@ func (x *opBot) processUserCommands(bot *tgbotapi.BotAPI, update tgbotapi.Update
 		log.Printf("Ignoring non-private request on private only command %q", cmd)
 		return
 	}
-	// Fail silently if a regular user makes an admin-only request.
+	// Fail silently if a regular user makes an admin-only request. Admin
+	// commands sent in private are accepted from the admins of any of the
+	// chats we manage.
 	if bcmd.adminOnly {
 		admin, err := isAdmin(bot, update.Message.Chat.ID, update.Message.From.ID)
+		if isPrivateChat(update.Message.Chat) {
+			admin, err = x.isManagedChatAdmin(bot, update.Message.From.ID)
+		}
 		if err != nil {
 			log.Printf("Error retrieving user info for %v: %v", update, err)
 			return
@@ -404,6 +645,12 @@ func (x *opBot) processUserCommands(bot *tgbotapi.BotAPI, update tgbotapi.Update
 			return
 		}
 	}
+	// Commands changing the state shared by all chats are reserved to the
+	// owners.
+	if bcmd.ownerOnly && !x.isOwner(bot, update.Message.From.ID) {
+		log.Printf("User %s attempted to use owner-only command: %s (ignored)", formatName(*update.Message.From), cmd)
+		return
+	}
 
 	// Handle command. Emit (and log) error.
 	err := bcmd.handler(bot, update)
@@ -414,59 +661,190 @@ func (x *opBot) processUserCommands(bot *tgbotapi.BotAPI, update tgbotapi.Update
 	}
 }
 
+// countSurvivingMessage counts a message that survived moderation towards the
+// graduation of its author from probation, and towards the reputation of the
+// author. The message is also deleted if the user leaves right away. Commands
+// and forwards about to be deleted are not counted.
+func (x *opBot) countSurvivingMessage(msg *tgbotapi.Message) {
+	if msg.IsCommand() || x.deletesForward(msg) {
+		return
+	}
+	x.countCleanMessage(msg)
+	x.reputation.message(msg.Chat.ID, *msg.From, time.Now())
+	x.trackJoinMessage(msg)
+}
+
+// handledPatternMatching matches the message against the ban patterns and
+// performs the action associated with the matching pattern (or with the
+// message score, in chats using scoring mode). Users banned by the patterns
+// are added to the global banlist. It returns the action taken, or
+// opNoAction if no pattern matched.
 func (x *opBot) handledPatternMatching(bot *tgbotapi.BotAPI, update tgbotapi.Update) (opMatchAction, error) {
-	ok, action := x.patterns.MatchFromUpdate(bot, update)
+	action, err := x.matchPatterns(bot, update)
+	if action == opBan && err == nil {
+		if err := x.globalBan(bot, update.Message.From.ID, "automatic ban: "+messageSample(update.Message), update.Message.Chat.ID); err != nil {
+			log.Printf("Not adding uid=%d to the global banlist: %v", update.Message.From.ID, err)
+		}
+	}
+	return action, err
+}
+
+// matchPatterns performs the pattern matching for handledPatternMatching.
+func (x *opBot) matchPatterns(bot *tgbotapi.BotAPI, update tgbotapi.Update) (opMatchAction, error) {
+	if msg := update.Message; msg != nil && msg.Chat != nil {
+		if cfg := x.config.forChat(msg.Chat.ID).Score; cfg.Enabled {
+			return x.handledScoring(bot, msg, cfg)
+		}
+	}
+
+	ok, rule := x.patterns.MatchFromUpdate(bot, update)
 
 	if !ok {
 		// No matches, so we can return.
-		return action, nil
+		return opNoAction, nil
 	}
 
-	// Message that matched the patterns is always deleted, then a specific
-	// action follows.
-	if deleteMessage(bot, update.Message.Chat.ID, update.Message.MessageID) == nil {
-		log.Printf("Removed message that matched the ban patterns. ChatID: %v, MessageID: %v", update.Message.Chat.ID, update.Message.MessageID)
-		promPatternMessageDeletedCount.Inc()
+	// A pattern without a (valid) action simply deletes the message.
+	action := rule.matchAction()
+	if action == opNoAction {
+		action = opDelete
 	}
 
-	// For now we only have two actions: ban and kick.
-	var err error
-	if action == opBan || action == opKick {
-		f := map[opMatchAction]func(kickChatMemberer, int64, int) error{
-			opBan:  banUser,
-			opKick: kickUser,
-		}
-		err = f[action](bot, update.Message.Chat.ID, update.Message.From.ID)
+	victim := 0
+	if action.removesUser() {
+		victim = update.Message.From.ID
+	}
+	x.patternStats.record(rule.ID, messageSample(update.Message), victim)
+
+	return action, x.performPatternAction(bot, update.Message, action, rule.Duration.Duration, fmt.Sprintf("pattern #%d", rule.ID))
+}
+
+// messageSample returns the text we use as a sample of the message in logs
+// and statistics.
+func messageSample(msg *tgbotapi.Message) string {
+	switch {
+	case msg.Text != "":
+		return msg.Text
+	case msg.Caption != "":
+		return msg.Caption
+	case msg.Sticker != nil:
+		return "sticker: " + msg.Sticker.SetName
+	}
+	return ""
+}
+
+// unbannedByAdmin returns true if the update indicates that someone other
+// than the bot lifted a ban on a user.
+func unbannedByAdmin(update tgbotapi.Update, botID int) bool {
+	cm := update.ChatMember
+	return cm != nil && cm.OldChatMember != nil && cm.NewChatMember != nil &&
+		cm.NewChatMember.User != nil && cm.From != nil && cm.From.ID != botID &&
+		cm.OldChatMember.Status == "kicked" && cm.NewChatMember.Status != "kicked"
+}
+
+// performPatternAction performs the given action on the message and its
+// author. The duration is only used by mute and tempban, and a zero duration
+// means the default for the action. The reason is kept with the message in the
+// quarantine.
+func (x *opBot) performPatternAction(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, action opMatchAction, d time.Duration, reason string) error {
+	promPatternActionCount.WithLabelValues(strings.ToLower(action.String())).Inc()
+
+	user := *msg.From
+	chatID := msg.Chat.ID
+
+	switch action {
+	case opNoAction:
+		return nil
+	case opReport:
+		// Reported messages stay in place until an admin decides what to do.
+		log.Printf("Reporting message that matched the ban patterns. ChatID: %v, MessageID: %v", chatID, msg.MessageID)
+		return x.reportMessage(bot, msg)
+	case opWarn:
+		x.reputation.warned(chatID, user.ID)
+		// Warn before deleting, so the warning can reply to the message.
+		reply, err := sendReply(bot, chatID, msg.MessageID, fmt.Sprintf(T("pattern_warning"), nameRef(user)))
 		if err != nil {
-			log.Printf("Error performing action %q with username %q (%s %s): %v", action.String(), update.Message.From.UserName, update.Message.From.FirstName, update.Message.From.LastName, err)
+			log.Printf("Error sending pattern warning to user %s: %v", formatName(user), err)
 		} else {
-			log.Printf("Action %q performed for user %q (%s %s). Hasta la vista, baby...", action.String(), update.Message.From.UserName, update.Message.From.FirstName, update.Message.From.LastName)
-			promPatternKickBannedCount.Inc()
+			selfDestructMessage(bot, reply.Chat.ID, reply.MessageID, 0)
 		}
 	}
-	return action, err
+
+	// Every other action deletes the message, then a specific action follows.
+	x.quarantineMessage(msg, reason)
+	if deleteMessage(bot, chatID, msg.MessageID) == nil {
+		log.Printf("Removed message that matched the ban patterns. ChatID: %v, MessageID: %v", chatID, msg.MessageID)
+		promPatternMessageDeletedCount.Inc()
+	}
+
+	return x.performUserAction(bot, chatID, user, action, d)
 }
 
-// removeBadRichMessages removes undesirable rich messages (Voice, VideoNotes, etc).
-// Returns the number of deleted messages.
-func removeBadRichMessages(bot sendDeleteMessager, update tgbotapi.Update) int {
-	var deleted int
-
-	// Blocks undesirable rich text messages. Checks messages and edited messages.
-	for _, msg := range []*tgbotapi.Message{update.Message, update.EditedMessage} {
-		if undesirableRichMessage(msg) {
-			deleted++
-			promRichMessageDeletedCount.Inc()
-
-			// Log and delete message.
-			log.Printf("Deleting undesirable rich message from user %s.", formatName(*msg.From))
-			bot.DeleteMessage(tgbotapi.DeleteMessageConfig{
-				ChatID:    msg.Chat.ID,
-				MessageID: msg.MessageID,
-			})
+// performUserAction performs the part of the action that applies to the user
+// (ban, kick, tempban and mute). Other actions are ignored. Channels can only
+// be banned, so every action removing a user bans the channel instead. The
+// placeholder users shared by everybody posting on behalf of chats are left
+// alone.
+func (x *opBot) performUserAction(bot *tgbotapi.BotAPI, chatID int64, user tgbotapi.User, action opMatchAction, d time.Duration) error {
+	if isPlaceholderUser(user.ID) {
+		return nil
+	}
+	if isSenderChatUser(user) {
+		if !action.removesUser() {
+			return nil
+		}
+		if err := banSenderChat(bot, chatID, int64(user.ID)); err != nil {
+			log.Printf("Error performing action %q on channel %s (%d): %v", action.String(), user.FirstName, user.ID, err)
+			return err
+		}
+		log.Printf("Action %q performed for channel %s (%d): channel banned from chat %d.", action.String(), user.FirstName, user.ID, chatID)
+		promPatternKickBannedCount.Inc()
+		return nil
+	}
+
+	var err error
+	switch action {
+	case opBan:
+		err = banUser(bot, chatID, user.ID)
+	case opKick:
+		err = kickUser(bot, chatID, user.ID)
+	case opTempBan:
+		if d == 0 {
+			d = defaultPatternTempBanTime
+		}
+		err = kickUserUntil(bot, chatID, user.ID, time.Now().Add(d))
+	case opMute:
+		if d == 0 {
+			d = defaultPatternMuteTime
 		}
+		err = muteUserUntil(bot, chatID, user.ID, time.Now().Add(d))
+	default:
+		return nil
 	}
-	return deleted
+
+	if err != nil {
+		log.Printf("Error performing action %q with username %q (%s %s): %v", action.String(), user.UserName, user.FirstName, user.LastName, err)
+		return err
+	}
+	log.Printf("Action %q performed for user %q (%s %s). Hasta la vista, baby...", action.String(), user.UserName, user.FirstName, user.LastName)
+	if action.removesUser() {
+		promPatternKickBannedCount.Inc()
+	}
+	return nil
+}
+
+// reportMessage reports a message to the admins on behalf of the bot, exactly
+// as if the bot had replied to it with /report.
+func (x *opBot) reportMessage(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) error {
+	update := tgbotapi.Update{
+		Message: &tgbotapi.Message{
+			From:           &bot.Self,
+			Chat:           msg.Chat,
+			ReplyToMessage: msg,
+		},
+	}
+	x.classifier.reported(fmt.Sprintf("%d:%d", msg.MessageID, msg.Chat.ID), classifierText(msg), time.Now())
+	return x.bans.banRequestHandler(bot, update)
 }
 
 // selfDestructMessage deletes a message in a chat after the specified amount of time.
@@ -494,18 +872,6 @@ func richMessage(m *tgbotapi.Message) bool {
 		m.Photo != nil || m.Video != nil || m.VideoNote != nil || m.Voice != nil)
 }
 
-// undesirableRichMessage returns true if the message is an undesirable rich
-// message for normal users.
-func undesirableRichMessage(m *tgbotapi.Message) bool {
-	// This should block the following messages types:
-	// - Audio/Voice: Basically an audio message. Voice has a different player.
-	// - VideoNote: The "round video" player.
-	// - Game: Couldn't find a direct reference to it.
-	// - Location/Venue: Protect users from accidentally sending their location or a Venue.
-	return m != nil && (m.Audio != nil || m.Voice != nil || m.VideoNote != nil ||
-		m.Game != nil || m.Location != nil || m.Venue != nil)
-}
-
 // isAdmin returns true if the user is a member of a given chat ID and has
 // administrator privileges.
 func isAdmin(bot getChatMemberer, chatID int64, userID int) (bool, error) {
@@ -521,6 +887,84 @@ func isAdmin(bot getChatMemberer, chatID int64, userID int) (bool, error) {
 	return (chatmember.IsAdministrator() || chatmember.IsCreator()), nil
 }
 
+// managedChats returns the chats explicitly configured in the bot. If none
+// are configured, the main group is used.
+func (x *opBot) managedChats() []tgbotapi.ChatConfig {
+	var chats []tgbotapi.ChatConfig
+	for _, cc := range x.config.Chats {
+		if cc.ID != 0 {
+			chats = append(chats, tgbotapi.ChatConfig{ChatID: cc.ID})
+		}
+	}
+	if len(chats) == 0 {
+		chats = append(chats, tgbotapi.ChatConfig{SuperGroupUsername: "@" + osProgramadoresGroup})
+	}
+	return chats
+}
+
+// isManagedChatAdmin returns true if the user is an administrator in any of
+// the chats managed by the bot.
+func (x *opBot) isManagedChatAdmin(bot getChatMemberer, userID int) (bool, error) {
+	var lastErr error
+	for _, chat := range x.managedChats() {
+		chatmember, err := bot.GetChatMember(tgbotapi.ChatConfigWithUser{
+			ChatID:             chat.ChatID,
+			SuperGroupUsername: chat.SuperGroupUsername,
+			UserID:             userID,
+		})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if chatmember.IsAdministrator() || chatmember.IsCreator() {
+			return true, nil
+		}
+	}
+	return false, lastErr
+}
+
+// isOwner returns true if the user is one of the owners in the configuration
+// or, if there are none, an administrator in every chat managed by the bot.
+func (x *opBot) isOwner(bot getChatMemberer, userID int) bool {
+	if len(x.config.Owners) > 0 {
+		return slices.Contains(x.config.Owners, userID)
+	}
+	for _, chat := range x.managedChats() {
+		chatmember, err := bot.GetChatMember(tgbotapi.ChatConfigWithUser{
+			ChatID:             chat.ChatID,
+			SuperGroupUsername: chat.SuperGroupUsername,
+			UserID:             userID,
+		})
+		if err != nil || !(chatmember.IsAdministrator() || chatmember.IsCreator()) {
+			return false
+		}
+	}
+	return true
+}
+
+// managedChatAdmins returns the administrators of all chats managed by the
+// bot, without duplicates or bots.
+func (x *opBot) managedChatAdmins(bot tgbotInterface) []tgbotapi.User {
+	seen := map[int]bool{}
+	var ret []tgbotapi.User
+
+	for _, chat := range x.managedChats() {
+		admins, err := bot.GetChatAdministrators(chat)
+		if err != nil {
+			log.Printf("Unable to get administrators for chat %v: %v", chat, err)
+			continue
+		}
+		for _, admin := range admins {
+			if admin.User == nil || admin.User.IsBot || seen[admin.User.ID] {
+				continue
+			}
+			seen[admin.User.ID] = true
+			ret = append(ret, *admin.User)
+		}
+	}
+	return ret
+}
+
 // isBanned returns true if the user was previously banned (kick/banned).
 func isBanned(bot getChatMemberer, chatID int64, userID int) (bool, error) {
 	q := tgbotapi.ChatConfigWithUser{
@@ -6,8 +6,10 @@ import (
 	"log"
 	"os"
 	"testing"
+	"time"
 
 	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+	"github.com/patrickmn/go-cache"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -36,16 +38,31 @@ func (m *MockTelebot) GetChatMember(c tgbotapi.ChatConfigWithUser) (tgbotapi.Cha
 	return args.Get(0).(tgbotapi.ChatMember), args.Error(1)
 }
 
+func (m *MockTelebot) GetFileDirectURL(fileID string) (string, error) {
+	args := m.Called(fileID)
+	return args.String(0), args.Error(1)
+}
+
 func (m *MockTelebot) GetUpdatesChan(config tgbotapi.UpdateConfig) (tgbotapi.UpdatesChannel, error) {
 	args := m.Called(config)
 	return args.Get(0).(tgbotapi.UpdatesChannel), args.Error(1)
 }
 
+func (m *MockTelebot) GetUserProfilePhotos(config tgbotapi.UserProfilePhotosConfig) (tgbotapi.UserProfilePhotos, error) {
+	args := m.Called(config)
+	return args.Get(0).(tgbotapi.UserProfilePhotos), args.Error(1)
+}
+
 func (m *MockTelebot) KickChatMember(config tgbotapi.KickChatMemberConfig) (tgbotapi.APIResponse, error) {
 	args := m.Called(config)
 	return args.Get(0).(tgbotapi.APIResponse), args.Error(1)
 }
 
+func (m *MockTelebot) RestrictChatMember(config tgbotapi.RestrictChatMemberConfig) (tgbotapi.APIResponse, error) {
+	args := m.Called(config)
+	return args.Get(0).(tgbotapi.APIResponse), args.Error(1)
+}
+
 func (m *MockTelebot) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
 	args := m.Called(c)
 	return args.Get(0).(tgbotapi.Message), args.Error(1)
@@ -302,7 +319,7 @@ func TestBanNewBots(t *testing.T) {
 		kickBots     bool     // Should we kick bots?
 		isBot        bool     // Is this user a bot?
 		username     string   // User name (or bot name)
-		botWhitelist []string // bot name whitelist.
+		botWhitelist []string // bot name or ID whitelist.
 		wantBan      bool     // Ban expected?
 	}{
 		// Kickbot enabled, Regular user (not bot): Do not ban.
@@ -322,6 +339,20 @@ func TestBanNewBots(t *testing.T) {
 			username:     "friend-bot",
 			botWhitelist: []string{"friend-bot"},
 		},
+		// Kickbot enabled, Bot, whitelisted by ID: No Ban.
+		{
+			kickBots:     true,
+			isBot:        true,
+			username:     "friend-bot",
+			botWhitelist: []string{"3333"},
+		},
+		// Kickbot enabled, Bot, whitelisted as @username: No Ban.
+		{
+			kickBots:     true,
+			isBot:        true,
+			username:     "friend-bot",
+			botWhitelist: []string{"@friend-bot"},
+		},
 		// Kickbot enabled, Bot, not whitelisted: Ban.
 		{
 			kickBots:     true,
@@ -330,6 +361,14 @@ func TestBanNewBots(t *testing.T) {
 			botWhitelist: []string{"friend-bot"},
 			wantBan:      true,
 		},
+		// Kickbot enabled, Bot, not whitelisted as @username: Ban.
+		{
+			kickBots:     true,
+			isBot:        true,
+			username:     "bad-bot",
+			botWhitelist: []string{"@friend-bot"},
+			wantBan:      true,
+		},
 		// Kickbot disabled, Bot: Do not ban.
 		{
 			isBot: true,
@@ -343,15 +382,21 @@ func TestBanNewBots(t *testing.T) {
 				KickBots:     tt.kickBots,
 				BotWhitelist: tt.botWhitelist,
 			},
+			botInviters: cache.New(time.Hour, time.Hour),
 		}
 
-		// test Update instance.
+		// test Update instance. Updates about new members have no Message.
+		newUser := tgbotapi.User{
+			ID:       userID,
+			UserName: tt.username,
+			IsBot:    tt.isBot,
+		}
 		mockUpdate := tgbotapi.Update{
 			UpdateID: int(chatID),
-			Message: &tgbotapi.Message{
-				Chat: &tgbotapi.Chat{
-					ID: chatID,
-				},
+			ChatMember: &tgbotapi.ChatMemberUpdate{
+				Chat:          &tgbotapi.Chat{ID: chatID},
+				From:          &tgbotapi.User{ID: 4444},
+				NewChatMember: &tgbotapi.NewChatMember{User: &newUser, Status: "member"},
 			},
 		}
 
@@ -363,12 +408,12 @@ func TestBanNewBots(t *testing.T) {
 			},
 		}
 		mockTelebot.On("KickChatMember", wantKick).Return(tgbotapi.APIResponse{}, nil).Once()
+		// The admins are notified about every bot.
+		mockTelebot.On("GetChatAdministrators", tgbotapi.ChatConfig{ChatID: chatID}).Return([]tgbotapi.ChatMember{}, nil)
 
-		mockOpBot.banNewBots(mockTelebot, mockUpdate, tgbotapi.User{
-			ID:       userID,
-			UserName: tt.username,
-			IsBot:    tt.isBot,
-		})
+		if got := mockOpBot.banNewBots(mockTelebot, mockUpdate, newUser); got != tt.isBot {
+			t.Errorf("banNewBots: got %v, want %v", got, tt.isBot)
+		}
 
 		// Should a ban have happened?
 		if tt.wantBan {
@@ -377,7 +422,89 @@ func TestBanNewBots(t *testing.T) {
 		} else {
 			mockTelebot.AssertNumberOfCalls(t, "KickChatMember", 0)
 		}
+		if !tt.isBot {
+			mockTelebot.AssertNumberOfCalls(t, "GetChatAdministrators", 0)
+		}
+	}
+}
+
+func TestBotInviter(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	mockTelebot := &MockTelebot{}
+	mockOpBot := opBot{
+		config: botConfig{
+			KickBots:         true,
+			BotInviterLimit:  2,
+			BotInviterAction: botInviterWarn,
+		},
+		botInviters: cache.New(time.Hour, time.Hour),
+		reputation:  newReputation(),
+	}
+	inviter := tgbotapi.User{ID: 4444, UserName: "inviter"}
+
+	mockTelebot.On("KickChatMember", mock.Anything).Return(tgbotapi.APIResponse{}, nil)
+	mockTelebot.On("GetChatAdministrators", mock.Anything).Return([]tgbotapi.ChatMember{}, nil)
+	mockTelebot.On("GetChatMember", mock.Anything).Return(tgbotapi.ChatMember{Status: "member"}, nil)
+	mockTelebot.On("Send", mock.Anything).Return(tgbotapi.Message{}, nil)
+
+	for i := 1; i <= 3; i++ {
+		bot := tgbotapi.User{ID: 9000 + i, UserName: "spam_bot", IsBot: true}
+		mockOpBot.banNewBots(mockTelebot, tgbotapi.Update{
+			ChatMember: &tgbotapi.ChatMemberUpdate{
+				Chat:          &tgbotapi.Chat{ID: chatID},
+				From:          &inviter,
+				NewChatMember: &tgbotapi.NewChatMember{User: &bot, Status: "member"},
+			},
+		}, bot)
+	}
+
+	// Only the third bot gets the inviter warned.
+	mockTelebot.AssertNumberOfCalls(t, "KickChatMember", 3)
+	mockTelebot.AssertNumberOfCalls(t, "Send", 1)
+	if got := mockOpBot.reputationScore(chatID, inviter.ID); got != repWarning {
+		t.Errorf("reputationScore: got %d, want %d after a warning", got, repWarning)
+	}
+}
+
+func TestProbationRulesEditedMessage(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	mockOpBot := opBot{
+		probation:           newProbation(),
+		quarantine:          newQuarantine(),
+		newUserWarningCache: cache.New(time.Hour, time.Hour),
+	}
+	mockOpBot.probation.joined(chatID, userID, time.Now(), time.Hour)
+
+	// Edited messages arrive without a Message in the update.
+	mockUpdate := tgbotapi.Update{
+		UpdateID: int(chatID),
+		EditedMessage: &tgbotapi.Message{
+			From: &tgbotapi.User{
+				ID: userID,
+			},
+			Chat: &tgbotapi.Chat{
+				ID: chatID,
+			},
+			MessageID: msgID,
+			Photo:     &[]tgbotapi.PhotoSize{},
+		},
+	}
+
+	wantDeleteMsgConfig := tgbotapi.DeleteMessageConfig{
+		ChatID:    chatID,
+		MessageID: msgID,
+	}
+
+	mockTelebot := &MockTelebot{}
+	mockTelebot.On("Send", mock.Anything).Return(tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}}, nil).Once()
+	mockTelebot.On("DeleteMessage", wantDeleteMsgConfig).Return(tgbotapi.APIResponse{}, nil).Once()
+
+	if _, ok := mockOpBot.handledProbationRules(mockTelebot, mockUpdate.EditedMessage); !ok {
+		t.Errorf("handledProbationRules: message from new user not deleted")
 	}
+	mockTelebot.AssertExpectations(t)
 }
 
 func setup() error {
@@ -393,3 +520,28 @@ func TestMain(m *testing.M) {
 	code := m.Run()
 	os.Exit(code)
 }
+
+func TestIsOwner(t *testing.T) {
+	mockTelebot := &MockTelebot{}
+	chatAdmin := tgbotapi.ChatMember{Status: "administrator"}
+	mockTelebot.On("GetChatMember", tgbotapi.ChatConfigWithUser{ChatID: chatID, UserID: userID}).Return(chatAdmin, nil)
+	mockTelebot.On("GetChatMember", tgbotapi.ChatConfigWithUser{ChatID: chatID + 1, UserID: userID}).Return(chatAdmin, nil)
+	mockTelebot.On("GetChatMember", tgbotapi.ChatConfigWithUser{ChatID: chatID, UserID: userID + 1}).Return(chatAdmin, nil)
+	mockTelebot.On("GetChatMember", tgbotapi.ChatConfigWithUser{ChatID: chatID + 1, UserID: userID + 1}).Return(tgbotapi.ChatMember{Status: "member"}, nil)
+
+	x := opBot{config: botConfig{Chats: []chatConfig{{ID: chatID}, {ID: chatID + 1}}}}
+
+	// Without owners, only the admins of every managed chat qualify.
+	if !x.isOwner(mockTelebot, userID) {
+		t.Errorf("isOwner: got false for an admin of every chat")
+	}
+	if x.isOwner(mockTelebot, userID+1) {
+		t.Errorf("isOwner: got true for an admin of a single chat")
+	}
+
+	// Configured owners replace the check.
+	x.config.Owners = []int{userID + 1}
+	if x.isOwner(mockTelebot, userID) || !x.isOwner(mockTelebot, userID+1) {
+		t.Errorf("isOwner: configured owners not honored")
+	}
+}
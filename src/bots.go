@@ -0,0 +1,139 @@
+// Bots added to the chats, and the users who add them.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+)
+
+const (
+	// Actions taken against users who add too many bots.
+	botInviterWarn = "warn"
+	botInviterMute = "mute"
+
+	// Default period in which bots added by the same user are counted.
+	defaultBotInviterWindow = 24 * time.Hour
+)
+
+// botWhitelisted returns true if the bot username (with or without "@") or
+// user ID is in the whitelist.
+func botWhitelisted(user tgbotapi.User, whitelist []string) bool {
+	for _, w := range whitelist {
+		w = strings.TrimPrefix(strings.TrimSpace(w), "@")
+		if w == strconv.Itoa(user.ID) || user.UserName != "" && strings.EqualFold(w, user.UserName) {
+			return true
+		}
+	}
+	return false
+}
+
+// newMemberInviter returns the user who added the new member to the chat, or
+// nil if the member joined by themselves.
+func newMemberInviter(cm *tgbotapi.ChatMemberUpdate) *tgbotapi.User {
+	if cm.From == nil || cm.NewChatMember == nil || cm.NewChatMember.User == nil || cm.From.ID == cm.NewChatMember.User.ID {
+		return nil
+	}
+	return cm.From
+}
+
+// validateBotInviterAction checks the action taken against users who add too
+// many bots.
+func validateBotInviterAction(action string) error {
+	switch action {
+	case "", botInviterWarn, botInviterMute:
+		return nil
+	}
+	return fmt.Errorf("unknown bot_inviter_action %q (valid: %s, %s)", action, botInviterWarn, botInviterMute)
+}
+
+// banNewBots bans the user if it is a bot and not in our bot whitelist, and
+// tells the admins who added the bot. Returns true if the user is a bot. Due
+// to the way telegram works, this only works for supergroups.
+func (x *opBot) banNewBots(bot tgbotInterface, update tgbotapi.Update, user tgbotapi.User) bool {
+	// Bots only.
+	if !user.IsBot {
+		return false
+	}
+	chatID := update.ChatMember.Chat.ID
+	inviter := newMemberInviter(update.ChatMember)
+
+	// Note: It's safe to use user.UserName here as bots should always have a name.
+	var outcome string
+	switch {
+	case !x.config.KickBots:
+		outcome = "allowed"
+	case botWhitelisted(user, x.config.BotWhitelist):
+		log.Printf("Whitelisted bot %q has joined. Doing nothing.", user.UserName)
+		outcome = "whitelisted"
+	default:
+		if err := banUser(bot, chatID, user.ID); err != nil {
+			log.Printf("Error attempting to ban bot named %q: %v", user.UserName, err)
+			outcome = "ban_failed"
+			break
+		}
+		log.Printf("Banned bot %q. Hasta la vista, baby...", user.UserName)
+		outcome = "banned"
+	}
+	promNewBotCount.WithLabelValues(outcome).Inc()
+
+	by := "an unknown user"
+	if inviter != nil {
+		by = fmt.Sprintf("%s (uid=%d)", formatName(*inviter), inviter.ID)
+		log.Printf("Bot %q (uid=%d) added to chat %d by %s (uid=%d)", user.UserName, user.ID, chatID, formatName(*inviter), inviter.ID)
+	}
+	notifyChatAdmins(bot, chatID, []string{fmt.Sprintf("Bot @%s (uid=%d) added to %s by %s: %s.",
+		markdownEscape(user.UserName), user.ID, markdownEscape(update.ChatMember.Chat.Title), by, strings.ReplaceAll(outcome, "_", " "))})
+
+	if outcome == "banned" && inviter != nil {
+		x.checkBotInviter(bot, chatID, *inviter)
+	}
+	return true
+}
+
+// checkBotInviter counts the banned bots added by the user, and warns or mutes
+// users who add more than bot_inviter_limit of them within bot_inviter_window.
+// Admins are exempt.
+func (x *opBot) checkBotInviter(bot tgbotInterface, chatID int64, inviter tgbotapi.User) {
+	limit := x.config.BotInviterLimit
+	if limit <= 0 || x.config.BotInviterAction == "" {
+		return
+	}
+	window := x.config.BotInviterWindow.Duration
+	if window == 0 {
+		window = defaultBotInviterWindow
+	}
+
+	key := chatUserKey(chatID, inviter.ID)
+	// Add only sets the expiration when the user adds the first bot.
+	x.botInviters.Add(key, 0, window)
+	count, err := x.botInviters.IncrementInt(key, 1)
+	if err != nil || count <= limit {
+		return
+	}
+	if admin, err := isAdmin(bot, chatID, inviter.ID); err != nil || admin {
+		return
+	}
+
+	promBotInviterCount.WithLabelValues(x.config.BotInviterAction).Inc()
+	log.Printf("User %s (uid=%d) added %d bots to chat %d. Action: %s", formatName(inviter), inviter.ID, count, chatID, x.config.BotInviterAction)
+
+	switch x.config.BotInviterAction {
+	case botInviterWarn:
+		if _, err := sendMessage(bot, chatID, fmt.Sprintf(T("bot_inviter_warning"), nameRef(inviter))); err != nil {
+			log.Printf("Error warning user %s (uid=%d): %v", formatName(inviter), inviter.ID, err)
+		}
+		x.reputation.warned(chatID, inviter.ID)
+	case botInviterMute:
+		if err := muteUserUntil(bot, chatID, inviter.ID, time.Now().Add(defaultPatternMuteTime)); err != nil {
+			log.Printf("Error muting user %s (uid=%d): %v", formatName(inviter), inviter.ID, err)
+		}
+	}
+	// Start counting again.
+	x.botInviters.Delete(key)
+}
@@ -45,6 +45,12 @@ func (x *opBot) handleCallbackQuery(bot *tgbotapi.BotAPI, update tgbotapi.Update
 		// We pass `true' as parameter to indicate we want to ban the user as well.
 		if x.bans.deleteMessageFromBanRequest(bot, update.CallbackQuery.From, requestID, true) != nil {
 			responseMessage = T("delete_and_ban_fail")
+		} else if report, ok := x.bans.banRequestInfo(requestID); ok {
+			if err := x.globalBan(bot, int(report.Author), fmt.Sprintf("banned by %s: %s", formatName(*update.CallbackQuery.From), report.Text), report.ChatID); err != nil {
+				log.Printf("Not adding uid=%d to the global banlist: %v", report.Author, err)
+			}
+			x.confirmReport(report)
+			x.learnFromReport(requestID, report)
 		}
 		answerCallbackWithNotification(bot, update.CallbackQuery.ID, responseMessage)
 	case strings.HasPrefix(data, "delete-message-"):
@@ -57,6 +63,24 @@ func (x *opBot) handleCallbackQuery(bot *tgbotapi.BotAPI, update tgbotapi.Update
 		// We pass `false' here to indicate we don't want to also ban the user.
 		if x.bans.deleteMessageFromBanRequest(bot, update.CallbackQuery.From, requestID, false) != nil {
 			responseMessage = T("delete_message_fail")
+		} else if report, ok := x.bans.banRequestInfo(requestID); ok {
+			x.confirmReport(report)
+			x.learnFromReport(requestID, report)
+		}
+		answerCallbackWithNotification(bot, update.CallbackQuery.ID, responseMessage)
+	case strings.HasPrefix(data, "block-image-"):
+		requestID, err := extractRequestID(data, "block-image", "malformed block image request callback query")
+		if err != nil {
+			answerCallbackWithNotification(bot, update.CallbackQuery.ID, T("callback_invalid_request"))
+			break
+		}
+		responseMessage := T("block_image_success")
+		report, ok := x.bans.banRequestInfo(requestID)
+		if !ok || report.PhotoID == "" {
+			responseMessage = T("block_image_fail")
+		} else if _, added, err := x.blockImage(bot, report.PhotoID, *update.CallbackQuery.From, report.ChatID); err != nil || !added {
+			log.Printf("Unable to block the image in request %s: %v", requestID, err)
+			responseMessage = T("block_image_fail")
 		}
 		answerCallbackWithNotification(bot, update.CallbackQuery.ID, responseMessage)
 	}
@@ -29,9 +29,16 @@ func init() {
 	captchaRegex = regexp.MustCompile(`\d{4}`)
 }
 
+// Code of pending captchas not sent yet. The captcha is sent in reply to the
+// first message of the user (E.g. users restricted during a raid).
+const captchaNotSent = -1
+
 type botCaptcha struct {
 	code       int
 	expiration time.Time
+	// Chat the user joined. The captcha itself may be sent elsewhere (E.g.
+	// in private, during a raid).
+	chatID int64
 }
 
 // pendingCaptchaType holds a list of UserIDs that have yet to be validated by
@@ -58,6 +65,17 @@ func (x *pendingCaptchaType) get(userID int) (botCaptcha, bool) {
 	return exp, ok
 }
 
+// setChat sets the chat the user joined, if the user is pending captcha
+// validation.
+func (x *pendingCaptchaType) setChat(userID int, chatID int64) {
+	x.Lock()
+	if captcha, ok := x.users[userID]; ok {
+		captcha.chatID = chatID
+		x.users[userID] = captcha
+	}
+	x.Unlock()
+}
+
 // del removes a userID from the list of users pending captcha validation.
 func (x *pendingCaptchaType) del(userID int) {
 	x.Lock()
@@ -73,19 +91,19 @@ func newPendingCaptchaType() *pendingCaptchaType {
 
 // sendCaptcha adds the user to the map of users that have not yet responded to
 // the captcha and sends a random captcha image as a reply to the message.
-func (x *opBot) sendCaptcha(bot tgbotInterface, chatID int64, messageID int, user tgbotapi.User) {
+func (x *opBot) sendCaptcha(bot tgbotInterface, chatID int64, messageID int, user tgbotapi.User) error {
 	promCaptchaCount.Inc()
 
 	// Do not send captcha messages to bots (belt and suspenders...)
 	if user.IsBot {
-		return
+		return nil
 	}
 	name := nameRef(user)
 
 	// Random captcha, expires in x.captchaTime duration.
 	code := rand.Int() % 10000
 
-	x.markAsPendingCaptcha(user, code)
+	x.markAsPendingCaptcha(user, chatID, code)
 
 	// Send the captcha message. Set to autodestruct in captcha_time + 10s.
 	log.Printf("Sending captcha %04.4d to user %s (uid=%d)", code, name, user.ID)
@@ -93,16 +111,17 @@ func (x *opBot) sendCaptcha(bot tgbotInterface, chatID int64, messageID int, use
 	fb, err := genCaptchaImage(code)
 	if err != nil {
 		log.Printf("Warning: Unable to generate captcha image. Ignoring")
-		return
+		return err
 	}
 	// Send.
 	msg, err := sendPhotoReply(bot, chatID, messageID, fb, fmt.Sprintf(T("enter_captcha"), name))
 	if err != nil {
 		log.Printf("Warning: Unable to send captcha message: %v", err)
-		return
+		return err
 	}
 	// Clean message after captcha duration + 10 seconds.
 	selfDestructMessage(bot, msg.Chat.ID, msg.MessageID, x.captchaTime+time.Duration(10*time.Second))
+	return nil
 }
 
 // genCaptchaImage generates a captcha image based on the captcha code. It
@@ -157,11 +176,12 @@ func (x *opBot) captchaReaper(bot tgbotInterface, chatID int64, user tgbotapi.Us
 }
 
 // markAsPendingCaptcha marks the user status as pending Captcha response.
-func (x *opBot) markAsPendingCaptcha(user tgbotapi.User, code int) {
+func (x *opBot) markAsPendingCaptcha(user tgbotapi.User, chatID int64, code int) {
 	log.Printf("Adding user to the pending-captcha list: %q, uid=%d\n", formatName(user), user.ID)
 	x.pendingCaptcha.set(user.ID, botCaptcha{
 		code:       code,
 		expiration: time.Now().Add(x.captchaTime),
+		chatID:     chatID,
 	})
 }
 
@@ -226,6 +246,7 @@ func captchaResendRequest(s string) bool {
 func (x *opBot) handleCaptchaFailure(bot tgbotInterface, chatID int64, messageID int, user tgbotapi.User) {
 	name := nameRef(user)
 	fails := x.captchaFails.increment(user.ID)
+	x.reputation.captchaFailed(chatID, user.ID)
 
 	log.Printf("User %s (uid=%d) failed captcha. Total fails: %d", name, user.ID, fails)
 
@@ -242,21 +263,32 @@ func (x *opBot) handleCaptchaFailure(bot tgbotInterface, chatID int64, messageID
 		return
 	}
 
+	// Do not add to the flood during a raid.
+	lockdown := x.raids.inLockdown(chatID)
+	notify := func(text string) {
+		if !lockdown {
+			sendMessage(bot, chatID, text)
+		}
+	}
+
 	switch fails {
 	case 1:
-		sendMessage(bot, chatID, fmt.Sprintf(T("captcha_fail_1"), name))
+		notify(fmt.Sprintf(T("captcha_fail_1"), name))
 		kickUser(bot, chatID, user.ID)
 		unBanUser(bot, chatID, user.ID)
 	case 2:
-		sendMessage(bot, chatID, fmt.Sprintf(T("captcha_fail_2"), name))
+		notify(fmt.Sprintf(T("captcha_fail_2"), name))
 		kickUser(bot, chatID, user.ID)
 		unBanUser(bot, chatID, user.ID)
 	case 3:
-		sendMessage(bot, chatID, fmt.Sprintf(T("captcha_fail_3"), name))
+		notify(fmt.Sprintf(T("captcha_fail_3"), name))
 		kickUserUntil(bot, chatID, user.ID, time.Now().Add(24*time.Hour))
 	default:
-		sendMessage(bot, chatID, fmt.Sprintf(T("captcha_fail_max"), name))
+		notify(fmt.Sprintf(T("captcha_fail_max"), name))
 		banUser(bot, chatID, user.ID)
+		if err := x.globalBan(bot, user.ID, fmt.Sprintf("failed the captcha %d times", fails), chatID); err != nil {
+			log.Printf("Not adding uid=%d to the global banlist: %v", user.ID, err)
+		}
 	}
 }
 
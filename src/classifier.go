@@ -0,0 +1,432 @@
+// Naive Bayes spam classifier, trained from the decisions of the admins.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+)
+
+const (
+	// File to store the classifier model.
+	classifierDB = "classifier.json"
+
+	// Classes of messages.
+	classHam  = 0
+	classSpam = 1
+
+	// Weight of the samples learned from reports the admins did not act upon
+	// (weak ham samples), and how old reports must be to be considered
+	// ignored.
+	classifierIgnoredReportWeight = 0.25
+	classifierIgnoredReportAge    = 48 * time.Hour
+
+	// The classifier only scores messages once it has learned this many
+	// samples of each class.
+	classifierMinSamples = 20
+
+	// Tokens shorter or longer than these (in runes) are ignored.
+	classifierMinTokenLen = 2
+	classifierMaxTokenLen = 30
+
+	// Rare tokens are dropped when the vocabulary grows beyond this size.
+	classifierMaxTokens = 100000
+
+	// Default minimum number of distinct tokens in classified messages.
+	defaultClassifierMinTokens = 3
+)
+
+// classLabels holds the names of the classes, as used in the metrics and in
+// the training files.
+var classLabels = [2]string{classHam: "ham", classSpam: "spam"}
+
+// bayesModel holds the token counts of a multinomial Naive Bayes classifier.
+// Counts are weighted, so weak samples count less.
+type bayesModel struct {
+	// Number of samples learned in each class.
+	Samples [2]float64 `json:"samples"`
+	// Number of tokens learned in each class.
+	Total [2]float64 `json:"total"`
+	// Number of samples of each class containing each token.
+	Tokens map[string][2]float64 `json:"tokens"`
+}
+
+// classifierReport holds a reported message not yet handled by the admins.
+type classifierReport struct {
+	Text string    `json:"text"`
+	Time time.Time `json:"time"`
+}
+
+// classifierState holds the state of the classifier saved to the disk.
+type classifierState struct {
+	Model bayesModel `json:"model"`
+	// Reports maps the report request ID ("messageID:chatID") to the
+	// reported message.
+	Reports map[string]classifierReport `json:"reports"`
+}
+
+// classifier holds the model and the pending reports. The model changes on
+// every sample learned, so it is saved periodically instead of on every
+// change.
+type classifier struct {
+	sync.RWMutex
+	classifierState
+	classifierDB string
+	dirty        bool
+}
+
+// newClassifier creates a new, untrained, classifier.
+func newClassifier() *classifier {
+	return &classifier{
+		classifierState: classifierState{
+			Model:   bayesModel{Tokens: map[string][2]float64{}},
+			Reports: map[string]classifierReport{},
+		},
+		classifierDB: classifierDB,
+	}
+}
+
+// loadClassifier loads the model and the pending reports from the disk.
+func (c *classifier) loadClassifier() error {
+	c.Lock()
+	defer c.Unlock()
+
+	err := readJSONFromDataDir(&c.classifierState, c.classifierDB)
+	if c.Model.Tokens == nil {
+		c.Model.Tokens = map[string][2]float64{}
+	}
+	if c.Reports == nil {
+		c.Reports = map[string]classifierReport{}
+	}
+	return err
+}
+
+// save saves the classifier to the disk. Locks are assumed to be taken care
+// of by the caller.
+func (c *classifier) save() error {
+	if err := safeWriteJSON(c.classifierState, c.classifierDB); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// autosave saves the classifier periodically, if it changed.
+func (c *classifier) autosave(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			c.flush()
+		}
+	}()
+}
+
+// flush saves the classifier, if it changed.
+func (c *classifier) flush() {
+	c.Lock()
+	defer c.Unlock()
+	if !c.dirty {
+		return
+	}
+	if err := c.save(); err != nil {
+		log.Printf("Error saving classifier: %v", err)
+	}
+}
+
+// classifierTokens returns the distinct tokens in the text: the words of the
+// normalized text, in lowercase.
+func classifierTokens(text string) []string {
+	seen := map[string]bool{}
+	var tokens []string
+	words := strings.FieldsFunc(strings.ToLower(normalizeText(text)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range words {
+		if n := len([]rune(w)); n < classifierMinTokenLen || n > classifierMaxTokenLen || seen[w] {
+			continue
+		}
+		seen[w] = true
+		tokens = append(tokens, w)
+	}
+	return tokens
+}
+
+// classifierText returns the text of the message used by the classifier.
+func classifierText(msg *tgbotapi.Message) string {
+	return strings.TrimSpace(msg.Text + " " + msg.Caption)
+}
+
+// learn adds a sample of the class to the model. Locks are assumed to be
+// taken care of by the caller.
+func (m *bayesModel) learn(text string, class int, weight float64) bool {
+	tokens := classifierTokens(text)
+	if len(tokens) == 0 {
+		return false
+	}
+	m.Samples[class] += weight
+	m.Total[class] += weight * float64(len(tokens))
+	for _, t := range tokens {
+		counts := m.Tokens[t]
+		counts[class] += weight
+		m.Tokens[t] = counts
+	}
+	m.prune()
+	return true
+}
+
+// prune drops the tokens seen only once when the vocabulary gets too large.
+func (m *bayesModel) prune() {
+	if len(m.Tokens) <= classifierMaxTokens {
+		return
+	}
+	for t, counts := range m.Tokens {
+		if counts[classHam]+counts[classSpam] <= 1 {
+			delete(m.Tokens, t)
+		}
+	}
+}
+
+// ready returns true if the model learned enough samples of both classes.
+func (m *bayesModel) ready() bool {
+	return m.Samples[classHam] >= classifierMinSamples && m.Samples[classSpam] >= classifierMinSamples
+}
+
+// spamProbability returns the probability (0 to 1) of the tokens belonging to
+// a spam message, using Laplace smoothing. Tokens never seen are ignored.
+func (m *bayesModel) spamProbability(tokens []string) float64 {
+	vocabulary := float64(len(m.Tokens))
+	var logp [2]float64
+	for class := range logp {
+		logp[class] = math.Log((m.Samples[class] + 1) / (m.Samples[classHam] + m.Samples[classSpam] + 2))
+	}
+	for _, t := range tokens {
+		counts, ok := m.Tokens[t]
+		if !ok {
+			continue
+		}
+		for class := range logp {
+			logp[class] += math.Log((counts[class] + 1) / (m.Total[class] + vocabulary))
+		}
+	}
+	return 1 / (1 + math.Exp(logp[classHam]-logp[classSpam]))
+}
+
+// learn adds a sample of the class to the model.
+func (c *classifier) learn(text string, class int, weight float64) {
+	c.Lock()
+	defer c.Unlock()
+
+	if !c.Model.learn(text, class, weight) {
+		return
+	}
+	promClassifierSamplesCount.WithLabelValues(classLabels[class]).Inc()
+	c.dirty = true
+}
+
+// classify returns the probability of the text being spam, and whether the
+// classifier is able to tell (the model is ready and the text has at least
+// minTokens distinct tokens).
+func (c *classifier) classify(text string, minTokens int) (float64, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	tokens := classifierTokens(text)
+	if !c.Model.ready() || len(tokens) < minTokens {
+		return 0, false
+	}
+	return c.Model.spamProbability(tokens), true
+}
+
+// reported records a message reported to the admins. If the admins do not
+// act upon it, the message becomes a weak ham sample.
+func (c *classifier) reported(requestID, text string, now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	if _, ok := c.Reports[requestID]; ok || strings.TrimSpace(text) == "" {
+		return
+	}
+	c.Reports[requestID] = classifierReport{Text: text, Time: now}
+	c.dirty = true
+}
+
+// confirmed learns a reported message removed by the admins as spam. The text
+// is only used if the report was not recorded.
+func (c *classifier) confirmed(requestID, text string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if r, ok := c.Reports[requestID]; ok {
+		text = r.Text
+		delete(c.Reports, requestID)
+	}
+	if c.Model.learn(text, classSpam, 1) {
+		promClassifierSamplesCount.WithLabelValues(classLabels[classSpam]).Inc()
+	}
+	c.dirty = true
+}
+
+// expireReports learns the reports older than maxAge as weak ham samples, as
+// the admins did not act upon them.
+func (c *classifier) expireReports(now time.Time, maxAge time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	var expired int
+	for id, r := range c.Reports {
+		if now.Sub(r.Time) < maxAge {
+			continue
+		}
+		if c.Model.learn(r.Text, classHam, classifierIgnoredReportWeight) {
+			promClassifierSamplesCount.WithLabelValues(classLabels[classHam]).Inc()
+		}
+		delete(c.Reports, id)
+		expired++
+	}
+	if expired > 0 {
+		log.Printf("Classifier learned %d ignored reports as ham", expired)
+		c.dirty = true
+	}
+}
+
+// autoExpireReports expires the ignored reports periodically.
+func (c *classifier) autoExpireReports(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			c.expireReports(time.Now(), classifierIgnoredReportAge)
+		}
+	}()
+}
+
+// action returns the threshold with the highest percent not above the
+// probability, if any.
+func (c classifierConfig) action(probability float64) (classifierThreshold, bool) {
+	thresholds := append([]classifierThreshold{}, c.Thresholds...)
+	sort.Slice(thresholds, func(i, j int) bool { return thresholds[i].Percent > thresholds[j].Percent })
+
+	for _, t := range thresholds {
+		if probability*100 >= float64(t.Percent) {
+			return t, true
+		}
+	}
+	return classifierThreshold{}, false
+}
+
+// handledClassifier classifies messages from users with a low reputation
+// score, and performs the action configured for the spam probability, if
+// any. Returns true if the message was removed.
+func (x *opBot) handledClassifier(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) bool {
+	cfg := x.config.forChat(msg.Chat.ID).Classifier
+	if !cfg.Enabled || msg.From == nil || isPrivateChat(msg.Chat) {
+		return false
+	}
+	if x.reputationScore(msg.Chat.ID, msg.From.ID) > cfg.MaxReputation {
+		return false
+	}
+
+	minTokens := cfg.MinTokens
+	if minTokens == 0 {
+		minTokens = defaultClassifierMinTokens
+	}
+	probability, ok := x.classifier.classify(classifierText(msg), minTokens)
+	if !ok {
+		return false
+	}
+	threshold, ok := cfg.action(probability)
+	if !ok {
+		return false
+	}
+
+	// As with patterns, a threshold without a (valid) action simply deletes
+	// the message.
+	action := actionFromString(threshold.Action)
+	if action == opNoAction {
+		action = opDelete
+	}
+	log.Printf("Message %d from user %s (uid=%d) in chat %d classified as spam (%.0f%%), action: %v", msg.MessageID, formatName(*msg.From), msg.From.ID, msg.Chat.ID, probability*100, action)
+	promClassifierActionCount.WithLabelValues(strings.ToLower(action.String())).Inc()
+
+	if err := x.performPatternAction(bot, msg, action, threshold.Duration.Duration, fmt.Sprintf("classifier %.0f%%", probability*100)); err != nil {
+		log.Printf("Error handling classified message: %v", err)
+	}
+	return action.deletesMessage()
+}
+
+// learnFromReport learns a report handled by the admins as spam.
+func (x *opBot) learnFromReport(requestID string, report banRequest) {
+	x.classifier.confirmed(requestID, report.Text)
+}
+
+// trainSample is a labeled sample in the files used to train the classifier.
+type trainSample struct {
+	Text string `json:"text"`
+	// "spam" or "ham".
+	Label string `json:"label"`
+}
+
+// trainClassifier trains the classifier with the labeled samples in the JSONL
+// files, one JSON object per line (E.g. {"text": "...", "label": "spam"}).
+// The model in the data directory is updated.
+func trainClassifier(files []string) error {
+	if len(files) == 0 {
+		return fmt.Errorf("usage: op-bot train <file.jsonl>...")
+	}
+
+	c := newClassifier()
+	if err := c.loadClassifier(); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error loading classifier: %v", err)
+	}
+
+	var counts [2]int
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 1<<20)
+		for line := 1; scanner.Scan(); line++ {
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+			var s trainSample
+			if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
+				f.Close()
+				return fmt.Errorf("%s:%d: %v", file, line, err)
+			}
+			class := classHam
+			switch s.Label {
+			case classLabels[classSpam]:
+				class = classSpam
+			case classLabels[classHam]:
+			default:
+				f.Close()
+				return fmt.Errorf("%s:%d: unknown label %q (valid: spam, ham)", file, line, s.Label)
+			}
+			if c.Model.learn(s.Text, class, 1) {
+				counts[class]++
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+	}
+
+	if err := c.save(); err != nil {
+		return fmt.Errorf("error saving classifier: %v", err)
+	}
+	log.Printf("Classifier trained with %d spam and %d ham samples (%.0f spam, %.0f ham, %d tokens in total)",
+		counts[classSpam], counts[classHam], c.Model.Samples[classSpam], c.Model.Samples[classHam], len(c.Model.Tokens))
+	return nil
+}
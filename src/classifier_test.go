@@ -0,0 +1,152 @@
+// Unit tests for the classifier module.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	spamSamples = []string{
+		"Earn %d dollars a day working from home, message me now",
+		"Crypto investment with guaranteed profit of %d%%, contact me in private",
+		"Make money fast: %d USDT per week with our trading bot, join now",
+	}
+	hamSamples = []string{
+		"How do I declare a slice with %d elements in Go?",
+		"The compiler says the function on line %d is undefined, any idea?",
+		"I read the chapter %d of the book about pointers and interfaces",
+	}
+)
+
+// trainingSet returns n samples of each class built from the templates.
+func trainingSet(n int) (spam, ham []string) {
+	for i := 0; i < n; i++ {
+		spam = append(spam, fmt.Sprintf(spamSamples[i%len(spamSamples)], i))
+		ham = append(ham, fmt.Sprintf(hamSamples[i%len(hamSamples)], i))
+	}
+	return spam, ham
+}
+
+func TestClassifier(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	c := newClassifier()
+	spam, ham := trainingSet(classifierMinSamples)
+	for i := range spam {
+		c.learn(spam[i], classSpam, 1)
+		if i < len(ham)-1 {
+			c.learn(ham[i], classHam, 1)
+		}
+	}
+
+	// Not enough ham samples yet.
+	if _, ok := c.classify("earn money from home now", 1); ok {
+		t.Errorf("classify: got a probability from a model not ready")
+	}
+	c.learn(ham[len(ham)-1], classHam, 1)
+
+	caseTests := []struct {
+		text     string
+		wantSpam bool
+		wantOK   bool
+	}{
+		{text: "Earn money from home, message me", wantSpam: true, wantOK: true},
+		{text: "Guaranteed crypto profit, contact me", wantSpam: true, wantOK: true},
+		{text: "How do I declare an interface in Go?", wantSpam: false, wantOK: true},
+		{text: "The compiler says it is undefined", wantSpam: false, wantOK: true},
+		// Too few words.
+		{text: "crypto", wantOK: false},
+	}
+	for _, tt := range caseTests {
+		p, ok := c.classify(tt.text, defaultClassifierMinTokens)
+		if ok != tt.wantOK || ok && (p > 0.5) != tt.wantSpam {
+			t.Errorf("classify(%q): got %.2f, %v, want spam = %v, ok = %v", tt.text, p, ok, tt.wantSpam, tt.wantOK)
+		}
+	}
+
+	// Reports handled by the admins are spam, the ones left alone are weak
+	// ham.
+	now := time.Now()
+	c.reported("1:-1001", "Free airdrop, claim your tokens", now.Add(-time.Hour))
+	c.reported("2:-1001", "Is this group about Go?", now.Add(-72*time.Hour))
+	c.confirmed("1:-1001", "")
+	c.expireReports(now, classifierIgnoredReportAge)
+	if len(c.Reports) != 0 {
+		t.Errorf("expireReports: got pending reports %v, want none", c.Reports)
+	}
+	if got, want := c.Model.Samples, [2]float64{classifierMinSamples + classifierIgnoredReportWeight, classifierMinSamples + 1}; got != want {
+		t.Errorf("samples: got %v, want %v", got, want)
+	}
+
+	// The model survives a reload, once flushed.
+	c.flush()
+	reloaded := newClassifier()
+	if err := reloaded.loadClassifier(); err != nil {
+		t.Fatalf("loadClassifier: %v", err)
+	}
+	if reloaded.Model.Samples != c.Model.Samples || len(reloaded.Model.Tokens) != len(c.Model.Tokens) {
+		t.Errorf("loadClassifier: got %v samples and %d tokens, want %v and %d", reloaded.Model.Samples, len(reloaded.Model.Tokens), c.Model.Samples, len(c.Model.Tokens))
+	}
+}
+
+func TestClassifierAction(t *testing.T) {
+	cfg := classifierConfig{
+		Thresholds: []classifierThreshold{
+			{Percent: 99, Action: "ban"},
+			{Percent: 80, Action: "report"},
+		},
+	}
+	caseTests := []struct {
+		probability float64
+		wantAction  string
+		wantOK      bool
+	}{
+		{probability: 0.5},
+		{probability: 0.8, wantAction: "report", wantOK: true},
+		{probability: 0.995, wantAction: "ban", wantOK: true},
+	}
+	for _, tt := range caseTests {
+		got, ok := cfg.action(tt.probability)
+		if ok != tt.wantOK || got.Action != tt.wantAction {
+			t.Errorf("action(%v): got %q, %v, want %q, %v", tt.probability, got.Action, ok, tt.wantAction, tt.wantOK)
+		}
+	}
+}
+
+func TestTrainClassifier(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	spam, ham := trainingSet(classifierMinSamples)
+	var lines []string
+	for i := range spam {
+		lines = append(lines, fmt.Sprintf(`{"text": %q, "label": "spam"}`, spam[i]), fmt.Sprintf(`{"text": %q, "label": "ham"}`, ham[i]), "")
+	}
+	file := filepath.Join(t.TempDir(), "samples.jsonl")
+	if err := os.WriteFile(file, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := trainClassifier([]string{file}); err != nil {
+		t.Fatalf("trainClassifier: %v", err)
+	}
+
+	c := newClassifier()
+	if err := c.loadClassifier(); err != nil {
+		t.Fatalf("loadClassifier: %v", err)
+	}
+	if p, ok := c.classify("Make money fast with our trading bot", defaultClassifierMinTokens); !ok || p < 0.5 {
+		t.Errorf("classify: got %.2f, %v, want spam", p, ok)
+	}
+
+	// Bad labels are rejected.
+	if err := os.WriteFile(file, []byte(`{"text": "hello there", "label": "eggs"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := trainClassifier([]string{file}); err == nil {
+		t.Errorf("trainClassifier: got no error with an unknown label")
+	}
+}
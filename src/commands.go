@@ -1,7 +1,9 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"math/rand"
 	"regexp"
@@ -28,6 +30,15 @@ func (x *opBot) Register(cmd string, desc string, adminOnly bool, pvtOnly bool,
 	log.Printf("Registered command %q, %q", cmd, desc)
 }
 
+// ownerOnly reserves the commands to the owners of the bot (see isOwner).
+func (x *opBot) ownerOnly(cmds ...string) {
+	for _, cmd := range cmds {
+		bcmd := x.commands[cmd]
+		bcmd.ownerOnly = true
+		x.commands[cmd] = bcmd
+	}
+}
+
 // hackerHandler provides anti-hacker protection to the bot.
 func (x *opBot) hackerHandler(bot tgbotInterface, update tgbotapi.Update) error {
 	// Gifs for /hackerdetected.
@@ -166,17 +177,28 @@ func parseCmdDuration(text string) (time.Duration, error) {
 	return d, nil
 }
 
+// setPatterns() replaces the patterns in use.
+func (x *opBot) setPatterns(patterns opPatterns) {
+	x.patterns = patterns
+	x.patternStats.setPatterns(patterns)
+}
+
 // reloadMatchPatterns() will reload the list of patterns to match against
 // for users joining the room.
 func (x *opBot) reloadMatchPatterns(_ tgbotInterface, update tgbotapi.Update) error {
 	patterns, err := loadPatterns()
+	// A missing file is created by the first /pattern_add.
+	x.patternsLoadErr = nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		x.patternsLoadErr = err
+	}
 	if err != nil {
 		fmt.Printf("Unable to load the matching patterns: %v (assuming no join patterns)\n", err)
 		// We are not returning the error here so that the bot will not send this to the user who
 		// requested this command. We log it anyway.
 		return nil
 	}
-	x.patterns = patterns
+	x.setPatterns(patterns)
 
 	from := "bot startup"
 	if update.Message != nil && update.Message.From != nil {
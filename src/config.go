@@ -36,6 +36,12 @@ func (d *duration) UnmarshalText(text []byte) error {
 	return err
 }
 
+// MarshalText encodes the duration in the same format accepted by
+// UnmarshalText.
+func (d duration) MarshalText() ([]byte, error) {
+	return []byte(d.Duration.String()), nil
+}
+
 type botConfig struct {
 	// BotToken contains the Telegram token for this bot.
 	BotToken string `toml:"token"`
@@ -56,12 +62,386 @@ type botConfig struct {
 	// Kick other bots from the channel at join time.
 	KickBots bool `toml:"kick_bots"`
 
-	// Bots in this whitelist won't be automatically kicked.
+	// Bots in this whitelist (usernames or user IDs) won't be automatically
+	// kicked.
 	BotWhitelist []string `toml:"bot_whitelist"`
 
+	// Users adding more than this number of banned bots within
+	// BotInviterWindow (default 24h) get BotInviterAction ("warn" or "mute").
+	// Set to 0 to disable this feature. Admins are exempt.
+	BotInviterLimit  int      `toml:"bot_inviter_limit"`
+	BotInviterWindow duration `toml:"bot_inviter_window"`
+	BotInviterAction string   `toml:"bot_inviter_action"`
+
 	// Restriction time for new users (can't post pictures, audio, etc)
 	// Set to 0 to disable this feature.
 	NewUserProbationTime duration `toml:"new_user_probation_time"`
+
+	// How often to send the stale patterns report to admins (0 = disabled).
+	PatternReportInterval duration `toml:"pattern_report_interval"`
+
+	// Patterns without matches for this many days are reported as stale.
+	StalePatternDays int `toml:"stale_pattern_days"`
+
+	// Plain text files with user IDs to add to the global banlist (one per
+	// line, optionally followed by the reason). Relative paths are relative
+	// to the configuration directory.
+	GlobalBanFeeds []string `toml:"global_ban_feeds"`
+
+	// Users (IDs) allowed to run the commands changing the state shared by
+	// all chats (patterns and the global banlist). Without owners, only the
+	// admins of every managed chat can run them.
+	Owners []int `toml:"owners"`
+
+	// Messages removed automatically are kept in a quarantine (browsed with
+	// /quarantine) for QuarantineTime (default 7 days), up to QuarantineSize
+	// messages (default 1000).
+	QuarantineSize int      `toml:"quarantine_size"`
+	QuarantineTime duration `toml:"quarantine_time"`
+
+	// Per chat settings. The entry with ID 0 (if any) holds the defaults for
+	// chats without a specific entry.
+	Chats []chatConfig `toml:"chat"`
+}
+
+// chatConfig holds the settings that can be set independently for each chat.
+type chatConfig struct {
+	// ID of the chat these settings apply to (0 = default).
+	ID int64 `toml:"id"`
+
+	// Links to these domains (and their subdomains) are always accepted.
+	LinkAllowlist []string `toml:"link_allowlist"`
+
+	// Links to these domains (and their subdomains) are always removed.
+	LinkBlocklist []string `toml:"link_blocklist"`
+
+	// Action to take on messages with links to blocked domains. Accepts the
+	// same actions as the ban patterns (default = delete).
+	LinkBlockAction string `toml:"link_block_action"`
+
+	// Duration used by the link block action, if applicable.
+	LinkBlockDuration duration `toml:"link_block_duration"`
+
+	// Prevent users under probation from posting links to domains not in
+	// the allowlist. Enabled unless explicitly set to false.
+	ProbationBlockLinks *bool `toml:"probation_block_links"`
+
+	// Kinds of content denied to users under probation, or to everyone. Chats
+	// without rules use defaultProbationRules.
+	ProbationRules []probationRule `toml:"probation_rule"`
+
+	// Forwards from these sources (chat or user IDs, and usernames) are kept
+	// even when delete_fwd is set.
+	ForwardAllowlist []string `toml:"forward_allowlist"`
+
+	// Only users past probation may forward from the allowlisted sources.
+	ForwardAfterProbation bool `toml:"forward_after_probation"`
+
+	// Policy for messages sent on behalf of channels: "allow" (default),
+	// "delete", or "linked" (only the linked channel: automatic forwards, and
+	// members posting as the channel).
+	// Allowed messages from channels other than the linked one are moderated
+	// like messages from users.
+	SenderChat string `toml:"sender_chat"`
+
+	// Ban the channel identity of messages deleted by the sender_chat policy.
+	SenderChatBan bool `toml:"sender_chat_ban"`
+
+	// Weighted spam scoring settings.
+	Score scoreConfig `toml:"score"`
+
+	// Filters based on the Unicode scripts used in messages and nicknames.
+	ScriptFilters []scriptFilter `toml:"script_filter"`
+
+	// Flood control settings.
+	Flood floodConfig `toml:"flood"`
+
+	// Detection of the same message posted by different users.
+	Duplicates duplicateConfig `toml:"duplicates"`
+
+	// Raid detection and lockdown settings.
+	Raid raidConfig `toml:"raid"`
+
+	// Graduation from probation by good behavior.
+	Graduation graduationConfig `toml:"graduation"`
+
+	// Photos matching the image blocklist.
+	ImageBlocklist imageConfig `toml:"image_blocklist"`
+
+	// Spam classifier trained from the decisions of the admins.
+	Classifier classifierConfig `toml:"classifier"`
+
+	// Cleanup of join and leave service messages.
+	ServiceMessages serviceMessageConfig `toml:"service_messages"`
+
+	// Detection of users who join, post and leave.
+	JoinSpamLeave joinSpamLeaveConfig `toml:"join_spam_leave"`
+
+	// Detection of users impersonating the admins.
+	Impostors impostorConfig `toml:"impostors"`
+}
+
+// impostorConfig holds the settings of the detection of users impersonating
+// the admins of a chat. The nickname and username of new users (and of users
+// who change them) are compared with the ones of the admins.
+type impostorConfig struct {
+	// Check new users, and users changing their names.
+	Enabled bool `toml:"enabled"`
+
+	// Minimum similarity, in percent, between the names of a user and an
+	// admin to restrict the user (default = 80).
+	Threshold int `toml:"threshold"`
+
+	// How long suspected impostors are restricted (default = forever).
+	RestrictTime duration `toml:"restrict_time"`
+}
+
+// joinSpamLeaveConfig holds the settings of the join-spam-leave detection.
+// Users who leave within the window after joining have their messages
+// deleted, and are banned.
+type joinSpamLeaveConfig struct {
+	// Time after joining during which leaving is suspicious (0 = disabled).
+	Window duration `toml:"window"`
+}
+
+// serviceMessageConfig holds the settings for the join and leave service
+// messages posted by Telegram in a chat.
+type serviceMessageConfig struct {
+	// Delete join and leave service messages.
+	Delete bool `toml:"delete"`
+
+	// Delete them after this long, instead of right away (0 = right away).
+	DeleteAfter duration `toml:"delete_after"`
+
+	// Post the number of joins and leaves once a day.
+	DailyCount bool `toml:"daily_count"`
+}
+
+// classifierConfig holds the settings of the spam classifier in a chat. The
+// model is shared by all chats.
+type classifierConfig struct {
+	// Classify messages from users with low reputation.
+	Enabled bool `toml:"enabled"`
+
+	// Only messages from users with a reputation score up to this are
+	// classified (default = 0, new users and users with a bad record).
+	MaxReputation int `toml:"max_reputation"`
+
+	// Messages with fewer distinct words are not classified (default = 3).
+	MinTokens int `toml:"min_tokens"`
+
+	// Actions to take based on the spam probability.
+	Thresholds []classifierThreshold `toml:"threshold"`
+}
+
+// classifierThreshold maps a minimum spam probability, in percent, to an
+// action.
+type classifierThreshold struct {
+	Percent  int      `toml:"percent"`
+	Action   string   `toml:"action"`
+	Duration duration `toml:"duration"`
+}
+
+// imageConfig holds the settings of the image blocklist in a chat. Images are
+// added to the blocklist by the admins, and shared by all chats.
+type imageConfig struct {
+	// Check photos from users under probation against the blocklist.
+	Enabled bool `toml:"enabled"`
+
+	// Check photos from all users, not only the ones under probation.
+	Everyone bool `toml:"everyone"`
+
+	// Maximum number of different bits (out of 64) between the perceptual
+	// hashes of images considered the same (default = 8).
+	MaxDistance int `toml:"max_distance"`
+
+	// Action to take on the authors of blocked photos. Accepts the same
+	// actions as the ban patterns, but only ban, kick, tempban and mute apply
+	// to the users (default = ban). The messages are always removed.
+	Action   string   `toml:"action"`
+	Duration duration `toml:"duration"`
+}
+
+// graduationConfig holds the settings of the alternative probation policy, in
+// which users graduate after posting enough messages neither removed nor
+// reported, instead of when new_user_probation_time runs out.
+type graduationConfig struct {
+	// Messages needed to graduate (0 = disabled).
+	Messages int `toml:"messages"`
+
+	// Minimum time under probation, even after posting enough messages.
+	MinTime duration `toml:"min_time"`
+
+	// Maximum time under probation (0 = no limit).
+	MaxTime duration `toml:"max_time"`
+}
+
+// probationRule denies some kinds of content to users under probation, or to
+// everyone. Messages with denied content are deleted.
+type probationRule struct {
+	// Name of the rule, shown in logs and metrics (default = content kinds).
+	Name string `toml:"name"`
+
+	// Kinds of content denied (see contentKinds).
+	Content []string `toml:"content"`
+
+	// Deny the content to everyone, not only to users under probation.
+	Everyone bool `toml:"everyone"`
+
+	// Translation key of the warning sent to the author (default = none).
+	Warning string `toml:"warning"`
+}
+
+// raidConfig holds the settings for the raid detection. When too many users
+// join a chat in a short time, the chat goes into lockdown: captchas are no
+// longer posted in the chat, and admins are notified.
+type raidConfig struct {
+	// Number of joins within the window that starts a lockdown (0 = never
+	// start a lockdown automatically).
+	Joins int `toml:"joins"`
+
+	// Time window used to count joins (default = 1m).
+	Window duration `toml:"window"`
+
+	// Automatic lockdowns end after this long without joins (default = 10m).
+	QuietPeriod duration `toml:"quiet_period"`
+
+	// What to do instead of posting the captcha in the chat: "private" (send
+	// the captcha in private, if possible, default) or "restrict" (silently
+	// restrict new users).
+	Captcha string `toml:"captcha"`
+
+	// How long to restrict new users when the captcha cannot be sent in
+	// private (default = 1h).
+	RestrictTime duration `toml:"restrict_time"`
+
+	// Kick all users joining during a lockdown.
+	KickJoins bool `toml:"kick_joins"`
+}
+
+// duplicateConfig holds the settings for the detection of similar messages
+// posted by different users (spam waves).
+type duplicateConfig struct {
+	// Number of different users posting similar messages within the window
+	// that makes a spam wave (0 = disable detection).
+	Users int `toml:"users"`
+
+	// Time window (default = 10m).
+	Window duration `toml:"window"`
+
+	// Minimum similarity between messages, in percent (default = 70).
+	Similarity int `toml:"similarity"`
+
+	// Messages with fewer letters and digits are ignored (default = 20).
+	MinLength int `toml:"min_length"`
+
+	// Messages matching these patterns (case-insensitive regular expressions)
+	// are never considered part of a wave.
+	Allowlist []string `toml:"allowlist"`
+
+	// Action to take on the authors of the messages in a wave. Accepts the
+	// same actions as the ban patterns, but only ban, kick, tempban and mute
+	// apply to the users (default = ban). The messages are always removed.
+	Action   string   `toml:"action"`
+	Duration duration `toml:"duration"`
+}
+
+// floodConfig holds the limits on the number of messages a single user can
+// post in a chat within a sliding time window.
+type floodConfig struct {
+	// Size of the sliding window (0 = disable flood control).
+	Window duration `toml:"window"`
+
+	// Maximum number of messages of each kind within the window (0 = no
+	// limit).
+	MaxText     int `toml:"max_text"`
+	MaxStickers int `toml:"max_stickers"`
+	MaxMedia    int `toml:"max_media"`
+
+	// Action to take on users over the limit: "mute" (default) or "tempban".
+	Action string `toml:"action"`
+
+	// How long the mute or tempban lasts (default = 1h).
+	Duration duration `toml:"duration"`
+}
+
+// scoreConfig holds the settings for the weighted spam scoring mode. In this
+// mode, every matching pattern and signal adds points to the message score,
+// and the thresholds decide what to do with the message.
+type scoreConfig struct {
+	// Use scoring instead of the action of the first matching pattern.
+	Enabled bool `toml:"enabled"`
+
+	// Points added when the author has no username.
+	NoUsername int `toml:"no_username"`
+
+	// Points added when the author has no profile photo.
+	NoProfilePhoto int `toml:"no_profile_photo"`
+
+	// Points added when the author has a new account. Telegram does not tell
+	// us when accounts were created, but user IDs are assigned in increasing
+	// order, so accounts with IDs at or above new_account_id are considered
+	// new (0 = disabled).
+	NewAccount   int `toml:"new_account"`
+	NewAccountID int `toml:"new_account_id"`
+
+	// Points added when the first message of a new user contains a link.
+	FirstMessageLink int `toml:"first_message_link"`
+
+	// Actions to take based on the total score.
+	Thresholds []scoreThreshold `toml:"threshold"`
+}
+
+// scriptFilter takes an action when the share of letters from some Unicode
+// scripts in a message (or nickname) exceeds a threshold.
+type scriptFilter struct {
+	// Names of the Unicode scripts (E.g. "Han", "Cyrillic", "Arabic").
+	Scripts []string `toml:"scripts"`
+
+	// Minimum combined share of the scripts, in percent.
+	Percent int `toml:"percent"`
+
+	// Texts with fewer letters than this are ignored (default = 10, or 3
+	// for nicknames).
+	MinLetters int `toml:"min_letters"`
+
+	// Only apply the filter to users under probation.
+	ProbationOnly bool `toml:"probation_only"`
+
+	// Also check the nicknames of users joining the chat.
+	Nickname bool `toml:"nickname"`
+
+	// Action to take. Accepts the same actions as the ban patterns (default =
+	// delete). For nicknames, only the actions that apply to the user (ban,
+	// kick, tempban and mute) are performed.
+	Action   string   `toml:"action"`
+	Duration duration `toml:"duration"`
+
+	// Shadow mode: only log and count the matches, without taking any action.
+	// Useful to tune the filter.
+	Shadow bool `toml:"shadow"`
+}
+
+// scoreThreshold maps a minimum score to an action.
+type scoreThreshold struct {
+	Score    int      `toml:"score"`
+	Action   string   `toml:"action"`
+	Duration duration `toml:"duration"`
+}
+
+// forChat returns the settings for a given chat ID. Chats without an entry of
+// their own get the default entry (ID 0) or an empty configuration.
+func (c botConfig) forChat(chatID int64) chatConfig {
+	var def chatConfig
+	for _, cc := range c.Chats {
+		if cc.ID == chatID {
+			return cc
+		}
+		if cc.ID == 0 {
+			def = cc
+		}
+	}
+	return def
 }
 
 // loadConfig loads the configuration items for the bot from 'configFile' under
@@ -69,9 +449,11 @@ type botConfig struct {
 func loadConfig() (botConfig, error) {
 	// Hardwire some defaults and let the config override them.
 	config := botConfig{
-		NewUserProbationTime: duration{time.Duration(24 * time.Hour)},
-		KickBots:             true,
-		DeleteFwd:            true,
+		NewUserProbationTime:  duration{time.Duration(24 * time.Hour)},
+		KickBots:              true,
+		DeleteFwd:             true,
+		PatternReportInterval: duration{time.Duration(7 * 24 * time.Hour)},
+		StalePatternDays:      30,
 	}
 
 	cfgdir, err := configDir()
@@ -93,6 +475,16 @@ func loadConfig() (botConfig, error) {
 		return botConfig{}, errors.New("token cannot be null")
 	}
 
+	if err := validateProbationRules(config.Chats); err != nil {
+		return botConfig{}, err
+	}
+	if err := validateSenderChat(config.Chats); err != nil {
+		return botConfig{}, err
+	}
+	if err := validateBotInviterAction(config.BotInviterAction); err != nil {
+		return botConfig{}, err
+	}
+
 	// Defaults
 	if config.ServerPort == 0 {
 		config.ServerPort = defaultServerPort
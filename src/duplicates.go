@@ -0,0 +1,240 @@
+// Detection of the same message posted by different users (spam waves).
+
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+)
+
+const (
+	// Defaults for the duplicate detection settings.
+	defaultDuplicateWindow     = 10 * time.Minute
+	defaultDuplicateSimilarity = 70
+	defaultDuplicateMinLength  = 20
+
+	// Size (in runes) of the shingles used to compare messages.
+	shingleSize = 4
+)
+
+// dupEntry holds the fingerprint of a recent message.
+type dupEntry struct {
+	Time      time.Time
+	User      tgbotapi.User
+	MessageID int
+	Sample    string
+	Shingles  map[uint64]bool
+	// Handled is set once the message is found to be part of a spam wave.
+	Handled bool
+}
+
+// dupIndex holds the fingerprints of recent messages, by chat. It is only used
+// from the main loop, so no locking is needed.
+type dupIndex map[int64][]*dupEntry
+
+// shingles returns the set of hashes of the overlapping substrings of the
+// normalized text (lowercase letters and digits, separated by single spaces),
+// and the number of letters and digits in it.
+func shingles(text string) (map[uint64]bool, int) {
+	var b strings.Builder
+	length := 0
+	for _, r := range skeleton(text) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			length++
+			continue
+		}
+		b.WriteRune(' ')
+	}
+	runes := []rune(strings.Join(strings.Fields(b.String()), " "))
+
+	ret := map[uint64]bool{}
+	for i := 0; i+shingleSize <= len(runes); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(string(runes[i : i+shingleSize])))
+		ret[h.Sum64()] = true
+	}
+	return ret, length
+}
+
+// similarity returns the Jaccard similarity (in percent) of two sets of
+// shingles.
+func similarity(a, b map[uint64]bool) int {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	common := 0
+	for h := range a {
+		if b[h] {
+			common++
+		}
+	}
+	return common * 100 / (len(a) + len(b) - common)
+}
+
+// window returns the time window of the duplicate detection.
+func (c duplicateConfig) window() time.Duration {
+	if c.Window.Duration > 0 {
+		return c.Window.Duration
+	}
+	return defaultDuplicateWindow
+}
+
+// allowed returns true if the text is too short or in the allowlist.
+func (c duplicateConfig) allowed(text string, length int) bool {
+	minLength := c.MinLength
+	if minLength == 0 {
+		minLength = defaultDuplicateMinLength
+	}
+	if length < minLength {
+		return true
+	}
+	normalized := normalizeText(text)
+	for _, pattern := range c.Allowlist {
+		if performMatch(pattern, text) || performMatch(pattern, normalized) {
+			return true
+		}
+	}
+	return false
+}
+
+// add adds the entry to the chat index, and returns the recent entries similar
+// to it (including itself). Entries older than the window are dropped.
+func (idx dupIndex) add(chatID int64, entry *dupEntry, cfg duplicateConfig) []*dupEntry {
+	minSimilarity := cfg.Similarity
+	if minSimilarity == 0 {
+		minSimilarity = defaultDuplicateSimilarity
+	}
+
+	var recent, similar []*dupEntry
+	for _, e := range idx[chatID] {
+		if entry.Time.Sub(e.Time) >= cfg.window() {
+			continue
+		}
+		recent = append(recent, e)
+		if similarity(e.Shingles, entry.Shingles) >= minSimilarity {
+			similar = append(similar, e)
+		}
+	}
+	idx[chatID] = append(recent, entry)
+	return append(similar, entry)
+}
+
+// distinctUsers returns the number of different authors of the entries.
+func distinctUsers(entries []*dupEntry) int {
+	users := map[int]bool{}
+	for _, e := range entries {
+		users[e.User.ID] = true
+	}
+	return len(users)
+}
+
+// handledDuplicates checks whether the message is part of a wave of similar
+// messages from different users. When the number of users reaches the limit,
+// all copies are removed and their authors are handled like a pattern match.
+// Returns true if the message was removed.
+func (x *opBot) handledDuplicates(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) bool {
+	cfg := x.config.forChat(msg.Chat.ID).Duplicates
+	if cfg.Users <= 0 {
+		return false
+	}
+
+	text := msg.Text
+	if msg.Caption != "" {
+		text = msg.Caption
+	}
+	sh, length := shingles(text)
+	if cfg.allowed(text, length) {
+		return false
+	}
+
+	entry := &dupEntry{
+		Time:      time.Now(),
+		User:      *msg.From,
+		MessageID: msg.MessageID,
+		Sample:    messageSample(msg),
+		Shingles:  sh,
+	}
+	similar := x.duplicates.add(msg.Chat.ID, entry, cfg)
+
+	// Copies of a wave already handled are removed right away.
+	wave := false
+	for _, e := range similar {
+		wave = wave || e.Handled
+	}
+	if !wave && distinctUsers(similar) < cfg.Users {
+		return false
+	}
+
+	var pending []*dupEntry
+	for _, e := range similar {
+		if !e.Handled {
+			e.Handled = true
+			pending = append(pending, e)
+		}
+	}
+	x.removeDuplicates(bot, msg.Chat, pending, cfg)
+
+	// Only the message starting the wave gets a summary.
+	if !wave {
+		promDuplicateWaveCount.Inc()
+		x.notifyDuplicateWave(bot, msg.Chat, pending)
+	}
+	return true
+}
+
+// removeDuplicates deletes the messages in the entries and performs the
+// configured action on their authors (once per author).
+func (x *opBot) removeDuplicates(bot *tgbotapi.BotAPI, chat *tgbotapi.Chat, entries []*dupEntry, cfg duplicateConfig) {
+	action := actionFromString(cfg.Action)
+	if action == opNoAction {
+		action = opBan
+	}
+
+	done := map[int]bool{}
+	for _, e := range entries {
+		// Only the sample of the text is kept for duplicates.
+		x.addToQuarantine(quarantineEntry{Time: e.Time, ChatID: chat.ID, ChatTitle: chat.Title, User: e.User, Reason: "duplicate message", Text: e.Sample})
+		if deleteMessage(bot, chat.ID, e.MessageID) == nil {
+			promDuplicateMessageDeletedCount.Inc()
+		}
+		if done[e.User.ID] {
+			continue
+		}
+		done[e.User.ID] = true
+
+		log.Printf("Duplicate message %d from user %s (uid=%d) in chat %d, action %q: %q", e.MessageID, formatName(e.User), e.User.ID, chat.ID, action.String(), e.Sample)
+		promPatternActionCount.WithLabelValues(strings.ToLower(action.String())).Inc()
+		if err := x.performUserAction(bot, chat.ID, e.User, action, cfg.Duration.Duration); err != nil {
+			log.Printf("Error handling duplicate message: %v", err)
+		}
+	}
+}
+
+// notifyDuplicateWave sends a single summary of the wave to the chat admins.
+func (x *opBot) notifyDuplicateWave(bot *tgbotapi.BotAPI, chat *tgbotapi.Chat, entries []*dupEntry) {
+	if len(entries) == 0 {
+		return
+	}
+
+	lines := []string{
+		fmt.Sprintf("*Duplicate messages removed from %s:*", markdownEscape(chat.Title)),
+		markdownEscape(entries[0].Sample),
+		"*Authors:*",
+	}
+	seen := map[int]bool{}
+	for _, e := range entries {
+		if !seen[e.User.ID] {
+			seen[e.User.ID] = true
+			lines = append(lines, fmt.Sprintf("- %s (uid=%d)", formatName(e.User), e.User.ID))
+		}
+	}
+
+	notifyChatAdmins(bot, chat.ID, lines)
+}
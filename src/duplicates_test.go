@@ -0,0 +1,89 @@
+// Unit tests for the duplicates module.
+package main
+
+import (
+	"testing"
+	"time"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+)
+
+func TestSimilarity(t *testing.T) {
+	spam := "Ganhe dinheiro fácil com criptomoedas, chama no privado!"
+
+	caseTests := []struct {
+		text    string
+		similar bool
+	}{
+		{text: "Ganhe dinheiro facil com criptomoedas, chama no privado!!!", similar: true},
+		{text: "GANHE DINHEIRO FÁCIL COM CRIPTOMOEDAS, me chama no privado", similar: true},
+		{text: "G\u0430nhe dinheiro fácil com criptomoedas\u200b, chama no privado", similar: true},
+		{text: "Oi pessoal, ganhe dinheiro fácil com criptomoedas, chama no privado agora", similar: true},
+		{text: "Alguém sabe como usar goroutines com canais em Go?"},
+		{text: "Ganhe tempo usando canais em Go, é fácil"},
+	}
+
+	a, _ := shingles(spam)
+	for _, tt := range caseTests {
+		b, _ := shingles(tt.text)
+		got := similarity(a, b)
+		if (got >= defaultDuplicateSimilarity) != tt.similar {
+			t.Errorf("similarity(%q): got %d%%, want similar = %v", tt.text, got, tt.similar)
+		}
+	}
+}
+
+func TestDuplicateAllowed(t *testing.T) {
+	cfg := duplicateConfig{Allowlist: []string{`^(obrigad[oa]|thanks)\b`}}
+
+	caseTests := []struct {
+		text string
+		want bool
+	}{
+		{text: "+1", want: true},
+		{text: "thanks!", want: true},
+		{text: "Thanks a lot for the detailed explanation, it helped!", want: true},
+		{text: "Obrigado pela explicação detalhada, ajudou muito!", want: true},
+		{text: "Ganhe dinheiro fácil com criptomoedas, chama no privado!"},
+	}
+
+	for _, tt := range caseTests {
+		_, length := shingles(tt.text)
+		if got := cfg.allowed(tt.text, length); got != tt.want {
+			t.Errorf("allowed(%q): got %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestDupIndex(t *testing.T) {
+	cfg := duplicateConfig{Window: duration{10 * time.Minute}}
+	idx := dupIndex{}
+	start := time.Now()
+
+	caseTests := []struct {
+		offset    time.Duration // Time of the message, relative to start.
+		userID    int
+		chatID    int64
+		text      string
+		wantUsers int // Distinct users posting similar messages.
+	}{
+		{offset: 0, userID: 1, chatID: 1, text: "Ganhe dinheiro fácil com criptomoedas", wantUsers: 1},
+		// Same user again.
+		{offset: time.Minute, userID: 1, chatID: 1, text: "Ganhe dinheiro fácil com criptomoedas!", wantUsers: 1},
+		// Another user, in another chat.
+		{offset: time.Minute, userID: 2, chatID: 2, text: "Ganhe dinheiro fácil com criptomoedas", wantUsers: 1},
+		{offset: 2 * time.Minute, userID: 2, chatID: 1, text: "ganhe dinheiro FACIL com criptomoedas", wantUsers: 2},
+		// Unrelated message.
+		{offset: 3 * time.Minute, userID: 3, chatID: 1, text: "Alguém sabe usar goroutines?", wantUsers: 1},
+		// The first messages fall out of the window.
+		{offset: 11*time.Minute + time.Second, userID: 3, chatID: 1, text: "Ganhe dinheiro fácil com criptomoedas", wantUsers: 2},
+	}
+
+	for _, tt := range caseTests {
+		sh, _ := shingles(tt.text)
+		entry := &dupEntry{Time: start.Add(tt.offset), User: tgbotapi.User{ID: tt.userID}, Shingles: sh}
+		if got := distinctUsers(idx.add(tt.chatID, entry, cfg)); got != tt.wantUsers {
+			t.Errorf("add(%q at %v): got %d users, want %d", tt.text, tt.offset, got, tt.wantUsers)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Per user flood control.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+)
+
+// Default duration of the mute (or tempban) applied to flooders.
+const defaultFloodActionTime = 1 * time.Hour
+
+// floodEntry holds a message posted within the flood control window.
+type floodEntry struct {
+	Time    time.Time
+	Message *tgbotapi.Message
+}
+
+// floodKind returns the kind of the message for flood control purposes:
+// "sticker", "media" or "text".
+func floodKind(msg *tgbotapi.Message) string {
+	switch {
+	case msg.Sticker != nil:
+		return "sticker"
+	case richMessage(msg):
+		return "media"
+	}
+	return "text"
+}
+
+// limit returns the maximum number of messages of the given kind within the
+// window (0 = no limit).
+func (c floodConfig) limit(kind string) int {
+	limits := map[string]int{
+		"text":    c.MaxText,
+		"sticker": c.MaxStickers,
+		"media":   c.MaxMedia,
+	}
+	return limits[kind]
+}
+
+// action returns the action to take on flooders: mute or tempban.
+func (c floodConfig) action() opMatchAction {
+	if actionFromString(c.Action) == opTempBan {
+		return opTempBan
+	}
+	return opMute
+}
+
+// recordFlood adds a message to the user entries and returns all entries
+// within the window, oldest first. Entries expire from the cache when the
+// user stops posting for a whole window.
+func (x *opBot) recordFlood(key string, entry floodEntry, window time.Duration) []floodEntry {
+	var entries []floodEntry
+	if v, found := x.floodCache.Get(key); found {
+		for _, e := range v.([]floodEntry) {
+			if entry.Time.Sub(e.Time) < window {
+				entries = append(entries, e)
+			}
+		}
+	}
+	entries = append(entries, entry)
+	x.floodCache.Set(key, entries, window)
+	return entries
+}
+
+// handledFlood tracks the messages of each user and, if the user posts more
+// messages than the chat allows within the window, deletes the whole burst
+// and mutes (or tempbans) the user. Returns true if the message was removed.
+func (x *opBot) handledFlood(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) bool {
+	cfg := x.config.forChat(msg.Chat.ID).Flood
+	window := cfg.Window.Duration
+	kind := floodKind(msg)
+	limit := cfg.limit(kind)
+	if window <= 0 || limit <= 0 {
+		return false
+	}
+
+	user := *msg.From
+	key := fmt.Sprintf("%d:%d:%s", msg.Chat.ID, user.ID, kind)
+	entries := x.recordFlood(key, floodEntry{Time: time.Now(), Message: msg}, window)
+	if len(entries) <= limit {
+		return false
+	}
+
+	promFloodCount.WithLabelValues(kind).Inc()
+	log.Printf("User %s (uid=%d) posted %d %s messages in %v in chat %d. Deleting the burst.", formatName(user), user.ID, len(entries), kind, window, msg.Chat.ID)
+
+	// Start over, so the rest of the burst is counted towards a new window.
+	x.floodCache.Delete(key)
+	for _, e := range entries {
+		x.quarantineMessage(e.Message, "flood")
+		deleteMessage(bot, msg.Chat.ID, e.Message.MessageID)
+	}
+
+	// Only warn once per window, to avoid adding to the flood.
+	warnKey := fmt.Sprintf("%d:%d", msg.Chat.ID, user.ID)
+	if _, found := x.floodWarningCache.Get(warnKey); !found {
+		x.reputation.warned(msg.Chat.ID, user.ID)
+		if warning, err := sendMessage(bot, msg.Chat.ID, fmt.Sprintf(T("flood_warning"), nameRef(user))); err != nil {
+			log.Printf("Error sending flood warning: %v", err)
+		} else {
+			selfDestructMessage(bot, warning.Chat.ID, warning.MessageID, 0)
+		}
+		x.floodWarningCache.Set(warnKey, time.Now(), window)
+	}
+
+	d := cfg.Duration.Duration
+	if d == 0 {
+		d = defaultFloodActionTime
+	}
+	if err := x.performUserAction(bot, msg.Chat.ID, user, cfg.action(), d); err != nil {
+		log.Printf("Error handling flood from user %s (uid=%d): %v", formatName(user), user.ID, err)
+	}
+	return true
+}
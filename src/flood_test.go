@@ -0,0 +1,69 @@
+// Unit tests for the flood module.
+package main
+
+import (
+	"testing"
+	"time"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+	"github.com/patrickmn/go-cache"
+)
+
+func TestFloodKind(t *testing.T) {
+	cfg := floodConfig{MaxText: 5, MaxStickers: 3, MaxMedia: 2}
+
+	caseTests := []struct {
+		message   *tgbotapi.Message
+		wantKind  string
+		wantLimit int
+	}{
+		{message: &tgbotapi.Message{Text: "hello"}, wantKind: "text", wantLimit: 5},
+		{message: &tgbotapi.Message{Sticker: &tgbotapi.Sticker{}}, wantKind: "sticker", wantLimit: 3},
+		{message: &tgbotapi.Message{Photo: &[]tgbotapi.PhotoSize{}}, wantKind: "media", wantLimit: 2},
+		{message: &tgbotapi.Message{Document: &tgbotapi.Document{}, Caption: "x"}, wantKind: "media", wantLimit: 2},
+	}
+
+	for _, tt := range caseTests {
+		kind := floodKind(tt.message)
+		if kind != tt.wantKind || cfg.limit(kind) != tt.wantLimit {
+			t.Errorf("floodKind: got %q (limit %d), want %q (limit %d)", kind, cfg.limit(kind), tt.wantKind, tt.wantLimit)
+		}
+	}
+}
+
+func TestRecordFlood(t *testing.T) {
+	x := opBot{floodCache: cache.New(time.Minute, time.Minute)}
+	window := 10 * time.Second
+	start := time.Now()
+
+	caseTests := []struct {
+		offset  time.Duration // Time of the message, relative to start.
+		wantIDs []int         // Messages expected within the window.
+	}{
+		{offset: 0, wantIDs: []int{1}},
+		{offset: 2 * time.Second, wantIDs: []int{1, 2}},
+		{offset: 9 * time.Second, wantIDs: []int{1, 2, 3}},
+		// The first message falls out of the window.
+		{offset: 11 * time.Second, wantIDs: []int{2, 3, 4}},
+		// Everything but the last message falls out of the window.
+		{offset: 30 * time.Second, wantIDs: []int{5}},
+	}
+
+	for i, tt := range caseTests {
+		entries := x.recordFlood("1:2:text", floodEntry{Time: start.Add(tt.offset), Message: &tgbotapi.Message{MessageID: i + 1}}, window)
+		var ids []int
+		for _, e := range entries {
+			ids = append(ids, e.Message.MessageID)
+		}
+		if len(ids) != len(tt.wantIDs) {
+			t.Errorf("recordFlood(%v): got %v, want %v", tt.offset, ids, tt.wantIDs)
+			continue
+		}
+		for j := range ids {
+			if ids[j] != tt.wantIDs[j] {
+				t.Errorf("recordFlood(%v): got %v, want %v", tt.offset, ids, tt.wantIDs)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,203 @@
+// Allowlist of sources whose forwarded messages are kept.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+)
+
+// File to store the sources added by the admins.
+const forwardAllowlistDB = "forward_allowlist.json"
+
+// forwardAllowlist holds the sources added to the allowlist of each chat by
+// the admins, on top of the ones in the configuration.
+type forwardAllowlist struct {
+	sync.RWMutex
+	// Sources maps the chat ID to the allowed sources (IDs or "@usernames").
+	Sources            map[int64][]string
+	forwardAllowlistDB string
+}
+
+// newForwardAllowlist creates a new forwardAllowlist object.
+func newForwardAllowlist() *forwardAllowlist {
+	return &forwardAllowlist{
+		Sources:            map[int64][]string{},
+		forwardAllowlistDB: forwardAllowlistDB,
+	}
+}
+
+// loadForwardAllowlist loads the sources added by the admins from the disk.
+func (f *forwardAllowlist) loadForwardAllowlist() error {
+	f.Lock()
+	defer f.Unlock()
+
+	err := readJSONFromDataDir(&f.Sources, f.forwardAllowlistDB)
+	if f.Sources == nil {
+		f.Sources = map[int64][]string{}
+	}
+	return err
+}
+
+// add adds the source to the allowlist of the chat. Returns false if the
+// source was already there.
+func (f *forwardAllowlist) add(chatID int64, source string) bool {
+	f.Lock()
+	defer f.Unlock()
+
+	source = normalizeSource(source)
+	for _, s := range f.Sources[chatID] {
+		if s == source {
+			return false
+		}
+	}
+	f.Sources[chatID] = append(f.Sources[chatID], source)
+	sort.Strings(f.Sources[chatID])
+	if err := safeWriteJSON(f.Sources, f.forwardAllowlistDB); err != nil {
+		log.Printf("Error saving forward allowlist: %v", err)
+	}
+	return true
+}
+
+// remove removes the source from the allowlist of the chat. Returns false if
+// the source was not there.
+func (f *forwardAllowlist) remove(chatID int64, source string) bool {
+	f.Lock()
+	defer f.Unlock()
+
+	source = normalizeSource(source)
+	sources := f.Sources[chatID]
+	for i, s := range sources {
+		if s != source {
+			continue
+		}
+		f.Sources[chatID] = append(sources[:i:i], sources[i+1:]...)
+		if len(f.Sources[chatID]) == 0 {
+			delete(f.Sources, chatID)
+		}
+		if err := safeWriteJSON(f.Sources, f.forwardAllowlistDB); err != nil {
+			log.Printf("Error saving forward allowlist: %v", err)
+		}
+		return true
+	}
+	return false
+}
+
+// list returns the sources added to the allowlist of the chat.
+func (f *forwardAllowlist) list(chatID int64) []string {
+	f.RLock()
+	defer f.RUnlock()
+	return append([]string{}, f.Sources[chatID]...)
+}
+
+// normalizeSource returns the canonical form of a source: a numeric ID, or a
+// lowercase username starting with "@".
+func normalizeSource(source string) string {
+	source = strings.TrimSpace(source)
+	if _, err := strconv.ParseInt(source, 10, 64); err == nil {
+		return source
+	}
+	return "@" + strings.ToLower(strings.TrimPrefix(source, "@"))
+}
+
+// forwardSources returns the ID and username (if any) of the chat or user the
+// message was forwarded from, in canonical form.
+func forwardSources(msg *tgbotapi.Message) []string {
+	var sources []string
+	switch {
+	case msg.ForwardFromChat != nil:
+		sources = append(sources, strconv.FormatInt(msg.ForwardFromChat.ID, 10))
+		if msg.ForwardFromChat.UserName != "" {
+			sources = append(sources, normalizeSource(msg.ForwardFromChat.UserName))
+		}
+	case msg.ForwardFrom != nil:
+		sources = append(sources, strconv.Itoa(msg.ForwardFrom.ID))
+		if msg.ForwardFrom.UserName != "" {
+			sources = append(sources, normalizeSource(msg.ForwardFrom.UserName))
+		}
+	}
+	return sources
+}
+
+// forwardAllowed returns true if the message was forwarded from a source in
+// the allowlist, either in the configuration or added by the admins.
+func (x *opBot) forwardAllowed(msg *tgbotapi.Message) bool {
+	cfg := x.config.forChat(msg.Chat.ID)
+	if cfg.ForwardAfterProbation && msg.From != nil && x.onProbation(msg.Chat.ID, msg.From.ID) {
+		return false
+	}
+
+	allowed := map[string]bool{}
+	for _, s := range cfg.ForwardAllowlist {
+		allowed[normalizeSource(s)] = true
+	}
+	for _, s := range x.forwardAllowlist.list(msg.Chat.ID) {
+		allowed[s] = true
+	}
+	for _, s := range forwardSources(msg) {
+		if allowed[s] {
+			return true
+		}
+	}
+	return false
+}
+
+// deletesForward returns true if the message is a forward to be deleted.
+func (x *opBot) deletesForward(msg *tgbotapi.Message) bool {
+	return x.config.DeleteFwd && isForwarded(msg) && !x.forwardAllowed(msg)
+}
+
+// fwdAllowHandler manages the forward allowlist of the chat. In reply to a
+// forwarded message, it adds the source of the message. Usage: /fwd_allow
+// [del <source>], or in reply to a forwarded message.
+func (x *opBot) fwdAllowHandler(bot tgbotInterface, update tgbotapi.Update) error {
+	msg := update.Message
+	if isPrivateChat(msg.Chat) {
+		return fmt.Errorf("use /fwd\\_allow in the group")
+	}
+
+	args := strings.Fields(msg.CommandArguments())
+	switch {
+	case len(args) == 2 && args[0] == "del":
+		if !x.forwardAllowlist.remove(msg.Chat.ID, args[1]) {
+			return fmt.Errorf("source %s is not in the allowlist", markdownEscape(args[1]))
+		}
+		log.Printf("Source %s removed from the forward allowlist of chat %d by %s", args[1], msg.Chat.ID, formatName(*msg.From))
+		_, err := sendReply(bot, msg.Chat.ID, msg.MessageID, fmt.Sprintf("Source %s removed from the forward allowlist.", markdownEscape(args[1])))
+		return err
+
+	case len(args) == 0 && msg.ReplyToMessage != nil:
+		sources := forwardSources(msg.ReplyToMessage)
+		if len(sources) == 0 {
+			return fmt.Errorf("the message is not a forward, or its source is hidden")
+		}
+		// Prefer the ID, as usernames can change.
+		if !x.forwardAllowlist.add(msg.Chat.ID, sources[0]) {
+			return fmt.Errorf("source %s is already in the allowlist", sources[0])
+		}
+		log.Printf("Source %s added to the forward allowlist of chat %d by %s", sources[0], msg.Chat.ID, formatName(*msg.From))
+		_, err := sendReply(bot, msg.Chat.ID, msg.MessageID, fmt.Sprintf("Source %s added to the forward allowlist.", markdownEscape(strings.Join(sources, " "))))
+		return err
+
+	case len(args) == 0:
+		cfg := x.config.forChat(msg.Chat.ID)
+		lines := []string{"*Forward allowlist:*"}
+		for _, s := range cfg.ForwardAllowlist {
+			lines = append(lines, markdownEscape(normalizeSource(s))+" (config)")
+		}
+		for _, s := range x.forwardAllowlist.list(msg.Chat.ID) {
+			lines = append(lines, markdownEscape(s))
+		}
+		if len(lines) == 1 {
+			lines = append(lines, "No sources.")
+		}
+		return sendLongReply(bot, msg.Chat.ID, msg.MessageID, lines)
+	}
+	return fmt.Errorf("usage: /fwd\\_allow \\[del <source>], or in reply to a forwarded message")
+}
@@ -0,0 +1,88 @@
+// Unit tests for the forward allowlist module.
+package main
+
+import (
+	"testing"
+	"time"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+)
+
+func TestForwardAllowed(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	x := opBot{
+		config: botConfig{
+			DeleteFwd: true,
+			Chats: []chatConfig{
+				{ID: chatID, ForwardAllowlist: []string{"@OsProgramadores", "-1005"}},
+				{ID: 4321, ForwardAllowlist: []string{"@osprogramadores"}, ForwardAfterProbation: true},
+			},
+		},
+		probation:        newProbation(),
+		forwardAllowlist: newForwardAllowlist(),
+	}
+	x.probation.joined(4321, userID, time.Now(), time.Hour)
+	x.forwardAllowlist.add(chatID, "42")
+
+	caseTests := []struct {
+		name       string
+		chatID     int64
+		fwdChat    *tgbotapi.Chat
+		fwdUser    *tgbotapi.User
+		wantDelete bool
+	}{
+		{
+			name:    "Allowed username",
+			chatID:  chatID,
+			fwdChat: &tgbotapi.Chat{ID: -1009, UserName: "osprogramadores"},
+		},
+		{
+			name:    "Allowed ID",
+			chatID:  chatID,
+			fwdChat: &tgbotapi.Chat{ID: -1005},
+		},
+		{
+			name:    "User added by an admin",
+			chatID:  chatID,
+			fwdUser: &tgbotapi.User{ID: 42},
+		},
+		{
+			name:       "Unknown channel",
+			chatID:     chatID,
+			fwdChat:    &tgbotapi.Chat{ID: -1007, UserName: "spam"},
+			wantDelete: true,
+		},
+		{
+			name:       "Allowed source, user under probation",
+			chatID:     4321,
+			fwdChat:    &tgbotapi.Chat{ID: -1009, UserName: "osprogramadores"},
+			wantDelete: true,
+		},
+	}
+
+	for _, tt := range caseTests {
+		msg := &tgbotapi.Message{
+			From:            &tgbotapi.User{ID: userID},
+			Chat:            &tgbotapi.Chat{ID: tt.chatID},
+			ForwardFromChat: tt.fwdChat,
+			ForwardFrom:     tt.fwdUser,
+			ForwardDate:     1,
+		}
+		if got := x.deletesForward(msg); got != tt.wantDelete {
+			t.Errorf("%s: deletesForward got %v, want %v", tt.name, got, tt.wantDelete)
+		}
+	}
+
+	// Sources added by the admins survive a reload, and can be removed.
+	reloaded := newForwardAllowlist()
+	if err := reloaded.loadForwardAllowlist(); err != nil {
+		t.Fatalf("loadForwardAllowlist: %v", err)
+	}
+	if got := reloaded.list(chatID); len(got) != 1 || got[0] != "42" {
+		t.Errorf("loadForwardAllowlist: got %v, want [42]", got)
+	}
+	if !reloaded.remove(chatID, "42") || reloaded.remove(chatID, "42") || len(reloaded.Sources) != 0 {
+		t.Errorf("remove: got %v, want no sources", reloaded.Sources)
+	}
+}
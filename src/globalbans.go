@@ -0,0 +1,354 @@
+// Global banlist, shared by all chats managed by the bot.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+)
+
+// File to store the global banlist.
+const globalBansDB = "global_bans.json"
+
+// globalBan holds the information about a globally banned user.
+type globalBan struct {
+	// Why the user was banned.
+	Reason string `json:"reason"`
+	// Chat where the user was banned (0 = unknown, E.g. imported).
+	SourceChat int64 `json:"source_chat"`
+	// When the user was added to the banlist.
+	Time time.Time `json:"time"`
+}
+
+// globalBans holds the list of users banned from all managed chats.
+type globalBans struct {
+	sync.RWMutex
+	Users        map[int]globalBan
+	globalBansDB string
+}
+
+// newGlobalBans creates a new globalBans object.
+func newGlobalBans() *globalBans {
+	return &globalBans{
+		Users:        map[int]globalBan{},
+		globalBansDB: globalBansDB,
+	}
+}
+
+// loadGlobalBans loads the global banlist from the disk.
+func (g *globalBans) loadGlobalBans() error {
+	g.Lock()
+	defer g.Unlock()
+
+	err := readJSONFromDataDir(&g.Users, g.globalBansDB)
+	if g.Users == nil {
+		g.Users = map[int]globalBan{}
+	}
+	promGlobalBanListSize.Set(float64(len(g.Users)))
+	return err
+}
+
+// save writes the banlist to disk. Locks are assumed to be taken care of by
+// the caller.
+func (g *globalBans) save() {
+	if err := safeWriteJSON(g.Users, g.globalBansDB); err != nil {
+		log.Printf("Error saving global banlist: %v", err)
+	}
+	promGlobalBanListSize.Set(float64(len(g.Users)))
+}
+
+// add adds the user to the banlist. Users already in the list keep their
+// original entry. Returns true if the user was added.
+func (g *globalBans) add(userID int, reason string, chatID int64) bool {
+	g.Lock()
+	defer g.Unlock()
+
+	if _, ok := g.Users[userID]; ok {
+		return false
+	}
+	g.Users[userID] = globalBan{Reason: reason, SourceChat: chatID, Time: time.Now().UTC().Truncate(time.Second)}
+	g.save()
+
+	log.Printf("User %d added to the global banlist (chat %d): %s", userID, chatID, reason)
+	return true
+}
+
+// remove removes the user from the banlist. Returns true if the user was
+// in the list.
+func (g *globalBans) remove(userID int) bool {
+	g.Lock()
+	defer g.Unlock()
+
+	if _, ok := g.Users[userID]; !ok {
+		return false
+	}
+	delete(g.Users, userID)
+	g.save()
+	return true
+}
+
+// get returns the banlist entry for the user, if any.
+func (g *globalBans) get(userID int) (globalBan, bool) {
+	g.RLock()
+	defer g.RUnlock()
+
+	gb, ok := g.Users[userID]
+	return gb, ok
+}
+
+// importFeed adds the users in a plain text feed to the banlist. Each line
+// holds a user ID, optionally followed by the reason. Empty lines and lines
+// starting with "#" are ignored. Returns the number of users added.
+func (g *globalBans) importFeed(r io.Reader, source string) (int, error) {
+	g.Lock()
+	defer g.Unlock()
+
+	added := 0
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, reason, _ := strings.Cut(line, " ")
+		userID, err := strconv.Atoi(id)
+		if err != nil || userID <= 0 {
+			log.Printf("Ignoring invalid user ID %q in %s, line %d", id, source, n)
+			continue
+		}
+		if _, ok := g.Users[userID]; ok {
+			continue
+		}
+		reason = strings.TrimSpace(reason)
+		if reason == "" {
+			reason = "imported from " + source
+		}
+		g.Users[userID] = globalBan{Reason: reason, Time: time.Now().UTC().Truncate(time.Second)}
+		added++
+	}
+	if added > 0 {
+		g.save()
+	}
+	return added, scanner.Err()
+}
+
+// export returns the banlist in the same format read by importFeed, sorted by
+// user ID.
+func (g *globalBans) export() []byte {
+	g.RLock()
+	defer g.RUnlock()
+
+	var ids []int
+	for id := range g.Users {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# op-bot global banlist, %d users, exported %s\n", len(ids), time.Now().UTC().Format(time.RFC3339))
+	for _, id := range ids {
+		reason := strings.Join(strings.Fields(g.Users[id].Reason), " ")
+		fmt.Fprintf(&buf, "%d %s\n", id, reason)
+	}
+	return buf.Bytes()
+}
+
+// importGlobalBanFeeds imports all feeds in the configuration. Relative paths
+// are relative to the configuration directory.
+func (x *opBot) importGlobalBanFeeds() (int, error) {
+	cfgdir, err := configDir()
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, feed := range x.config.GlobalBanFeeds {
+		if !filepath.IsAbs(feed) {
+			feed = filepath.Join(cfgdir, feed)
+		}
+		f, err := os.Open(feed)
+		if err != nil {
+			return total, err
+		}
+		n, err := x.globalBans.importFeed(f, filepath.Base(feed))
+		f.Close()
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("error reading %s: %v", feed, err)
+		}
+		log.Printf("Imported %d users from global ban feed %s", n, feed)
+	}
+	return total, nil
+}
+
+// globalBan adds the user to the global banlist and bans the user from all
+// managed chats.
+func (x *opBot) globalBan(bot kickGetChatMemberer, userID int, reason string, chatID int64) error {
+	if err := x.checkGlobalBan(bot, userID); err != nil {
+		return err
+	}
+	if !x.globalBans.add(userID, reason, chatID) {
+		return nil
+	}
+	promGlobalBanCount.Inc()
+
+	for _, chat := range x.managedChats() {
+		if chat.ChatID == chatID {
+			continue
+		}
+		_, err := bot.KickChatMember(tgbotapi.KickChatMemberConfig{ChatMemberConfig: tgbotapi.ChatMemberConfig{
+			ChatID:             chat.ChatID,
+			SuperGroupUsername: chat.SuperGroupUsername,
+			UserID:             userID,
+		}})
+		if err != nil {
+			log.Printf("Unable to ban uid=%d from chat %d%s: %v", userID, chat.ChatID, chat.SuperGroupUsername, err)
+		}
+	}
+	return nil
+}
+
+// checkGlobalBan returns an error if the user must never be globally banned:
+// administrators of the managed chats, the placeholder users shared by
+// everybody posting on behalf of a chat, and channels (see senderChatUser).
+func (x *opBot) checkGlobalBan(bot getChatMemberer, userID int) error {
+	if userID <= 0 || isPlaceholderUser(userID) {
+		return fmt.Errorf("user %d stands for messages sent on behalf of chats and cannot be banned", userID)
+	}
+	admin, err := x.isManagedChatAdmin(bot, userID)
+	if err != nil {
+		return fmt.Errorf("unable to check if user %d is an administrator: %v", userID, err)
+	}
+	if admin {
+		return fmt.Errorf("user %d is an administrator and cannot be banned", userID)
+	}
+	return nil
+}
+
+// handledGlobalBan bans new users found in the global banlist. Returns true
+// if the user was banned.
+func (x *opBot) handledGlobalBan(bot kickChatMemberer, chatID int64, user tgbotapi.User) bool {
+	gb, ok := x.globalBans.get(user.ID)
+	if !ok {
+		return false
+	}
+
+	log.Printf("User %s (uid=%d) is in the global banlist (%s). Banning.", formatName(user), user.ID, gb.Reason)
+	if err := banUser(bot, chatID, user.ID); err != nil {
+		log.Printf("Unable to ban globally banned user %s (uid=%d): %v", formatName(user), user.ID, err)
+	}
+	promGlobalBanJoinCount.Inc()
+	return true
+}
+
+// gbanHandler adds a user to the global banlist. In a group, in reply to a
+// message, it bans the author of the message and removes it. Otherwise, it
+// takes a user ID. Usage: /gban [user_id] [reason].
+func (x *opBot) gbanHandler(bot tgbotInterface, update tgbotapi.Update) error {
+	msg := update.Message
+	args := strings.TrimSpace(msg.CommandArguments())
+	admin := formatName(*msg.From)
+
+	var userID int
+	var reason string
+	var reply *tgbotapi.Message
+	if r := msg.ReplyToMessage; r != nil && r.From != nil && !isPrivateChat(msg.Chat) {
+		reply = r
+		userID, reason = reply.From.ID, args
+	} else {
+		id, rest, _ := strings.Cut(args, " ")
+		var err error
+		if userID, err = strconv.Atoi(id); err != nil || userID <= 0 {
+			return fmt.Errorf("usage: /gban <user\\_id> \\[reason], or reply to a message with /gban \\[reason]")
+		}
+		reason = strings.TrimSpace(rest)
+	}
+	if err := x.checkGlobalBan(bot, userID); err != nil {
+		return err
+	}
+	if reply != nil {
+		deleteMessage(bot, msg.Chat.ID, reply.MessageID)
+		if err := banUser(bot, msg.Chat.ID, userID); err != nil {
+			log.Printf("Unable to ban uid=%d: %v", userID, err)
+		}
+	}
+
+	if gb, ok := x.globalBans.get(userID); ok {
+		return fmt.Errorf("user %d is already in the global banlist: %s", userID, markdownEscape(gb.Reason))
+	}
+	if reason == "" {
+		reason = "banned by " + admin
+	}
+	if err := x.globalBan(bot, userID, reason, msg.Chat.ID); err != nil {
+		return err
+	}
+
+	_, err := sendReply(bot, msg.Chat.ID, msg.MessageID, fmt.Sprintf("User %d added to the global banlist.", userID))
+	return err
+}
+
+// ungbanHandler removes a user from the global banlist and lifts the ban in
+// all managed chats. Usage: /ungban <user_id>.
+func (x *opBot) ungbanHandler(bot tgbotInterface, update tgbotapi.Update) error {
+	msg := update.Message
+	userID, err := strconv.Atoi(strings.TrimSpace(msg.CommandArguments()))
+	if err != nil {
+		return fmt.Errorf("usage: /ungban <user\\_id>")
+	}
+	if !x.globalBans.remove(userID) {
+		return fmt.Errorf("user %d is not in the global banlist", userID)
+	}
+	log.Printf("User %d removed from the global banlist by %s", userID, formatName(*msg.From))
+
+	// Only lift actual bans, since unbanning a member removes them from the
+	// chat.
+	for _, chat := range x.managedChats() {
+		member, err := bot.GetChatMember(tgbotapi.ChatConfigWithUser{ChatID: chat.ChatID, SuperGroupUsername: chat.SuperGroupUsername, UserID: userID})
+		if err != nil || !member.WasKicked() {
+			continue
+		}
+		if _, err := bot.UnbanChatMember(tgbotapi.ChatMemberConfig{ChatID: chat.ChatID, SuperGroupUsername: chat.SuperGroupUsername, UserID: userID}); err != nil {
+			log.Printf("Unable to unban uid=%d from chat %d%s: %v", userID, chat.ChatID, chat.SuperGroupUsername, err)
+		}
+	}
+
+	_, err = sendReply(bot, msg.Chat.ID, msg.MessageID, fmt.Sprintf("User %d removed from the global banlist.", userID))
+	return err
+}
+
+// gbanExportHandler sends the global banlist as a file, in the format
+// accepted by the ban feeds.
+func (x *opBot) gbanExportHandler(bot tgbotInterface, update tgbotapi.Update) error {
+	doc := tgbotapi.NewDocumentUpload(update.Message.Chat.ID, tgbotapi.FileBytes{
+		Name:  "global_bans.txt",
+		Bytes: x.globalBans.export(),
+	})
+	doc.ReplyToMessageID = update.Message.MessageID
+	_, err := bot.Send(doc)
+	return err
+}
+
+// gbanImportHandler imports the global ban feeds in the configuration again.
+func (x *opBot) gbanImportHandler(bot tgbotInterface, update tgbotapi.Update) error {
+	if len(x.config.GlobalBanFeeds) == 0 {
+		return fmt.Errorf("no global ban feeds configured")
+	}
+	n, err := x.importGlobalBanFeeds()
+	if err != nil {
+		return err
+	}
+	_, err = sendReply(bot, update.Message.Chat.ID, update.Message.MessageID, fmt.Sprintf("%d users added to the global banlist.", n))
+	return err
+}
@@ -0,0 +1,118 @@
+// Unit tests for the global banlist module.
+package main
+
+import (
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+)
+
+func TestGlobalBansFeed(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	g := newGlobalBans()
+	g.add(100, "local ban", -1001)
+
+	feed := `# Known spammers.
+200 crypto spam
+100 should not replace the local entry
+
+300
+bogus line
+-5 negative
+`
+	n, err := g.importFeed(strings.NewReader(feed), "feed.txt")
+	if err != nil {
+		t.Fatalf("importFeed: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("importFeed: got %d users added, want 2", n)
+	}
+
+	caseTests := []struct {
+		userID     int
+		wantReason string
+		wantChat   int64
+	}{
+		{userID: 100, wantReason: "local ban", wantChat: -1001},
+		{userID: 200, wantReason: "crypto spam"},
+		{userID: 300, wantReason: "imported from feed.txt"},
+	}
+	for _, tt := range caseTests {
+		gb, ok := g.get(tt.userID)
+		if !ok || gb.Reason != tt.wantReason || gb.SourceChat != tt.wantChat {
+			t.Errorf("get(%d): got %+v (%v), want reason %q, chat %d", tt.userID, gb, ok, tt.wantReason, tt.wantChat)
+		}
+	}
+
+	// The exported list can be imported back, and survives a reload.
+	export := g.export()
+	other := newGlobalBans()
+	other.globalBansDB = "other_bans.json"
+	if n, err := other.importFeed(strings.NewReader(string(export)), "export"); err != nil || n != 3 {
+		t.Errorf("importFeed(export): got %d users (err=%v), want 3", n, err)
+	}
+	if gb, _ := other.get(200); gb.Reason != "crypto spam" {
+		t.Errorf("importFeed(export): got reason %q for 200, want %q", gb.Reason, "crypto spam")
+	}
+
+	reloaded := newGlobalBans()
+	if err := reloaded.loadGlobalBans(); err != nil {
+		t.Fatalf("loadGlobalBans: %v", err)
+	}
+	if len(reloaded.Users) != 3 {
+		t.Errorf("loadGlobalBans: got %d users, want 3", len(reloaded.Users))
+	}
+
+	if !g.remove(200) || g.remove(200) {
+		t.Errorf("remove(200): expected to succeed only once")
+	}
+}
+
+func TestHandledGlobalBan(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	x := opBot{globalBans: newGlobalBans()}
+	x.globalBans.add(1, "spam", 0)
+
+	bot := &MockTelebot{}
+	bot.On("KickChatMember", tgbotapi.KickChatMemberConfig{ChatMemberConfig: tgbotapi.ChatMemberConfig{ChatID: 10, UserID: 1}}).Return(tgbotapi.APIResponse{Ok: true}, nil).Once()
+
+	if !x.handledGlobalBan(bot, 10, tgbotapi.User{ID: 1}) {
+		t.Errorf("handledGlobalBan: listed user was not banned")
+	}
+	if x.handledGlobalBan(bot, 10, tgbotapi.User{ID: 2}) {
+		t.Errorf("handledGlobalBan: unlisted user was banned")
+	}
+	bot.AssertExpectations(t)
+}
+
+func TestGlobalBanProtectedUsers(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	x := opBot{
+		config:     botConfig{Chats: []chatConfig{{ID: 10}}},
+		globalBans: newGlobalBans(),
+	}
+	bot := &MockTelebot{}
+	bot.On("GetChatMember", tgbotapi.ChatConfigWithUser{ChatID: 10, UserID: 1}).Return(tgbotapi.ChatMember{Status: "administrator"}, nil)
+	bot.On("GetChatMember", tgbotapi.ChatConfigWithUser{ChatID: 10, UserID: 2}).Return(tgbotapi.ChatMember{Status: "member"}, nil)
+	bot.On("KickChatMember", tgbotapi.KickChatMemberConfig{ChatMemberConfig: tgbotapi.ChatMemberConfig{ChatID: 10, UserID: 2}}).Return(tgbotapi.APIResponse{Ok: true}, nil).Once()
+
+	for _, uid := range []int{1, telegramServiceUserID, channelBotUserID, groupAnonymousBotUserID, -1005} {
+		if err := x.globalBan(bot, uid, "spam", 0); err == nil {
+			t.Errorf("globalBan(%d): got no error, want refusal", uid)
+		}
+		if _, ok := x.globalBans.get(uid); ok {
+			t.Errorf("globalBan(%d): protected user added to the global banlist", uid)
+		}
+	}
+	if err := x.globalBan(bot, 2, "spam", 0); err != nil {
+		t.Errorf("globalBan(2): got error %v", err)
+	}
+	if _, ok := x.globalBans.get(2); !ok {
+		t.Errorf("globalBan(2): user not added to the global banlist")
+	}
+	bot.AssertExpectations(t)
+}
@@ -0,0 +1,359 @@
+// Blocklist of spam images, matched by perceptual hashes.
+
+package main
+
+import (
+	"fmt"
+	"image"
+	// Image formats used by Telegram photos.
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"math/bits"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+)
+
+const (
+	// File to store the image blocklist.
+	imageBlocklistDB = "image_blocklist.json"
+
+	// Default maximum distance (in bits) between the hashes of two images
+	// considered the same.
+	defaultImageMaxDistance = 8
+
+	// Photos are hashed from the first size at least this wide (or the
+	// largest one), to save bandwidth.
+	imageHashPhotoWidth = 320
+
+	// Downloads larger than this are not decoded.
+	maxImageDownloadSize = 5 << 20
+)
+
+// imageHTTPClient is used to download the photos to be hashed.
+var imageHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// imageHash holds the perceptual hashes of an image. Both hashes survive
+// small changes to the image, such as scaling, recompression and tweaks to
+// the colors.
+type imageHash struct {
+	// Average hash: pixels brighter than the average.
+	A uint64 `json:"ahash"`
+	// Difference hash: pixels brighter than the next pixel in the row.
+	D uint64 `json:"dhash"`
+}
+
+// String returns the hashes in hexadecimal.
+func (h imageHash) String() string {
+	return fmt.Sprintf("%016x:%016x", h.A, h.D)
+}
+
+// distance returns the largest Hamming distance between the hashes of both
+// images, from 0 (the same image) to 64.
+func (h imageHash) distance(o imageHash) int {
+	return max(bits.OnesCount64(h.A^o.A), bits.OnesCount64(h.D^o.D))
+}
+
+// grayGrid scales the image down to a w x h grid with the average gray level
+// of each cell.
+func grayGrid(img image.Image, w, h int) []float64 {
+	b := img.Bounds()
+	sums := make([]float64, w*h)
+	counts := make([]int, w*h)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := (y - b.Min.Y) * h / b.Dy() * w
+		for x := b.Min.X; x < b.Max.X; x++ {
+			cell := row + (x-b.Min.X)*w/b.Dx()
+			r, g, bl, _ := img.At(x, y).RGBA()
+			sums[cell] += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+			counts[cell]++
+		}
+	}
+	for i := range sums {
+		if counts[i] > 0 {
+			sums[i] /= float64(counts[i])
+		}
+	}
+	return sums
+}
+
+// hashImage returns the perceptual hashes of the image.
+func hashImage(img image.Image) imageHash {
+	var h imageHash
+
+	grid := grayGrid(img, 8, 8)
+	var mean float64
+	for _, v := range grid {
+		mean += v
+	}
+	mean /= float64(len(grid))
+	for i, v := range grid {
+		if v > mean {
+			h.A |= 1 << uint(i)
+		}
+	}
+
+	grid = grayGrid(img, 9, 8)
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if grid[y*9+x] > grid[y*9+x+1] {
+				h.D |= 1 << uint(y*8+x)
+			}
+		}
+	}
+	return h
+}
+
+// hashPhotoID returns the file ID of the size of the photo in the message used
+// for hashing, or an empty string if the message has no photo.
+func hashPhotoID(msg *tgbotapi.Message) string {
+	if msg == nil || msg.Photo == nil || len(*msg.Photo) == 0 {
+		return ""
+	}
+	best := (*msg.Photo)[0]
+	for _, p := range (*msg.Photo)[1:] {
+		switch {
+		case best.Width < imageHashPhotoWidth:
+			// Too small, so any larger size is better.
+			if p.Width > best.Width {
+				best = p
+			}
+		case p.Width >= imageHashPhotoWidth && p.Width < best.Width:
+			best = p
+		}
+	}
+	return best.FileID
+}
+
+// downloadImageHash downloads the file and returns its perceptual hashes.
+func downloadImageHash(bot fileURLGetter, fileID string) (imageHash, error) {
+	url, err := bot.GetFileDirectURL(fileID)
+	if err != nil {
+		return imageHash{}, err
+	}
+	resp, err := imageHTTPClient.Get(url)
+	if err != nil {
+		// The URL contains the bot token, so it is left out of the error.
+		return imageHash{}, fmt.Errorf("error downloading file %s", fileID)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return imageHash{}, fmt.Errorf("error downloading file %s: %s", fileID, resp.Status)
+	}
+
+	img, _, err := image.Decode(io.LimitReader(resp.Body, maxImageDownloadSize))
+	if err != nil {
+		return imageHash{}, fmt.Errorf("error decoding file %s: %v", fileID, err)
+	}
+	return hashImage(img), nil
+}
+
+// blockedImage holds the information about an image in the blocklist.
+type blockedImage struct {
+	Hash imageHash `json:"hash"`
+	// Admin who added the image.
+	AddedBy int `json:"added_by"`
+	// Chat where the image was posted.
+	SourceChat int64 `json:"source_chat"`
+	// When the image was added to the blocklist.
+	Time time.Time `json:"time"`
+}
+
+// imageBlocklist holds the images blocked in all managed chats.
+type imageBlocklist struct {
+	sync.RWMutex
+	Images           []blockedImage
+	imageBlocklistDB string
+}
+
+// newImageBlocklist creates a new imageBlocklist object.
+func newImageBlocklist() *imageBlocklist {
+	return &imageBlocklist{
+		imageBlocklistDB: imageBlocklistDB,
+	}
+}
+
+// loadImageBlocklist loads the image blocklist from the disk.
+func (ib *imageBlocklist) loadImageBlocklist() error {
+	ib.Lock()
+	defer ib.Unlock()
+
+	err := readJSONFromDataDir(&ib.Images, ib.imageBlocklistDB)
+	promImageBlocklistSize.Set(float64(len(ib.Images)))
+	return err
+}
+
+// save saves the image blocklist to the disk. Locks are assumed to be taken
+// care of by the caller.
+func (ib *imageBlocklist) save() {
+	promImageBlocklistSize.Set(float64(len(ib.Images)))
+	if err := safeWriteJSON(ib.Images, ib.imageBlocklistDB); err != nil {
+		log.Printf("Error saving image blocklist: %v", err)
+	}
+}
+
+// add adds the image to the blocklist. Returns false if the same image is
+// already there.
+func (ib *imageBlocklist) add(img blockedImage) bool {
+	ib.Lock()
+	defer ib.Unlock()
+
+	for _, bi := range ib.Images {
+		if bi.Hash == img.Hash {
+			return false
+		}
+	}
+	ib.Images = append(ib.Images, img)
+	ib.save()
+	return true
+}
+
+// remove removes the images within maxDistance of the hash from the
+// blocklist. Returns the number of images removed.
+func (ib *imageBlocklist) remove(hash imageHash, maxDistance int) int {
+	ib.Lock()
+	defer ib.Unlock()
+
+	var kept []blockedImage
+	for _, bi := range ib.Images {
+		if bi.Hash.distance(hash) > maxDistance {
+			kept = append(kept, bi)
+		}
+	}
+	removed := len(ib.Images) - len(kept)
+	if removed > 0 {
+		ib.Images = kept
+		ib.save()
+	}
+	return removed
+}
+
+// match returns the image in the blocklist closest to the hash, if within
+// maxDistance, and its distance.
+func (ib *imageBlocklist) match(hash imageHash, maxDistance int) (blockedImage, int, bool) {
+	ib.RLock()
+	defer ib.RUnlock()
+
+	var found blockedImage
+	best := maxDistance + 1
+	for _, bi := range ib.Images {
+		if d := bi.Hash.distance(hash); d < best {
+			found, best = bi, d
+		}
+	}
+	return found, best, best <= maxDistance
+}
+
+// size returns the number of images in the blocklist.
+func (ib *imageBlocklist) size() int {
+	ib.RLock()
+	defer ib.RUnlock()
+	return len(ib.Images)
+}
+
+// maxDistance returns the maximum distance between images considered the same
+// in the chat.
+func (c imageConfig) maxDistance() int {
+	if c.MaxDistance <= 0 {
+		return defaultImageMaxDistance
+	}
+	return c.MaxDistance
+}
+
+// checkBlockedImage checks photos from users under probation (or everyone,
+// if configured) against the image blocklist. The photo is downloaded and
+// checked in the background: the configured action is performed on matches,
+// and photos found clean are counted as messages that survived moderation.
+// Returns true if the photo is being checked.
+func (x *opBot) checkBlockedImage(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) bool {
+	cfg := x.config.forChat(msg.Chat.ID).ImageBlocklist
+	fileID := hashPhotoID(msg)
+	if !cfg.Enabled || fileID == "" || x.images.size() == 0 {
+		return false
+	}
+	if !cfg.Everyone && !x.onProbation(msg.Chat.ID, msg.From.ID) {
+		return false
+	}
+
+	go func() {
+		hash, err := downloadImageHash(bot, fileID)
+		if err != nil {
+			log.Printf("Unable to hash photo in message %d, chat %d: %v", msg.MessageID, msg.Chat.ID, err)
+			return
+		}
+		blocked, distance, ok := x.images.match(hash, cfg.maxDistance())
+		if !ok {
+			x.countSurvivingMessage(msg)
+			return
+		}
+
+		action := actionFromString(cfg.Action)
+		if action == opNoAction {
+			action = opBan
+		}
+		log.Printf("Blocked image (hash %s, distance %d) from user %s (uid=%d) in chat %d, action %q", blocked.Hash, distance, formatName(*msg.From), msg.From.ID, msg.Chat.ID, action.String())
+		promImageBlockedCount.Inc()
+
+		if err := x.performPatternAction(bot, msg, action, cfg.Duration.Duration, "blocked image "+blocked.Hash.String()); err != nil {
+			log.Printf("Error handling blocked image: %v", err)
+		}
+	}()
+	return true
+}
+
+// blockImage downloads the photo and adds it to the image blocklist.
+func (x *opBot) blockImage(bot fileURLGetter, fileID string, admin tgbotapi.User, chatID int64) (imageHash, bool, error) {
+	hash, err := downloadImageHash(bot, fileID)
+	if err != nil {
+		return imageHash{}, false, err
+	}
+	added := x.images.add(blockedImage{Hash: hash, AddedBy: admin.ID, SourceChat: chatID, Time: time.Now()})
+	if added {
+		log.Printf("Image %s added to the blocklist by %s (uid=%d)", hash, formatName(admin), admin.ID)
+	}
+	return hash, added, nil
+}
+
+// imageBlockHandler adds the photo replied to to the image blocklist, and
+// removes the message. With "del", it removes the photo from the blocklist
+// instead. Usage: /image_block [del], in reply to a photo.
+func (x *opBot) imageBlockHandler(bot tgbotInterface, update tgbotapi.Update) error {
+	msg := update.Message
+	fileID := hashPhotoID(msg.ReplyToMessage)
+	if fileID == "" {
+		return fmt.Errorf("usage: /image\\_block \\[del], in reply to a photo (%d images in the blocklist)", x.images.size())
+	}
+
+	switch strings.TrimSpace(msg.CommandArguments()) {
+	case "":
+		hash, added, err := x.blockImage(bot, fileID, *msg.From, msg.Chat.ID)
+		if err != nil {
+			return err
+		}
+		if !added {
+			return fmt.Errorf("image %s is already in the blocklist", hash)
+		}
+		deleteMessage(bot, msg.Chat.ID, msg.ReplyToMessage.MessageID)
+		_, err = sendReply(bot, msg.Chat.ID, msg.MessageID, fmt.Sprintf("Image %s added to the blocklist.", hash))
+		return err
+
+	case "del":
+		hash, err := downloadImageHash(bot, fileID)
+		if err != nil {
+			return err
+		}
+		removed := x.images.remove(hash, x.config.forChat(msg.Chat.ID).ImageBlocklist.maxDistance())
+		if removed == 0 {
+			return fmt.Errorf("image %s is not in the blocklist", hash)
+		}
+		log.Printf("Image %s removed from the blocklist by %s (%d entries)", hash, formatName(*msg.From), removed)
+		_, err = sendReply(bot, msg.Chat.ID, msg.MessageID, fmt.Sprintf("Image %s removed from the blocklist (%d entries).", hash, removed))
+		return err
+	}
+	return fmt.Errorf("usage: /image\\_block \\[del], in reply to a photo")
+}
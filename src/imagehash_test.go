@@ -0,0 +1,115 @@
+// Unit tests for the image blocklist module.
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+)
+
+// testImage returns a w x h image with large blocks of different gray levels,
+// made brighter by delta. With invert set, the blocks are reversed.
+func testImage(w, h, delta int, invert bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := (x*4/w)*60 + (y*3/h)*20
+			if invert {
+				v = 240 - v
+			}
+			v = min(v+delta, 255)
+			img.Set(x, y, color.RGBA{uint8(v), uint8(v), uint8(v), 255})
+		}
+	}
+	return img
+}
+
+func TestHashImage(t *testing.T) {
+	orig := hashImage(testImage(400, 300, 0, false))
+
+	caseTests := []struct {
+		name     string
+		img      image.Image
+		wantSame bool
+	}{
+		{name: "Same image", img: testImage(400, 300, 0, false), wantSame: true},
+		{name: "Scaled down", img: testImage(200, 150, 0, false), wantSame: true},
+		{name: "Brighter", img: testImage(400, 300, 12, false), wantSame: true},
+		{name: "Different image", img: testImage(400, 300, 0, true), wantSame: false},
+	}
+	for _, tt := range caseTests {
+		d := orig.distance(hashImage(tt.img))
+		if got := d <= defaultImageMaxDistance; got != tt.wantSame {
+			t.Errorf("%s: got distance %d, want same image = %v", tt.name, d, tt.wantSame)
+		}
+	}
+}
+
+func TestHashPhotoID(t *testing.T) {
+	caseTests := []struct {
+		sizes []tgbotapi.PhotoSize
+		want  string
+	}{
+		{sizes: nil, want: ""},
+		{sizes: []tgbotapi.PhotoSize{{FileID: "s", Width: 90}, {FileID: "m", Width: 320}, {FileID: "x", Width: 800}}, want: "m"},
+		{sizes: []tgbotapi.PhotoSize{{FileID: "x", Width: 800}, {FileID: "m", Width: 400}, {FileID: "s", Width: 90}}, want: "m"},
+		// No size large enough.
+		{sizes: []tgbotapi.PhotoSize{{FileID: "s", Width: 90}, {FileID: "m", Width: 200}}, want: "m"},
+	}
+	for _, tt := range caseTests {
+		msg := &tgbotapi.Message{}
+		if tt.sizes != nil {
+			msg.Photo = &tt.sizes
+		}
+		if got := hashPhotoID(msg); got != tt.want {
+			t.Errorf("hashPhotoID(%v): got %q, want %q", tt.sizes, got, tt.want)
+		}
+	}
+}
+
+func TestImageBlocklist(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	// The bot returns the URL of a test server holding the image.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		png.Encode(w, testImage(400, 300, 0, false))
+	}))
+	defer server.Close()
+	mockTelebot := &MockTelebot{}
+	mockTelebot.On("GetFileDirectURL", "spam").Return(server.URL, nil)
+
+	x := opBot{images: newImageBlocklist()}
+	hash, added, err := x.blockImage(mockTelebot, "spam", tgbotapi.User{ID: userID}, chatID)
+	if err != nil || !added {
+		t.Fatalf("blockImage: got added = %v, error %v", added, err)
+	}
+	if _, added, _ = x.blockImage(mockTelebot, "spam", tgbotapi.User{ID: userID}, chatID); added {
+		t.Errorf("blockImage: image added twice")
+	}
+
+	similar := hashImage(testImage(200, 150, 8, false))
+	if bi, _, ok := x.images.match(similar, defaultImageMaxDistance); !ok || bi.Hash != hash || bi.AddedBy != userID {
+		t.Errorf("match: got %+v, %v, want image %s added by %d", bi, ok, hash, userID)
+	}
+	if _, _, ok := x.images.match(hashImage(testImage(400, 300, 0, true)), defaultImageMaxDistance); ok {
+		t.Errorf("match: different image matched the blocklist")
+	}
+
+	// The blocklist survives a reload.
+	reloaded := newImageBlocklist()
+	if err := reloaded.loadImageBlocklist(); err != nil {
+		t.Fatalf("loadImageBlocklist: %v", err)
+	}
+	if _, _, ok := reloaded.match(hash, 0); !ok {
+		t.Errorf("loadImageBlocklist: image %s not found", hash)
+	}
+
+	if got := x.images.remove(similar, defaultImageMaxDistance); got != 1 || x.images.size() != 0 {
+		t.Errorf("remove: got %d images removed, %d left, want 1 removed, none left", got, x.images.size())
+	}
+}
@@ -0,0 +1,209 @@
+// Detection of users impersonating the admins of a chat.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+	"github.com/patrickmn/go-cache"
+)
+
+const (
+	// Default minimum similarity (in percent) between the names of a user
+	// and an admin for the user to be considered an impostor.
+	defaultImpostorThreshold = 80
+
+	// Names shorter than this (after normalization) are too common to be
+	// compared.
+	impostorMinNameLen = 4
+
+	// How long the list of admins of each chat is cached.
+	impostorAdminCacheTime = 10 * time.Minute
+
+	// Restrictions longer than 366 days are permanent.
+	impostorPermanentRestriction = 400 * 24 * time.Hour
+)
+
+// impostorKey returns the normalized version of a name used for comparisons:
+// lowercase, with lookalike characters replaced, and only letters and digits.
+func impostorKey(s string) string {
+	var b strings.Builder
+	for _, r := range skeleton(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// editDistance returns the Levenshtein distance between the strings, in
+// runes.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// nameSimilarity returns how similar the names are, in percent, based on the
+// edit distance between their normalized versions. Names too short to be
+// compared have no similarity.
+func nameSimilarity(a, b string) int {
+	ka, kb := impostorKey(a), impostorKey(b)
+	la, lb := len([]rune(ka)), len([]rune(kb))
+	if la < impostorMinNameLen || lb < impostorMinNameLen {
+		return 0
+	}
+	return 100 - editDistance(ka, kb)*100/max(la, lb)
+}
+
+// userNames returns the names of the user compared with the names of the
+// admins: the nickname and the username.
+func userNames(user tgbotapi.User) []string {
+	return []string{strings.TrimSpace(user.FirstName + " " + user.LastName), user.UserName}
+}
+
+// impostorScore returns the admin the user resembles the most, and the
+// similarity between their names, in percent. Admins are never impostors of
+// themselves, or of each other.
+func impostorScore(user tgbotapi.User, admins []tgbotapi.User) (tgbotapi.User, int) {
+	var found tgbotapi.User
+	best := 0
+	for _, admin := range admins {
+		if admin.ID == user.ID {
+			return tgbotapi.User{}, 0
+		}
+	}
+	for _, admin := range admins {
+		for _, name := range userNames(user) {
+			for _, adminName := range userNames(admin) {
+				if score := nameSimilarity(name, adminName); score > best {
+					found, best = admin, score
+				}
+			}
+		}
+	}
+	return found, best
+}
+
+// threshold returns the minimum similarity for impostors in the chat.
+func (c impostorConfig) threshold() int {
+	if c.Threshold <= 0 {
+		return defaultImpostorThreshold
+	}
+	return c.Threshold
+}
+
+// chatAdmins returns the admins of the chat (bots excluded), caching the
+// list for a few minutes.
+func (x *opBot) chatAdmins(bot tgbotInterface, chatID int64) ([]tgbotapi.User, error) {
+	key := strconv.FormatInt(chatID, 10)
+	if v, ok := x.impostorAdmins.Get(key); ok {
+		return v.([]tgbotapi.User), nil
+	}
+	members, err := bot.GetChatAdministrators(tgbotapi.ChatConfig{ChatID: chatID})
+	if err != nil {
+		return nil, err
+	}
+	var admins []tgbotapi.User
+	for _, m := range members {
+		if m.User != nil && !m.User.IsBot {
+			admins = append(admins, *m.User)
+		}
+	}
+	x.impostorAdmins.Set(key, admins, impostorAdminCacheTime)
+	return admins, nil
+}
+
+// nameChanged records the names of the user in the chat, and returns true if
+// they are different from the ones seen before (or if the user was never seen
+// before, e.g. after the bot restarts).
+func (x *opBot) nameChanged(chatID int64, user tgbotapi.User) bool {
+	key := chatUserKey(chatID, user.ID)
+	names := strings.Join(userNames(user), "\x00")
+	if v, ok := x.knownNames.Get(key); ok && v.(string) == names {
+		return false
+	}
+	x.knownNames.Set(key, names, cache.DefaultExpiration)
+	return true
+}
+
+// handledImpostor compares the names of the user with the names of the admins
+// of the chat, and restricts suspected impostors, notifying the admins. The
+// trigger ("join" or "name change") is used in logs and metrics. Returns true
+// if the user was restricted.
+func (x *opBot) handledImpostor(bot tgbotInterface, chat *tgbotapi.Chat, user tgbotapi.User, trigger string) bool {
+	cfg := x.config.forChat(chat.ID).Impostors
+	if !cfg.Enabled || user.IsBot {
+		return false
+	}
+
+	admins, err := x.chatAdmins(bot, chat.ID)
+	if err != nil {
+		log.Printf("Unable to get the administrators of chat %d: %v", chat.ID, err)
+		return false
+	}
+	admin, score := impostorScore(user, admins)
+	if score < cfg.threshold() {
+		return false
+	}
+
+	promImpostorCount.WithLabelValues(trigger).Inc()
+	log.Printf("User %s (uid=%d) looks like admin %s (uid=%d) in chat %d on %s: %d%% similar", formatName(user), user.ID, formatName(admin), admin.ID, chat.ID, trigger, score)
+
+	d := cfg.RestrictTime.Duration
+	if d <= 0 {
+		d = impostorPermanentRestriction
+	}
+	outcome := "user restricted"
+	if err := muteUserUntil(bot, chat.ID, user.ID, time.Now().Add(d)); err != nil {
+		log.Printf("Error restricting impostor %s (uid=%d) in chat %d: %v", formatName(user), user.ID, chat.ID, err)
+		outcome = "unable to restrict user"
+	}
+
+	username := ""
+	if user.UserName != "" {
+		username = " @" + markdownEscape(user.UserName)
+	}
+	notifyChatAdmins(bot, chat.ID, []string{fmt.Sprintf("Possible impostor of %s in %s (%s): %s%s (uid=%d), %d%% similar. %s.",
+		formatName(admin), markdownEscape(chat.Title), trigger, formatName(user), username, user.ID, score, outcome)})
+	return true
+}
+
+// handledImpostorMessage checks the author of the message for impersonation
+// when their names change. Messages from suspected impostors are removed.
+// Returns true if the author was restricted.
+func (x *opBot) handledImpostorMessage(bot tgbotInterface, msg *tgbotapi.Message) bool {
+	if msg.From == nil || isPrivateChat(msg.Chat) || !x.config.forChat(msg.Chat.ID).Impostors.Enabled {
+		return false
+	}
+	if !x.nameChanged(msg.Chat.ID, *msg.From) {
+		return false
+	}
+	if !x.handledImpostor(bot, msg.Chat, *msg.From, "name change") {
+		return false
+	}
+	x.quarantineMessage(msg, "possible impostor")
+	deleteMessage(bot, msg.Chat.ID, msg.MessageID)
+	return true
+}
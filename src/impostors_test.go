@@ -0,0 +1,100 @@
+// Unit tests for the impostors module.
+package main
+
+import (
+	"testing"
+	"time"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+	"github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestEditDistance(t *testing.T) {
+	caseTests := []struct {
+		a, b string
+		want int
+	}{
+		{a: "", b: "", want: 0},
+		{a: "abc", b: "", want: 3},
+		{a: "kitten", b: "sitting", want: 3},
+		{a: "marcopaganini", b: "marcopaganinl", want: 1},
+		{a: "ação", b: "acao", want: 2},
+	}
+	for _, tt := range caseTests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q): got %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestImpostorScore(t *testing.T) {
+	admins := []tgbotapi.User{
+		{ID: 1, FirstName: "Marco", LastName: "Paganini", UserName: "marcopaganini"},
+		{ID: 2, FirstName: "Ana", UserName: "ana_admin"},
+	}
+
+	caseTests := []struct {
+		name      string
+		user      tgbotapi.User
+		wantAdmin int
+		wantMin   int
+		wantMax   int
+	}{
+		{name: "Same nickname", user: tgbotapi.User{ID: 10, FirstName: "Marco", LastName: "Paganini"}, wantAdmin: 1, wantMin: 100, wantMax: 100},
+		{name: "Cyrillic lookalikes", user: tgbotapi.User{ID: 10, FirstName: "Mаrcо Pаgаnini"}, wantAdmin: 1, wantMin: 100, wantMax: 100},
+		{name: "Accents and punctuation", user: tgbotapi.User{ID: 10, FirstName: "Márco_Pagani.ni"}, wantAdmin: 1, wantMin: 100, wantMax: 100},
+		{name: "Similar username", user: tgbotapi.User{ID: 10, FirstName: "Support", UserName: "marcopaganlni_"}, wantAdmin: 1, wantMin: 90, wantMax: 99},
+		{name: "Nickname like username", user: tgbotapi.User{ID: 10, FirstName: "ana admin"}, wantAdmin: 2, wantMin: 100, wantMax: 100},
+		{name: "Different user", user: tgbotapi.User{ID: 10, FirstName: "Joana", LastName: "Silva"}, wantMax: 50},
+		// Short names are too common to be compared.
+		{name: "Short name", user: tgbotapi.User{ID: 10, FirstName: "Ana"}, wantMax: 0},
+		{name: "Admin", user: admins[0], wantMax: 0},
+	}
+	for _, tt := range caseTests {
+		admin, got := impostorScore(tt.user, admins)
+		if got < tt.wantMin || got > tt.wantMax || got >= tt.wantMin && tt.wantAdmin != 0 && admin.ID != tt.wantAdmin {
+			t.Errorf("%s: got %d%% similar to admin %d, want %d-%d%% similar to admin %d", tt.name, got, admin.ID, tt.wantMin, tt.wantMax, tt.wantAdmin)
+		}
+	}
+}
+
+func TestHandledImpostorMessage(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	x := opBot{
+		config: botConfig{
+			Chats: []chatConfig{{ID: chatID, Impostors: impostorConfig{Enabled: true}}},
+		},
+		impostorAdmins: cache.New(time.Minute, time.Minute),
+		knownNames:     cache.New(time.Minute, time.Minute),
+		quarantine:     newQuarantine(),
+	}
+	admin := tgbotapi.User{ID: userID + 1, FirstName: "Marco", LastName: "Paganini"}
+	chat := &tgbotapi.Chat{ID: chatID, Type: "supergroup"}
+
+	mockTelebot := &MockTelebot{}
+	mockTelebot.On("GetChatAdministrators", tgbotapi.ChatConfig{ChatID: chatID}).Return([]tgbotapi.ChatMember{{User: &admin}}, nil)
+	mockTelebot.On("Send", mock.Anything).Return(tgbotapi.Message{}, nil)
+	mockTelebot.On("RestrictChatMember", mock.Anything).Return(tgbotapi.APIResponse{}, nil).Once()
+	mockTelebot.On("DeleteMessage", tgbotapi.DeleteMessageConfig{ChatID: chatID, MessageID: msgID + 2}).Return(tgbotapi.APIResponse{}, nil).Once()
+
+	caseTests := []struct {
+		name        string
+		user        tgbotapi.User
+		wantHandled bool
+	}{
+		{name: "First message", user: tgbotapi.User{ID: userID, FirstName: "John"}},
+		{name: "Same name", user: tgbotapi.User{ID: userID, FirstName: "John"}},
+		{name: "Name changed to an admin's", user: tgbotapi.User{ID: userID, FirstName: "Marco", LastName: "Pаganini"}, wantHandled: true},
+	}
+	for i, tt := range caseTests {
+		msg := &tgbotapi.Message{MessageID: msgID + i, From: &tt.user, Chat: chat, Text: "hello"}
+		if got := x.handledImpostorMessage(mockTelebot, msg); got != tt.wantHandled {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.wantHandled)
+		}
+	}
+	mockTelebot.AssertExpectations(t)
+	// The list of admins is cached (the second call notifies them).
+	mockTelebot.AssertNumberOfCalls(t, "GetChatAdministrators", 2)
+}
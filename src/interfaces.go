@@ -21,6 +21,7 @@ type notificationsInterface interface {
 type bansInterface interface {
 	banRequestHandler(tgbotInterface, tgbotapi.Update) error
 	deleteMessageFromBanRequest(tgbotInterface, *tgbotapi.User, string, bool) error
+	banRequestInfo(string) (banRequest, bool)
 	loadBanRequestsInfo() error
 }
 
@@ -39,8 +40,11 @@ type tgbotInterface interface {
 	DeleteMessage(tgbotapi.DeleteMessageConfig) (tgbotapi.APIResponse, error)
 	GetChatAdministrators(tgbotapi.ChatConfig) ([]tgbotapi.ChatMember, error)
 	GetChatMember(tgbotapi.ChatConfigWithUser) (tgbotapi.ChatMember, error)
+	GetFileDirectURL(string) (string, error)
 	GetUpdatesChan(tgbotapi.UpdateConfig) (tgbotapi.UpdatesChannel, error)
+	GetUserProfilePhotos(tgbotapi.UserProfilePhotosConfig) (tgbotapi.UserProfilePhotos, error)
 	KickChatMember(tgbotapi.KickChatMemberConfig) (tgbotapi.APIResponse, error)
+	RestrictChatMember(tgbotapi.RestrictChatMemberConfig) (tgbotapi.APIResponse, error)
 	UnbanChatMember(tgbotapi.ChatMemberConfig) (tgbotapi.APIResponse, error)
 	Send(tgbotapi.Chattable) (tgbotapi.Message, error)
 }
@@ -66,6 +70,23 @@ type kickChatMemberer interface {
 	KickChatMember(tgbotapi.KickChatMemberConfig) (tgbotapi.APIResponse, error)
 }
 
+type kickGetChatMemberer interface {
+	KickChatMember(tgbotapi.KickChatMemberConfig) (tgbotapi.APIResponse, error)
+	GetChatMember(tgbotapi.ChatConfigWithUser) (tgbotapi.ChatMember, error)
+}
+
 type unbanChatMemberer interface {
 	UnbanChatMember(tgbotapi.ChatMemberConfig) (tgbotapi.APIResponse, error)
 }
+
+type restrictChatMemberer interface {
+	RestrictChatMember(tgbotapi.RestrictChatMemberConfig) (tgbotapi.APIResponse, error)
+}
+
+type fileURLGetter interface {
+	GetFileDirectURL(string) (string, error)
+}
+
+type getUserProfilePhotoser interface {
+	GetUserProfilePhotos(tgbotapi.UserProfilePhotosConfig) (tgbotapi.UserProfilePhotos, error)
+}
@@ -0,0 +1,100 @@
+// Detection of users who join, post and leave before anyone can report them.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+)
+
+// Maximum number of messages tracked for each user who joined recently.
+const maxRecentJoinMessages = 50
+
+// recentJoin holds the join time of a user, and the messages the user posted
+// since then.
+type recentJoin struct {
+	Joined   time.Time
+	Messages []*tgbotapi.Message
+}
+
+// trackJoin starts tracking the messages of a user who just joined, in chats
+// with join-spam-leave detection enabled. Users are tracked for the configured
+// window only.
+func (x *opBot) trackJoin(chatID int64, userID int, now time.Time) {
+	window := x.config.forChat(chatID).JoinSpamLeave.Window.Duration
+	if window <= 0 {
+		return
+	}
+	x.recentJoins.Set(chatUserKey(chatID, userID), &recentJoin{Joined: now}, window)
+}
+
+// trackJoinMessage records the message if its author joined recently. Only
+// messages surviving moderation are recorded. The index is only used from the
+// main loop, so no locking is needed.
+func (x *opBot) trackJoinMessage(msg *tgbotapi.Message) {
+	if msg.From == nil || isPrivateChat(msg.Chat) {
+		return
+	}
+	v, ok := x.recentJoins.Get(chatUserKey(msg.Chat.ID, msg.From.ID))
+	if !ok {
+		return
+	}
+	rj := v.(*recentJoin)
+	if len(rj.Messages) < maxRecentJoinMessages {
+		rj.Messages = append(rj.Messages, msg)
+	}
+}
+
+// leftChatMember returns the user in the update if the user left the chat on
+// their own, or nil otherwise (E.g. users kicked by admins or by the bot).
+func leftChatMember(update tgbotapi.Update) *tgbotapi.User {
+	cm := update.ChatMember
+	if cm == nil || cm.NewChatMember == nil || cm.NewChatMember.User == nil || cm.NewChatMember.Status != "left" {
+		return nil
+	}
+	if cm.From != nil && cm.From.ID != cm.NewChatMember.User.ID {
+		return nil
+	}
+	return cm.NewChatMember.User
+}
+
+// handledJoinSpamLeave handles users leaving a chat shortly after joining. If
+// they posted anything in the meantime, their messages are deleted and they
+// are banned, so they cannot come back. Returns true if the user was banned.
+func (x *opBot) handledJoinSpamLeave(bot tgbotInterface, update tgbotapi.Update) bool {
+	user := leftChatMember(update)
+	if user == nil {
+		return false
+	}
+	chat := update.ChatMember.Chat
+	key := chatUserKey(chat.ID, user.ID)
+	v, ok := x.recentJoins.Get(key)
+	if !ok {
+		return false
+	}
+	x.recentJoins.Delete(key)
+
+	rj := v.(*recentJoin)
+	if len(rj.Messages) == 0 {
+		return false
+	}
+
+	stay := time.Since(rj.Joined).Round(time.Second)
+	log.Printf("User %s (uid=%d) left chat %d %v after joining and posting %d messages. Deleting messages and banning.", formatName(*user), user.ID, chat.ID, stay, len(rj.Messages))
+	promJoinSpamLeaveCount.Inc()
+
+	for _, msg := range rj.Messages {
+		x.quarantineMessage(msg, "join-spam-leave")
+		deleteMessage(bot, chat.ID, msg.MessageID)
+	}
+	if err := banUser(bot, chat.ID, user.ID); err != nil {
+		log.Printf("Error banning user %s (uid=%d) from chat %d: %v", formatName(*user), user.ID, chat.ID, err)
+	}
+
+	notifyChatAdmins(bot, chat.ID, []string{fmt.Sprintf("%s (uid=%d) left %s %v after joining. %d messages deleted, user banned.",
+		formatName(*user), user.ID, markdownEscape(chat.Title), stay, len(rj.Messages))})
+	return true
+}
@@ -0,0 +1,82 @@
+// Unit tests for the join-spam-leave module.
+package main
+
+import (
+	"testing"
+	"time"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+	"github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/mock"
+)
+
+// leaveUpdate returns an update for the user leaving the chat, on their own or
+// removed by someone else.
+func leaveUpdate(user, by tgbotapi.User, status string) tgbotapi.Update {
+	return tgbotapi.Update{
+		ChatMember: &tgbotapi.ChatMemberUpdate{
+			Chat:          &tgbotapi.Chat{ID: chatID, Title: "Test"},
+			From:          &by,
+			NewChatMember: &tgbotapi.NewChatMember{User: &user, Status: status},
+		},
+	}
+}
+
+func TestJoinSpamLeave(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	x := opBot{
+		config: botConfig{
+			Chats: []chatConfig{{ID: chatID, JoinSpamLeave: joinSpamLeaveConfig{Window: duration{10 * time.Minute}}}},
+		},
+		recentJoins: cache.New(time.Minute, time.Minute),
+		globalBans:  newGlobalBans(),
+		quarantine:  newQuarantine(),
+	}
+	spammer := tgbotapi.User{ID: userID}
+	quiet := tgbotapi.User{ID: userID + 1}
+	kicked := tgbotapi.User{ID: userID + 2}
+	admin := tgbotapi.User{ID: userID + 3}
+
+	for _, u := range []tgbotapi.User{spammer, quiet, kicked} {
+		x.trackJoin(chatID, u.ID, time.Now())
+	}
+	chat := &tgbotapi.Chat{ID: chatID}
+	for i, u := range []tgbotapi.User{spammer, spammer, kicked} {
+		x.trackJoinMessage(&tgbotapi.Message{MessageID: msgID + i, From: &u, Chat: chat, Text: "buy now"})
+	}
+	// Users who joined before the bot started are not tracked.
+	x.trackJoinMessage(&tgbotapi.Message{MessageID: msgID + 10, From: &admin, Chat: chat, Text: "hello"})
+
+	mockTelebot := &MockTelebot{}
+	mockTelebot.On("DeleteMessage", tgbotapi.DeleteMessageConfig{ChatID: chatID, MessageID: msgID}).Return(tgbotapi.APIResponse{}, nil).Once()
+	mockTelebot.On("DeleteMessage", tgbotapi.DeleteMessageConfig{ChatID: chatID, MessageID: msgID + 1}).Return(tgbotapi.APIResponse{}, nil).Once()
+	mockTelebot.On("KickChatMember", mock.Anything).Return(tgbotapi.APIResponse{}, nil).Once()
+	mockTelebot.On("GetChatAdministrators", tgbotapi.ChatConfig{ChatID: chatID}).Return([]tgbotapi.ChatMember{}, nil).Once()
+
+	caseTests := []struct {
+		name       string
+		update     tgbotapi.Update
+		wantBanned bool
+	}{
+		{name: "Posted and left", update: leaveUpdate(spammer, spammer, "left"), wantBanned: true},
+		{name: "Left without posting", update: leaveUpdate(quiet, quiet, "left")},
+		{name: "Removed by an admin", update: leaveUpdate(kicked, admin, "left")},
+		{name: "Not tracked", update: leaveUpdate(admin, admin, "left")},
+		{name: "Joined", update: leaveUpdate(spammer, spammer, "member")},
+	}
+	for _, tt := range caseTests {
+		if got := x.handledJoinSpamLeave(mockTelebot, tt.update); got != tt.wantBanned {
+			t.Errorf("%s: got banned = %v, want %v", tt.name, got, tt.wantBanned)
+		}
+	}
+	mockTelebot.AssertExpectations(t)
+
+	// Users are only banned from the chat.
+	if _, ok := x.globalBans.get(spammer.ID); ok {
+		t.Errorf("globalBans: user %d in the global banlist", spammer.ID)
+	}
+	if got := x.quarantine.list(defaultQuarantineTime, func(e quarantineEntry) bool { return e.User.ID == spammer.ID }); len(got) != 2 {
+		t.Errorf("quarantine: got %d messages from user %d, want 2", len(got), spammer.ID)
+	}
+}
@@ -0,0 +1,259 @@
+// Link filtering for the bot.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+	"github.com/patrickmn/go-cache"
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+)
+
+// linkRegex finds things that look like links in plain text: an optional
+// scheme, followed by at least two dot separated labels, and an optional path.
+var linkRegex = regexp.MustCompile(`(?i)(?:https?://)?(?:[\p{L}\p{N}](?:[\p{L}\p{N}-]*[\p{L}\p{N}])?\.)+\p{L}{2,}(?::\d+)?(?:/[^\s]*)?`)
+
+// linkFilterResult describes why a link was rejected.
+type linkFilterResult int
+
+const (
+	// linkAccepted means all links in the message are acceptable.
+	linkAccepted linkFilterResult = iota
+	// linkBlocked means the message contains a link to a blocked domain.
+	linkBlocked
+	// linkProbation means a user under probation posted a link to a domain
+	// not in the allowlist.
+	linkProbation
+)
+
+// String returns the linkFilterResult as a string.
+func (r linkFilterResult) String() string {
+	results := map[linkFilterResult]string{
+		linkBlocked:   "blocklist",
+		linkProbation: "probation",
+	}
+	if result, ok := results[r]; ok {
+		return result
+	}
+	return "accepted"
+}
+
+// entityText returns the text covered by a message entity. Telegram measures
+// offsets and lengths in UTF-16 code units, so we convert the text first.
+func entityText(text string, entity tgbotapi.MessageEntity) string {
+	units := utf16.Encode([]rune(text))
+	if entity.Offset < 0 || entity.Length < 0 || entity.Offset+entity.Length > len(units) {
+		return ""
+	}
+	return string(utf16.Decode(units[entity.Offset : entity.Offset+entity.Length]))
+}
+
+// extractLinks returns all links in a message: URL and text_link entities,
+// plus anything that looks like a link in the text and caption. The same
+// link may be returned more than once.
+func extractLinks(msg *tgbotapi.Message) []string {
+	if msg == nil {
+		return nil
+	}
+
+	var links []string
+	if msg.Entities != nil {
+		for _, entity := range *msg.Entities {
+			switch entity.Type {
+			case "url":
+				if s := entityText(msg.Text, entity); s != "" {
+					links = append(links, s)
+				}
+			case "text_link":
+				// The link hides behind some unrelated text. Internal
+				// Telegram links (tg://) are not links to other sites.
+				if entity.URL != "" && !strings.HasPrefix(entity.URL, "tg:") {
+					links = append(links, entity.URL)
+				}
+			}
+		}
+	}
+
+	// Telegram does not always create entities (and we do not get the
+	// caption entities at all), so scan the plain text as well.
+	for _, text := range []string{msg.Text, msg.Caption} {
+		for _, s := range linkRegex.FindAllString(text, -1) {
+			if plainTextLink(s) {
+				links = append(links, s)
+			}
+		}
+	}
+	return links
+}
+
+// Top level domains that are also common file extensions in a programming
+// group. Bare names ending in these ("main.py", "run.sh") are file names.
+var fileExtensionTLDs = map[string]bool{
+	"cc": true,
+	"md": true,
+	"mk": true,
+	"ml": true,
+	"pl": true,
+	"ps": true,
+	"py": true,
+	"rs": true,
+	"sh": true,
+	"so": true,
+}
+
+// plainTextLink returns true if a regex match in plain text is likely to be an
+// actual link. Bare names such as "main.go" are common in a programming group,
+// so we require a scheme, a "www." prefix, a path, or a host ending in a known
+// top level domain (international ones included) that is not a common file
+// extension.
+func plainTextLink(s string) bool {
+	if strings.Contains(s, "://") || strings.HasPrefix(strings.ToLower(s), "www.") || strings.Contains(s, "/") {
+		return true
+	}
+	host, err := normalizeHost(s)
+	if err != nil {
+		return false
+	}
+	tld := host[strings.LastIndex(host, ".")+1:]
+	if fileExtensionTLDs[tld] {
+		return false
+	}
+	_, icann := publicsuffix.PublicSuffix(tld)
+	return icann
+}
+
+// normalizeHost returns the host part of a link in lowercase ASCII form
+// (using punycode for international domains), without port or trailing dots.
+// Nothing is resolved or fetched over the network.
+func normalizeHost(link string) (string, error) {
+	link = strings.TrimSpace(link)
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+
+	host := strings.TrimRight(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return "", fmt.Errorf("no host in link %q", link)
+	}
+	return idna.ToASCII(host)
+}
+
+// linkHosts returns the unique normalized hosts of all links in the message.
+func linkHosts(msg *tgbotapi.Message) []string {
+	seen := map[string]bool{}
+	var hosts []string
+
+	for _, link := range extractLinks(msg) {
+		host, err := normalizeHost(link)
+		if err != nil {
+			log.Printf("Ignoring invalid link %q: %v", link, err)
+			continue
+		}
+		if !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// domainInList returns true if the host is one of the domains in the list, or
+// a subdomain of one of them.
+func domainInList(host string, domains []string) bool {
+	for _, d := range domains {
+		d, err := normalizeHost(d)
+		if err != nil {
+			continue
+		}
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// blocksProbationLinks returns true if users under probation can only post
+// links to domains in the allowlist.
+func (c chatConfig) blocksProbationLinks() bool {
+	return c.ProbationBlockLinks == nil || *c.ProbationBlockLinks
+}
+
+// filterLinks checks the hosts against the chat allowlist and blocklist, and
+// returns the result and the offending host, if any. Users under probation
+// can only post links to domains in the allowlist, unless the chat opts out.
+func filterLinks(cfg chatConfig, hosts []string, probation bool) (linkFilterResult, string) {
+	for _, host := range hosts {
+		if domainInList(host, cfg.LinkAllowlist) {
+			continue
+		}
+		if domainInList(host, cfg.LinkBlocklist) {
+			return linkBlocked, host
+		}
+		if probation && cfg.blocksProbationLinks() {
+			return linkProbation, host
+		}
+	}
+	return linkAccepted, ""
+}
+
+// handledLinkFiltering removes messages with unacceptable links and takes the
+// configured action on their authors. Returns true if the message was
+// removed.
+func (x *opBot) handledLinkFiltering(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) bool {
+	hosts := linkHosts(msg)
+	if len(hosts) == 0 {
+		return false
+	}
+
+	cfg := x.config.forChat(msg.Chat.ID)
+	result, host := filterLinks(cfg, hosts, x.onProbation(msg.Chat.ID, msg.From.ID))
+
+	switch result {
+	case linkBlocked:
+		promLinkBlockedCount.WithLabelValues(result.String()).Inc()
+		log.Printf("Message from user %s (uid=%d) links to blocked domain %q", formatName(*msg.From), msg.From.ID, host)
+
+		// Links to blocked domains are handled exactly like a pattern match.
+		action := actionFromString(cfg.LinkBlockAction)
+		if action == opNoAction {
+			action = opDelete
+		}
+		if err := x.performPatternAction(bot, msg, action, cfg.LinkBlockDuration.Duration, "blocked link "+host); err != nil {
+			log.Printf("Error handling blocked link: %v", err)
+		}
+		return action.deletesMessage()
+
+	case linkProbation:
+		promLinkBlockedCount.WithLabelValues(result.String()).Inc()
+		log.Printf("Deleting link to %q from new user %s (uid=%d)", host, formatName(*msg.From), msg.From.ID)
+
+		// As with other probation restrictions, only warn the user once in a
+		// while to avoid flooding the group.
+		strID := fmt.Sprintf("%d", msg.From.ID)
+		if _, found := x.newUserWarningCache.Get(strID); !found {
+			reply, err := sendReply(bot, msg.Chat.ID, msg.MessageID, fmt.Sprintf(T("probation_no_links"), nameRef(*msg.From)))
+			if err != nil {
+				log.Printf("Error sending probation link warning: %v", err)
+			} else {
+				selfDestructMessage(bot, reply.Chat.ID, reply.MessageID, 0)
+			}
+			x.newUserWarningCache.Set(strID, time.Now(), cache.DefaultExpiration)
+		}
+		x.quarantineMessage(msg, "link from new user")
+		deleteMessage(bot, msg.Chat.ID, msg.MessageID)
+		return true
+	}
+	return false
+}
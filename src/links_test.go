@@ -0,0 +1,104 @@
+// Unit tests for the links module.
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+)
+
+func TestLinkHosts(t *testing.T) {
+	caseTests := []struct {
+		message *tgbotapi.Message // Message from the received update.
+		want    []string          // Normalized hosts expected.
+	}{
+		{
+			// nil message.
+			message: nil,
+		},
+		{
+			// File names are not links.
+			message: &tgbotapi.Message{
+				Text: "Look at main.go and foo.txt",
+			},
+		},
+		{
+			// Plain text link with scheme, uppercase and port.
+			message: &tgbotapi.Message{
+				Text: "Visit HTTPS://Example.COM:8080/foo now",
+			},
+			want: []string{"example.com"},
+		},
+		{
+			// Link hidden in a text_link entity, and an URL entity after a
+			// non-BMP character (two UTF-16 units).
+			message: &tgbotapi.Message{
+				Text: "🚀 click here scam.io",
+				Entities: &[]tgbotapi.MessageEntity{
+					{Type: "text_link", Offset: 3, Length: 10, URL: "http://hidden.example.org/x"},
+					{Type: "url", Offset: 14, Length: 7},
+					{Type: "text_link", Offset: 3, Length: 5, URL: "tg://user?id=42"},
+				},
+			},
+			want: []string{"hidden.example.org", "scam.io"},
+		},
+		{
+			// Links in captions and international domain names.
+			message: &tgbotapi.Message{
+				Caption: "free money at www.bücher.de/promo and t.me/joinchat/xyz",
+			},
+			want: []string{"www.xn--bcher-kva.de", "t.me"},
+		},
+		{
+			// Bare domains with known top level domains, international
+			// ones included. File names are still not links.
+			message: &tgbotapi.Message{
+				Text: "Join spam.com or золото.рф for free crypto, see main.py and notes.md",
+			},
+			want: []string{"spam.com", "xn--g1aiibax.xn--p1ai"},
+		},
+	}
+
+	for _, tt := range caseTests {
+		got := linkHosts(tt.message)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("linkHosts handled %+v incorrectly; expected: %v, got: %v", tt.message, tt.want, got)
+		}
+	}
+}
+
+func TestFilterLinks(t *testing.T) {
+	cfg := chatConfig{
+		LinkAllowlist: []string{"github.com", "Go.dev"},
+		LinkBlocklist: []string{"t.me", "bit.ly"},
+	}
+
+	caseTests := []struct {
+		hosts     []string
+		probation bool
+		want      linkFilterResult
+		wantHost  string
+	}{
+		{hosts: []string{"github.com", "pkg.go.dev"}, probation: true, want: linkAccepted},
+		{hosts: []string{"example.com"}, want: linkAccepted},
+		{hosts: []string{"example.com"}, probation: true, want: linkProbation, wantHost: "example.com"},
+		{hosts: []string{"github.com", "t.me"}, want: linkBlocked, wantHost: "t.me"},
+		{hosts: []string{"evil.bit.ly"}, want: linkBlocked, wantHost: "evil.bit.ly"},
+		{hosts: []string{"notbit.ly"}, want: linkAccepted},
+	}
+
+	for _, tt := range caseTests {
+		got, host := filterLinks(cfg, tt.hosts, tt.probation)
+		if got != tt.want || host != tt.wantHost {
+			t.Errorf("filterLinks handled %v (probation: %v) incorrectly; expected: %v %q, got: %v %q", tt.hosts, tt.probation, tt.want, tt.wantHost, got, host)
+		}
+	}
+
+	// Chats can let users under probation post links.
+	optOut := false
+	cfg.ProbationBlockLinks = &optOut
+	if got, _ := filterLinks(cfg, []string{"example.com"}, true); got != linkAccepted {
+		t.Errorf("filterLinks with probation_block_links = false: got %v, want %v", got, linkAccepted)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 
 	tgbotapi "github.com/osprogramadores/telegram-bot-api"
 )
@@ -21,6 +22,14 @@ var (
 )
 
 func main() {
+	// Train the classifier from labeled files and exit.
+	if len(os.Args) > 1 && os.Args[1] == "train" {
+		if err := trainClassifier(os.Args[2:]); err != nil {
+			log.Fatalf("Unable to train the classifier: %v", err)
+		}
+		return
+	}
+
 	config, err := loadConfig()
 	if err != nil {
 		log.Fatalf("Unable to load config: %s", err)
@@ -56,6 +65,41 @@ func main() {
 		log.Printf("Error loading info on the requested bans: %v (assuming no bans)", err)
 	}
 
+	if err = opbot.patternStats.loadPatternStats(); err != nil {
+		log.Printf("Error loading pattern statistics: %v (assuming no statistics)", err)
+	}
+
+	if err = opbot.globalBans.loadGlobalBans(); err != nil {
+		log.Printf("Error loading global banlist: %v (assuming empty banlist)", err)
+	}
+	if _, err = opbot.importGlobalBanFeeds(); err != nil {
+		log.Printf("Error importing global ban feeds: %v", err)
+	}
+
+	if err = opbot.images.loadImageBlocklist(); err != nil {
+		log.Printf("Error loading image blocklist: %v (assuming empty blocklist)", err)
+	}
+
+	if err = opbot.classifier.loadClassifier(); err != nil {
+		log.Printf("Error loading classifier: %v (assuming untrained classifier)", err)
+	}
+
+	if err = opbot.quarantine.loadQuarantine(); err != nil {
+		log.Printf("Error loading quarantine: %v (assuming empty quarantine)", err)
+	}
+
+	if err = opbot.probation.loadProbation(); err != nil {
+		log.Printf("Error loading probation state: %v (assuming no users under probation)", err)
+	}
+
+	if err = opbot.reputation.loadReputation(); err != nil {
+		log.Printf("Error loading reputation records: %v (assuming no records)", err)
+	}
+
+	if err = opbot.forwardAllowlist.loadForwardAllowlist(); err != nil {
+		log.Printf("Error loading forward allowlist: %v (assuming empty allowlist)", err)
+	}
+
 	if err := opbot.geolocations.readLocations(); err != nil {
 		log.Printf("Error reading locations: %v (assuming no locations recorded)", err)
 	}
@@ -71,16 +115,36 @@ func main() {
 	opbot.Register("hackerdetected", T("register_hackerdetected"), false, false, true, opbot.hackerHandler)
 	opbot.Register("help", T("register_help"), false, true, true, opbot.helpHandler)
 	opbot.Register("notifications", T("notifications_help"), false, true, true, opbot.notifications.notificationHandler)
+	opbot.Register("status", T("status_help"), false, true, true, opbot.probationStatusHandler)
 
 	// Commands to report messages to admins.
-	opbot.Register("ban", T("ban_help"), false, false, true, opbot.bans.banRequestHandler)
-	opbot.Register("admin", T("ban_help"), false, false, true, opbot.bans.banRequestHandler)
-	opbot.Register("report", T("ban_help"), false, false, true, opbot.bans.banRequestHandler)
+	opbot.Register("ban", T("ban_help"), false, false, true, opbot.reportHandler)
+	opbot.Register("admin", T("ban_help"), false, false, true, opbot.reportHandler)
+	opbot.Register("report", T("ban_help"), false, false, true, opbot.reportHandler)
 
 	opbot.Register("new_user_probation_time", T("new_user_probation_time_help"), true, false, true, opbot.setNewUserProbationTimeHandler)
 	opbot.Register("welcome_message_ttl", T("welcome_message_ttl_help"), true, false, true, opbot.setWelcomeMessageTTLHandler)
 	opbot.Register("captcha_time", T("captcha_time_help"), true, false, true, opbot.setCaptchaTimeHandler)
 	opbot.Register("reload_patterns", T("reload_patterns_help"), true, true, false, opbot.reloadMatchPatterns)
+	opbot.Register("pattern_list", T("pattern_list_help"), true, true, true, opbot.patternListHandler)
+	opbot.Register("pattern_add", T("pattern_add_help"), true, true, true, opbot.patternAddHandler)
+	opbot.Register("pattern_del", T("pattern_del_help"), true, true, true, opbot.patternDelHandler)
+	opbot.Register("pattern_test", T("pattern_test_help"), true, true, true, opbot.patternTestHandler)
+	opbot.Register("pattern_stats", T("pattern_stats_help"), true, true, true, opbot.patternStatsHandler)
+	opbot.Register("gban", T("gban_help"), true, false, true, opbot.gbanHandler)
+	opbot.Register("ungban", T("ungban_help"), true, true, true, opbot.ungbanHandler)
+	opbot.Register("gban_export", T("gban_export_help"), true, true, true, opbot.gbanExportHandler)
+	opbot.Register("gban_import", T("gban_import_help"), true, true, true, opbot.gbanImportHandler)
+	opbot.Register("lockdown", T("lockdown_help"), true, false, true, opbot.lockdownHandler)
+	opbot.Register("probation", T("probation_help"), true, false, true, opbot.probationHandler)
+	opbot.Register("score", T("score_help"), true, false, true, opbot.scoreHandler)
+	opbot.Register("rep", T("rep_help"), true, false, true, opbot.repHandler)
+	opbot.Register("fwd_allow", T("fwd_allow_help"), true, false, true, opbot.fwdAllowHandler)
+	opbot.Register("image_block", T("image_block_help"), true, false, true, opbot.imageBlockHandler)
+	opbot.Register("quarantine", T("quarantine_help"), true, true, true, opbot.quarantineHandler)
+
+	// Commands changing the patterns or the global banlist affect all chats.
+	opbot.ownerOnly("reload_patterns", "pattern_add", "pattern_del", "ungban", "gban_import")
 
 	// Start listener
 	go http.ListenAndServe(fmt.Sprintf(":%d", opbot.config.ServerPort), nil)
@@ -0,0 +1,75 @@
+// Text normalization, used to defeat common tricks to evade the patterns.
+
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// confusables maps characters commonly used as lookalikes of Latin letters
+// (mostly Cyrillic and Greek) to the Latin letters they resemble. Characters
+// with compatibility decompositions (fullwidth, mathematical, circled letters,
+// etc) are handled by NFKC and do not need to be listed here.
+var confusables = map[rune]rune{
+	// Cyrillic lowercase.
+	'а': 'a', 'е': 'e', 'о': 'o', 'р': 'p', 'с': 'c', 'у': 'y', 'х': 'x',
+	'ѕ': 's', 'і': 'i', 'ј': 'j', 'ԁ': 'd', 'һ': 'h', 'ԛ': 'q', 'ԝ': 'w',
+	'ɡ': 'g', 'ү': 'y',
+	// Cyrillic uppercase.
+	'А': 'A', 'В': 'B', 'Е': 'E', 'К': 'K', 'М': 'M', 'Н': 'H', 'О': 'O',
+	'Р': 'P', 'С': 'C', 'Т': 'T', 'У': 'Y', 'Х': 'X', 'Ѕ': 'S', 'І': 'I',
+	'Ј': 'J', 'Ү': 'Y', 'Ԛ': 'Q', 'Ԝ': 'W',
+	// Greek lowercase.
+	'α': 'a', 'β': 'b', 'γ': 'y', 'ε': 'e', 'ι': 'i', 'κ': 'k', 'ν': 'v',
+	'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x', 'ω': 'w',
+	// Greek uppercase.
+	'Α': 'A', 'Β': 'B', 'Ε': 'E', 'Ζ': 'Z', 'Η': 'H', 'Ι': 'I', 'Κ': 'K',
+	'Μ': 'M', 'Ν': 'N', 'Ο': 'O', 'Ρ': 'P', 'Τ': 'T', 'Υ': 'Y', 'Χ': 'X',
+	// Latin lookalikes.
+	'ı': 'i', 'ȷ': 'j', 'ł': 'l', 'ø': 'o', 'đ': 'd', 'ħ': 'h',
+}
+
+// normalizeText returns a version of the text better suited for matching:
+//   - NFKC normalization (fullwidth and "fancy" letters become plain letters);
+//   - invisible and format characters (E.g. zero width spaces) are removed;
+//   - combining marks are removed (so accented letters become plain letters);
+//   - lookalike characters are replaced by the Latin letters they resemble;
+//   - runs of whitespace, or of the same punctuation, are collapsed into one.
+func normalizeText(s string) string {
+	// Decompose first, so combining marks can be removed from the base
+	// letters, then compose again.
+	s = norm.NFKD.String(s)
+
+	var b strings.Builder
+	var last rune
+	for _, r := range s {
+		switch {
+		case unicode.Is(unicode.Mn, r), unicode.Is(unicode.Me, r):
+			continue
+		case unicode.Is(unicode.Cf, r), unicode.Is(unicode.Variation_Selector, r):
+			continue
+		case unicode.IsSpace(r):
+			r = ' '
+		}
+		if c, ok := confusables[r]; ok {
+			r = c
+		}
+
+		// Collapse repeated separators.
+		if r == last && (r == ' ' || unicode.IsPunct(r)) {
+			continue
+		}
+		b.WriteRune(r)
+		last = r
+	}
+	return norm.NFC.String(b.String())
+}
+
+// skeleton returns a lowercase, normalized version of the text, useful to
+// compare strings that look alike.
+func skeleton(s string) string {
+	return strings.ToLower(normalizeText(s))
+}
@@ -0,0 +1,66 @@
+// Unit tests for the normalize module.
+package main
+
+import (
+	"testing"
+)
+
+func TestNormalizeText(t *testing.T) {
+	caseTests := []struct {
+		s    string
+		want string
+	}{
+		// Plain text is unchanged.
+		{s: "hello world", want: "hello world"},
+		// Cyrillic lookalikes.
+		{s: "cryрto", want: "crypto"},
+		{s: "САSН", want: "CASH"},
+		// Zero width space and other format characters.
+		{s: "t.me/\u200bxyz", want: "t.me/xyz"},
+		{s: "fr\u200de\u2060e", want: "free"},
+		// Fullwidth and mathematical letters.
+		{s: "ｃｒｙｐｔｏ", want: "crypto"},
+		{s: "𝐜𝐫𝐲𝐩𝐭𝐨", want: "crypto"},
+		// Combining marks and accents.
+		{s: "c̷r̷y̷p̷t̷o̷", want: "crypto"},
+		{s: "promoção", want: "promocao"},
+		// Repeated separators.
+		{s: "free    money...now\t\t!!", want: "free money.now !"},
+	}
+
+	for _, tt := range caseTests {
+		if got := normalizeText(tt.s); got != tt.want {
+			t.Errorf("normalizeText(%q): expected %q, got %q", tt.s, tt.want, got)
+		}
+	}
+}
+
+func TestNormalizedPatternMatch(t *testing.T) {
+	p, err := stringTomlToPatterns(`[[message]]
+pattern = "crypto"
+action = "ban"
+
+[[message]]
+pattern = "t\\.me/xyz"
+action = "kick"
+normalize = false`)
+	if err != nil {
+		t.Fatalf("Unable to parse patterns: %v", err)
+	}
+
+	caseTests := []struct {
+		msg            string
+		expectedAction opMatchAction
+	}{
+		{msg: "buy ｃｒｙрｔｏ now", expectedAction: opBan},
+		{msg: "t.me/xyz", expectedAction: opKick},
+		// Normalization disabled for this pattern.
+		{msg: "t.me/\u200bxyz", expectedAction: opNoAction},
+	}
+
+	for _, tt := range caseTests {
+		if _, action := p.matchPattern(opMatchPattern{Message: tt.msg}); action != tt.expectedAction {
+			t.Errorf("matchPattern(%q): expected action %v, got %v", tt.msg, tt.expectedAction, action)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+// Per pattern hit statistics.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+)
+
+const (
+	// File to store the pattern statistics.
+	patternStatsDB = "pattern_stats.json"
+
+	// Number of recent matched samples kept for each pattern.
+	patternStatsMaxSamples = 5
+
+	// Maximum length (in runes) of each sample.
+	patternStatsMaxSampleLen = 200
+
+	// Users removed by a pattern are forgotten after this long. Later unbans
+	// no longer count as false positives of the pattern.
+	patternVictimMaxAge = 30 * 24 * time.Hour
+)
+
+// patternHits holds the statistics for a single pattern.
+type patternHits struct {
+	// Number of times the pattern matched.
+	Hits int `json:"hits"`
+	// Time of the last match.
+	LastMatch time.Time `json:"last_match"`
+	// Time we first saw this pattern. Used instead of the last match to
+	// decide if a pattern that never matched is stale.
+	Since time.Time `json:"since"`
+	// Most recent matched samples, newest last.
+	Samples []string `json:"samples"`
+	// Number of users removed by this pattern later unbanned by an admin.
+	Unbanned int `json:"unbanned"`
+}
+
+// patternVictim holds a user removed by a pattern.
+type patternVictim struct {
+	Pattern int       `json:"pattern"`
+	Time    time.Time `json:"time"`
+}
+
+// patternStatsData holds the statistics for all patterns, as saved on disk.
+type patternStatsData struct {
+	Patterns map[int]*patternHits `json:"patterns"`
+	// Victims maps the IDs of users removed by a pattern to the pattern.
+	Victims map[int]patternVictim `json:"removed_users"`
+}
+
+// patternStats holds the statistics for all patterns. They change on every
+// match, so they are saved periodically instead of on every change.
+type patternStats struct {
+	sync.RWMutex
+	Stats patternStatsData
+	dirty bool
+
+	// Copy of the patterns in use, so the periodic report does not need to
+	// touch the patterns used by the main loop.
+	current opPatterns
+	statsDB string
+}
+
+// newPatternStats creates a new patternStats object.
+func newPatternStats() *patternStats {
+	return &patternStats{
+		Stats: patternStatsData{
+			Patterns: map[int]*patternHits{},
+			Victims:  map[int]patternVictim{},
+		},
+		statsDB: patternStatsDB,
+	}
+}
+
+// loadPatternStats loads the pattern statistics from the disk.
+func (s *patternStats) loadPatternStats() error {
+	s.Lock()
+	defer s.Unlock()
+
+	err := readJSONFromDataDir(&s.Stats, s.statsDB)
+	if s.Stats.Patterns == nil {
+		s.Stats.Patterns = map[int]*patternHits{}
+	}
+	if s.Stats.Victims == nil {
+		s.Stats.Victims = map[int]patternVictim{}
+	}
+	for id, ph := range s.Stats.Patterns {
+		promPatternLastMatch.WithLabelValues(strconv.Itoa(id)).Set(float64(ph.LastMatch.Unix()))
+	}
+	return err
+}
+
+// save writes the statistics to disk. Locks are assumed to be taken care of
+// by the caller.
+func (s *patternStats) save() {
+	if err := safeWriteJSON(s.Stats, s.statsDB); err != nil {
+		log.Printf("Error saving pattern statistics: %v", err)
+		return
+	}
+	s.dirty = false
+}
+
+// autosave saves the statistics periodically, if they changed.
+func (s *patternStats) autosave(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			s.flush()
+		}
+	}()
+}
+
+// flush forgets old victims and saves the statistics, if they changed.
+func (s *patternStats) flush() {
+	s.Lock()
+	defer s.Unlock()
+	for userID, v := range s.Stats.Victims {
+		if time.Since(v.Time) > patternVictimMaxAge {
+			delete(s.Stats.Victims, userID)
+			s.dirty = true
+		}
+	}
+	if s.dirty {
+		s.save()
+	}
+}
+
+// setPatterns updates the copy of the patterns in use and starts tracking
+// new patterns.
+func (s *patternStats) setPatterns(p opPatterns) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.current = p.clone()
+	for _, name := range patternFields {
+		for _, pa := range *p.field(name) {
+			if _, ok := s.Stats.Patterns[pa.ID]; !ok {
+				s.Stats.Patterns[pa.ID] = &patternHits{Since: time.Now()}
+			}
+		}
+	}
+	s.dirty = true
+}
+
+// record registers a match for the pattern with the given ID. If the match
+// removed the user from the chat, victim holds the user ID (0 otherwise).
+func (s *patternStats) record(id int, sample string, victim int) {
+	s.Lock()
+	defer s.Unlock()
+
+	ph, ok := s.Stats.Patterns[id]
+	if !ok {
+		ph = &patternHits{Since: time.Now()}
+		s.Stats.Patterns[id] = ph
+	}
+	ph.Hits++
+	ph.LastMatch = time.Now()
+
+	if runes := []rune(sample); len(runes) > patternStatsMaxSampleLen {
+		sample = string(runes[:patternStatsMaxSampleLen]) + "..."
+	}
+	ph.Samples = append(ph.Samples, sample)
+	if len(ph.Samples) > patternStatsMaxSamples {
+		ph.Samples = ph.Samples[len(ph.Samples)-patternStatsMaxSamples:]
+	}
+
+	if victim != 0 {
+		s.Stats.Victims[victim] = patternVictim{Pattern: id, Time: time.Now()}
+	}
+	s.dirty = true
+
+	label := strconv.Itoa(id)
+	promPatternHitCount.WithLabelValues(label).Inc()
+	promPatternLastMatch.WithLabelValues(label).Set(float64(ph.LastMatch.Unix()))
+}
+
+// unbanned registers that an admin unbanned the user. If the user had been
+// removed by a pattern, this counts as a possible false positive for it.
+func (s *patternStats) unbanned(userID int) {
+	s.Lock()
+	defer s.Unlock()
+
+	v, ok := s.Stats.Victims[userID]
+	if !ok {
+		return
+	}
+	id := v.Pattern
+	delete(s.Stats.Victims, userID)
+	if ph, ok := s.Stats.Patterns[id]; ok {
+		ph.Unbanned++
+	}
+	s.dirty = true
+
+	log.Printf("User %d, removed by pattern #%d, was unbanned by an admin", userID, id)
+	promPatternUnbannedCount.WithLabelValues(strconv.Itoa(id)).Inc()
+}
+
+// describe returns a markdown safe, single line summary of the statistics
+// for the pattern. Locks are assumed to be taken care of by the caller.
+func (s *patternStats) describe(field string, pa opPatternAction) string {
+	ph, ok := s.Stats.Patterns[pa.ID]
+	if !ok || ph.Hits == 0 {
+		return fmt.Sprintf("%s: never matched", describePattern(field, pa))
+	}
+	return fmt.Sprintf("%s: %d hits, last %s, %d unbanned", describePattern(field, pa), ph.Hits, ph.LastMatch.Format("2006-01-02 15:04"), ph.Unbanned)
+}
+
+// summary returns one line of statistics per pattern, sorted by the number of
+// hits (most hits first). If id is not zero, only that pattern is returned,
+// along with its recent samples.
+func (s *patternStats) summary(id int) []string {
+	s.RLock()
+	defer s.RUnlock()
+
+	type entry struct {
+		hits int
+		line string
+	}
+	var entries []entry
+	var samples []string
+
+	for _, name := range patternFields {
+		for _, pa := range *s.current.field(name) {
+			if id != 0 && pa.ID != id {
+				continue
+			}
+			hits := 0
+			if ph, ok := s.Stats.Patterns[pa.ID]; ok {
+				hits = ph.Hits
+				if id != 0 {
+					for _, sample := range ph.Samples {
+						samples = append(samples, "- "+markdownEscape(sample))
+					}
+				}
+			}
+			entries = append(entries, entry{hits, s.describe(name, pa)})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].hits > entries[j].hits })
+
+	var ret []string
+	for _, e := range entries {
+		ret = append(ret, e.line)
+	}
+	return append(ret, samples...)
+}
+
+// staleReport returns a list of patterns that have not matched anything in
+// the given number of days, and patterns whose victims were later unbanned by
+// admins.
+func (s *patternStats) staleReport(days int) []string {
+	s.RLock()
+	defer s.RUnlock()
+
+	period := time.Duration(days) * 24 * time.Hour
+
+	var stale, unbanned []string
+	for _, name := range patternFields {
+		for _, pa := range *s.current.field(name) {
+			ph, ok := s.Stats.Patterns[pa.ID]
+			if !ok {
+				continue
+			}
+			last := ph.LastMatch
+			if last.Before(ph.Since) {
+				last = ph.Since
+			}
+			if time.Since(last) > period {
+				stale = append(stale, s.describe(name, pa))
+			}
+			if ph.Unbanned > 0 {
+				unbanned = append(unbanned, s.describe(name, pa))
+			}
+		}
+	}
+
+	var ret []string
+	if len(stale) > 0 {
+		ret = append(ret, fmt.Sprintf("*Patterns without matches in the last %d days:*", days))
+		ret = append(ret, stale...)
+	}
+	if len(unbanned) > 0 {
+		ret = append(ret, "*Patterns with victims later unbanned by admins:*")
+		ret = append(ret, unbanned...)
+	}
+	return ret
+}
+
+// patternStatsHandler shows the statistics for all patterns, or for a single
+// pattern if an ID is given.
+func (x *opBot) patternStatsHandler(bot tgbotInterface, update tgbotapi.Update) error {
+	var id int
+	if arg := strings.TrimPrefix(strings.TrimSpace(update.Message.CommandArguments()), "#"); arg != "" {
+		var err error
+		if id, err = strconv.Atoi(arg); err != nil {
+			return fmt.Errorf("usage: /pattern\\_stats \\[id]")
+		}
+	}
+
+	lines := x.patternStats.summary(id)
+	if len(lines) == 0 {
+		lines = []string{"No patterns found."}
+	}
+	return sendLongReply(bot, update.Message.Chat.ID, update.Message.MessageID, lines)
+}
+
+// patternReporter periodically sends the stale patterns report to the admins
+// of the managed chats.
+func (x *opBot) patternReporter(bot tgbotInterface) {
+	interval := x.config.PatternReportInterval.Duration
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		for range time.Tick(interval) {
+			lines := x.patternStats.staleReport(x.config.StalePatternDays)
+			if len(lines) == 0 {
+				continue
+			}
+			for _, admin := range x.managedChatAdmins(bot) {
+				if err := sendLongReply(bot, int64(admin.ID), 0, lines); err != nil {
+					log.Printf("Unable to send pattern report to admin %s (uid=%d): %v", formatName(admin), admin.ID, err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,73 @@
+// Unit tests for the pattern statistics module.
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPatternStats(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	p, err := stringTomlToPatterns(patterns)
+	if err != nil {
+		t.Fatalf("Unable to parse patterns: %v", err)
+	}
+
+	s := newPatternStats()
+	s.setPatterns(p)
+
+	// Pattern #1 matches a few times and bans a user, who is later unbanned.
+	for i := 0; i < patternStatsMaxSamples+2; i++ {
+		s.record(1, strings.Repeat("x", patternStatsMaxSampleLen+10), 0)
+	}
+	s.record(1, "last sample", 42)
+	s.unbanned(42)
+	// Unbanning someone not removed by a pattern changes nothing.
+	s.unbanned(43)
+
+	ph := s.Stats.Patterns[1]
+	if ph.Hits != patternStatsMaxSamples+3 || ph.Unbanned != 1 || len(ph.Samples) != patternStatsMaxSamples {
+		t.Errorf("unexpected statistics for pattern #1: %+v", ph)
+	}
+	if ph.Samples[len(ph.Samples)-1] != "last sample" || len([]rune(ph.Samples[0])) != patternStatsMaxSampleLen+3 {
+		t.Errorf("unexpected samples for pattern #1: %q", ph.Samples)
+	}
+
+	// Make pattern #2 look old: it should be reported as stale.
+	s.Stats.Patterns[2].Since = time.Now().Add(-31 * 24 * time.Hour)
+
+	report := strings.Join(s.staleReport(30), "\n")
+	if !strings.Contains(report, "#2 ") || strings.Contains(report, "#3 ") {
+		t.Errorf("stale patterns report should contain only pattern #2 as stale, got:\n%s", report)
+	}
+	if !strings.Contains(report, "unbanned by admins:*\n#1 ") {
+		t.Errorf("stale patterns report should contain pattern #1 as unbanned, got:\n%s", report)
+	}
+
+	// The summary for a single pattern includes its samples.
+	if lines := s.summary(1); len(lines) != 1+patternStatsMaxSamples {
+		t.Errorf("unexpected summary for pattern #1: %q", lines)
+	}
+
+	// Old victims are forgotten.
+	s.record(1, "sample", 44)
+	v := s.Stats.Victims[44]
+	v.Time = time.Now().Add(-patternVictimMaxAge - time.Hour)
+	s.Stats.Victims[44] = v
+	s.record(1, "sample", 45)
+
+	// Statistics survive a reload, once flushed.
+	s.flush()
+	if _, ok := s.Stats.Victims[44]; ok {
+		t.Errorf("flush: old victim 44 not forgotten")
+	}
+	if _, ok := s.Stats.Victims[45]; !ok {
+		t.Errorf("flush: recent victim 45 forgotten")
+	}
+	loaded := newPatternStats()
+	if err := loaded.loadPatternStats(); err != nil || loaded.Stats.Patterns[1].Hits != ph.Hits || len(loaded.Stats.Victims) != 1 {
+		t.Errorf("unable to reload statistics: %v", err)
+	}
+}
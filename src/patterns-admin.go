@@ -0,0 +1,184 @@
+// Pattern management commands for the bot.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+)
+
+// patternAddRegex parses the arguments to /pattern_add: field, action (with an
+// optional duration) and the pattern itself, which may contain spaces.
+var patternAddRegex = regexp.MustCompile(`^(\S+)\s+(\S+)\s+(.+)$`)
+
+// parsePatternAction parses an action in the form "action" or
+// "action:duration" (E.g: "mute:1h").
+func parsePatternAction(s string) (string, duration, error) {
+	action, d, found := strings.Cut(s, ":")
+	if actionFromString(action) == opNoAction {
+		return "", duration{}, fmt.Errorf("invalid action %q", action)
+	}
+	if !found {
+		return strings.ToLower(action), duration{}, nil
+	}
+	pd, err := time.ParseDuration(d)
+	if err != nil || pd <= 0 {
+		return "", duration{}, fmt.Errorf("invalid duration %q", d)
+	}
+	return strings.ToLower(action), duration{pd}, nil
+}
+
+// describePattern returns a markdown safe, single line description of a
+// pattern.
+func describePattern(field string, pa opPatternAction) string {
+	action := strings.ToLower(pa.matchAction().String())
+	if pa.matchAction() == opNoAction {
+		action = "delete"
+	}
+	if pa.Duration.Duration > 0 {
+		action = fmt.Sprintf("%s:%v", action, pa.Duration.Duration)
+	}
+
+	if pa.Weight != 0 {
+		action = fmt.Sprintf("%s (weight %d)", action, pa.Weight)
+	}
+
+	desc := fmt.Sprintf("#%d %s %s: %s", pa.ID, field, action, markdownEscape(pa.Pattern))
+	if pa.AddedBy != "" {
+		desc += fmt.Sprintf(" (%s, %s)", markdownEscape(pa.AddedBy), pa.AddedAt.Format("2006-01-02"))
+	}
+	return desc
+}
+
+// testPatterns returns the description of the first pattern matching the
+// text in each field.
+func (p *opPatterns) testPatterns(text string) []string {
+	var ret []string
+	for _, name := range patternFields {
+		if ok, pa := performGroupMatch(*p.field(name), text); ok {
+			ret = append(ret, describePattern(name, pa))
+		}
+	}
+	return ret
+}
+
+// replacePatterns saves the new patterns to disk and reloads them, so the
+// patterns in use always match the contents of the patterns file. Patterns
+// files that failed to load are never overwritten, as the patterns in use do
+// not reflect their contents.
+func (x *opBot) replacePatterns(p opPatterns) error {
+	if x.patternsLoadErr != nil {
+		return fmt.Errorf("the patterns file failed to load (%v). Fix it and use /reload\\_patterns first", x.patternsLoadErr)
+	}
+	if err := savePatterns(p); err != nil {
+		return fmt.Errorf("unable to save patterns: %v", err)
+	}
+	patterns, err := loadPatterns()
+	if err != nil {
+		return fmt.Errorf("unable to reload patterns: %v", err)
+	}
+	x.setPatterns(patterns)
+	return nil
+}
+
+// patternListHandler lists all patterns.
+func (x *opBot) patternListHandler(bot tgbotInterface, update tgbotapi.Update) error {
+	var lines []string
+	for _, name := range patternFields {
+		for _, pa := range *x.patterns.field(name) {
+			lines = append(lines, describePattern(name, pa))
+		}
+	}
+	if len(lines) == 0 {
+		lines = []string{"No patterns defined."}
+	}
+	return sendLongReply(bot, update.Message.Chat.ID, update.Message.MessageID, lines)
+}
+
+// patternAddHandler adds a new pattern. Usage: /pattern_add <field>
+// <action[:duration]> <regex>.
+func (x *opBot) patternAddHandler(bot tgbotInterface, update tgbotapi.Update) error {
+	args := patternAddRegex.FindStringSubmatch(strings.TrimSpace(update.Message.CommandArguments()))
+	if args == nil {
+		return fmt.Errorf("usage: /pattern\\_add <field> <action\\[:duration]> <regex>")
+	}
+	field := strings.ToLower(args[1])
+
+	patterns := x.patterns.clone()
+	list := patterns.field(field)
+	if list == nil {
+		return fmt.Errorf("invalid field %q (valid fields: %s)", field, strings.Join(patternFields, ", "))
+	}
+
+	action, d, err := parsePatternAction(args[2])
+	if err != nil {
+		return err
+	}
+
+	from := update.Message.From
+	pa := opPatternAction{
+		ID:       patterns.newID(),
+		Pattern:  args[3],
+		Action:   action,
+		Duration: d,
+		AddedBy:  fmt.Sprintf("%s (uid=%d)", strings.TrimSpace(from.FirstName+" "+from.LastName), from.ID),
+		AddedAt:  time.Now().UTC().Truncate(time.Second),
+	}
+	if err := pa.validate(); err != nil {
+		return err
+	}
+	*list = append(*list, pa)
+
+	if err := x.replacePatterns(patterns); err != nil {
+		return err
+	}
+	log.Printf("Pattern added by %s: %+v", formatName(*from), pa)
+	_, err = sendReply(bot, update.Message.Chat.ID, update.Message.MessageID, "Pattern added: "+describePattern(field, pa))
+	return err
+}
+
+// patternDelHandler removes the pattern with the given ID.
+func (x *opBot) patternDelHandler(bot tgbotInterface, update tgbotapi.Update) error {
+	id, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(update.Message.CommandArguments()), "#"))
+	if err != nil {
+		return fmt.Errorf("usage: /pattern\\_del <id>")
+	}
+
+	patterns := x.patterns.clone()
+	for _, name := range patternFields {
+		list := patterns.field(name)
+		for i, pa := range *list {
+			if pa.ID != id {
+				continue
+			}
+			*list = append((*list)[:i], (*list)[i+1:]...)
+			if err := x.replacePatterns(patterns); err != nil {
+				return err
+			}
+			log.Printf("Pattern removed by %s: %+v", formatName(*update.Message.From), pa)
+			_, err := sendReply(bot, update.Message.Chat.ID, update.Message.MessageID, "Pattern removed: "+describePattern(name, pa))
+			return err
+		}
+	}
+	return fmt.Errorf("pattern #%d not found", id)
+}
+
+// patternTestHandler shows which patterns would match the given text.
+func (x *opBot) patternTestHandler(bot tgbotInterface, update tgbotapi.Update) error {
+	text := strings.TrimSpace(update.Message.CommandArguments())
+	if text == "" {
+		return fmt.Errorf("usage: /pattern\\_test <text>")
+	}
+
+	lines := x.patterns.testPatterns(text)
+	if len(lines) == 0 {
+		lines = []string{"No patterns match."}
+	}
+	return sendLongReply(bot, update.Message.Chat.ID, update.Message.MessageID, lines)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/url"
@@ -9,6 +10,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	tgbotapi "github.com/osprogramadores/telegram-bot-api"
@@ -24,26 +26,67 @@ const (
 	opBan
 	// Kick indicates the user should be kicked.
 	opKick
+	// Delete indicates the message should only be deleted.
+	opDelete
+	// Mute indicates the user should be muted for a period of time.
+	opMute
+	// TempBan indicates the user should be banned for a period of time.
+	opTempBan
+	// Warn indicates the user should receive a self-destructing warning.
+	opWarn
+	// Report indicates the message should be reported to the admins.
+	opReport
 
 	// File to store the list of patterns.
 	patternsFile = "patterns.toml"
+
+	// Default durations for the mute and tempban actions.
+	defaultPatternMuteTime    = 1 * time.Hour
+	defaultPatternTempBanTime = 24 * time.Hour
+
+	// Weight of patterns without an explicit weight, in scoring mode.
+	defaultPatternWeight = 10
 )
 
 // opPatternAction contains a pattern and its associated action, in string form.
 type opPatternAction struct {
+	// ID uniquely identifies the pattern. Patterns without an ID get one
+	// when loaded, which becomes permanent the next time the file is saved.
+	ID      int    `toml:"id"`
 	Pattern string `toml:"pattern"`
-	Action  string `toml:"action"`
+	Action  string `toml:"action,omitempty"`
+	// Duration is used by the actions that are limited in time (mute and
+	// tempban). A zero value means the action default.
+	Duration duration `toml:"duration,omitempty"`
+	// Normalize indicates whether the pattern should also be matched against
+	// the normalized version of the data (see normalizeText). Enabled unless
+	// explicitly set to false.
+	Normalize *bool `toml:"normalize,omitempty"`
+	// Weight is the number of points added to the message score when the
+	// pattern matches, in scoring mode. A zero value means the default.
+	Weight int `toml:"weight,omitempty"`
+	// Who added this pattern (using /pattern_add), and when.
+	AddedBy string    `toml:"added_by,omitempty"`
+	AddedAt time.Time `toml:"added_at,omitempty"`
 }
 
 // opPatterns contains the lists of patterns to match against.
 type opPatterns struct {
-	Nickname []opPatternAction `toml:"nickname"`
-	Username []opPatternAction `toml:"username"`
-	Bio      []opPatternAction `toml:"bio"`
-	Message  []opPatternAction `toml:"message"`
-	Sticker  []opPatternAction `toml:"sticker"`
+	// NextID is the next ID to assign to a pattern. It is saved to make sure
+	// the IDs of removed patterns are never reused.
+	NextID int `toml:"next_id,omitempty"`
+
+	Nickname []opPatternAction `toml:"nickname,omitempty"`
+	Username []opPatternAction `toml:"username,omitempty"`
+	Bio      []opPatternAction `toml:"bio,omitempty"`
+	Message  []opPatternAction `toml:"message,omitempty"`
+	Sticker  []opPatternAction `toml:"sticker,omitempty"`
 }
 
+// patternFields contains the names of all pattern lists, in the order used
+// when displaying them.
+var patternFields = []string{"message", "sticker", "bio", "username", "nickname"}
+
 // opMatchPattern contains the data we will use when matching.
 type opMatchPattern struct {
 	// These three items will be matched when a new user joins
@@ -57,6 +100,19 @@ type opMatchPattern struct {
 	Sticker string
 }
 
+// field() returns the data to match against the patterns in the field with
+// the given name.
+func (m opMatchPattern) field(name string) string {
+	fields := map[string]string{
+		"nickname": m.Nickname,
+		"username": m.Username,
+		"bio":      m.Bio,
+		"message":  m.Message,
+		"sticker":  m.Sticker,
+	}
+	return fields[strings.ToLower(name)]
+}
+
 // This is a way to obtain specific information not available with the current
 // bot api we are using; instead, we will do the HTTP request ourselves and
 // unmarshal its result in this struct.
@@ -71,8 +127,13 @@ type opBotUserInfo struct {
 // default is NoAction.
 func actionFromString(s string) opMatchAction {
 	actions := map[string]opMatchAction{
-		"kick": opKick,
-		"ban":  opBan,
+		"kick":    opKick,
+		"ban":     opBan,
+		"delete":  opDelete,
+		"mute":    opMute,
+		"tempban": opTempBan,
+		"warn":    opWarn,
+		"report":  opReport,
 	}
 
 	if action, ok := actions[strings.ToLower(s)]; ok {
@@ -84,8 +145,13 @@ func actionFromString(s string) opMatchAction {
 // String returns the MatchAction as a string.
 func (ma opMatchAction) String() string {
 	actions := map[opMatchAction]string{
-		opKick: "Kick",
-		opBan:  "Ban",
+		opKick:    "Kick",
+		opBan:     "Ban",
+		opDelete:  "Delete",
+		opMute:    "Mute",
+		opTempBan: "TempBan",
+		opWarn:    "Warn",
+		opReport:  "Report",
 	}
 	if action, ok := actions[ma]; ok {
 		return action
@@ -93,6 +159,45 @@ func (ma opMatchAction) String() string {
 	return "NoAction"
 }
 
+// removesUser returns true if the action removes the user from the chat.
+func (ma opMatchAction) removesUser() bool {
+	return ma == opBan || ma == opKick || ma == opTempBan
+}
+
+// deletesMessage returns true if the action removes the matched message.
+// Reports leave the message in place so the admins can decide what to do.
+func (ma opMatchAction) deletesMessage() bool {
+	return ma != opNoAction && ma != opReport
+}
+
+// normalized returns true if the pattern should also be matched against
+// normalized data.
+func (pa opPatternAction) normalized() bool {
+	return pa.Normalize == nil || *pa.Normalize
+}
+
+// weight returns the weight of the pattern in scoring mode.
+func (pa opPatternAction) weight() int {
+	if pa.Weight == 0 {
+		return defaultPatternWeight
+	}
+	return pa.Weight
+}
+
+// matches returns true if the pattern matches the data or, for patterns using
+// normalization, its normalized form.
+func (pa opPatternAction) matches(data, normalized string) bool {
+	if len(pa.Pattern) == 0 || len(data) == 0 {
+		return false
+	}
+	return performMatch(pa.Pattern, data) || (pa.normalized() && normalized != data && performMatch(pa.Pattern, normalized))
+}
+
+// matchAction returns the opMatchAction for this pattern.
+func (pa opPatternAction) matchAction() opMatchAction {
+	return actionFromString(pa.Action)
+}
+
 // getMatchPattern() gets the relevant data from the update message.
 // For messages indicating new users have joined, it performs a web request to
 // get additional info on the user; for regular messages, we get the actual
@@ -146,6 +251,76 @@ func getMatchPattern(bot *tgbotapi.BotAPI, update tgbotapi.Update) (opMatchPatte
 	return matchPattern, nil
 }
 
+// field() returns a pointer to the list of patterns for the field with the
+// given name, or nil if there is no such field.
+func (p *opPatterns) field(name string) *[]opPatternAction {
+	fields := map[string]*[]opPatternAction{
+		"nickname": &p.Nickname,
+		"username": &p.Username,
+		"bio":      &p.Bio,
+		"message":  &p.Message,
+		"sticker":  &p.Sticker,
+	}
+	return fields[strings.ToLower(name)]
+}
+
+// clone() returns a copy of the patterns that can be changed without
+// affecting the original.
+func (p *opPatterns) clone() opPatterns {
+	ret := opPatterns{NextID: p.NextID}
+	for _, name := range patternFields {
+		*ret.field(name) = append([]opPatternAction{}, *p.field(name)...)
+	}
+	return ret
+}
+
+// assignIDs() gives an ID to every pattern without one. New IDs are always
+// larger than any existing ID, so IDs are never reused.
+func (p *opPatterns) assignIDs() {
+	for _, name := range patternFields {
+		list := *p.field(name)
+		for i := range list {
+			if list[i].ID == 0 {
+				list[i].ID = p.newID()
+			}
+		}
+	}
+}
+
+// newID() reserves and returns a new pattern ID.
+func (p *opPatterns) newID() int {
+	id := p.nextID()
+	p.NextID = id + 1
+	return id
+}
+
+// nextID() returns the next available pattern ID.
+func (p *opPatterns) nextID() int {
+	next := max(p.NextID, 1)
+	for _, name := range patternFields {
+		for _, pa := range *p.field(name) {
+			if pa.ID >= next {
+				next = pa.ID + 1
+			}
+		}
+	}
+	return next
+}
+
+// validate() checks that the pattern compiles and has a valid action.
+func (pa opPatternAction) validate() error {
+	if len(pa.Pattern) == 0 {
+		return fmt.Errorf("empty pattern")
+	}
+	if _, err := regexp.Compile("(?i)" + pa.Pattern); err != nil {
+		return fmt.Errorf("invalid pattern %q: %v", pa.Pattern, err)
+	}
+	if pa.Action != "" && pa.matchAction() == opNoAction {
+		return fmt.Errorf("invalid action %q", pa.Action)
+	}
+	return nil
+}
+
 // stringTomlToPatterns() converts the toml patterns from string to the Patterns
 // type, that can be used for the matching.
 func stringTomlToPatterns(sp string) (opPatterns, error) {
@@ -153,9 +328,45 @@ func stringTomlToPatterns(sp string) (opPatterns, error) {
 	if _, err := toml.Decode(sp, &newPatterns); err != nil {
 		return opPatterns{}, err
 	}
+	newPatterns.assignIDs()
 	return newPatterns, nil
 }
 
+// Header of patterns files written by the bot.
+const patternsFileHeader = `# Ban patterns for the op-bot.
+#
+# This file is managed by the bot: /pattern_add and /pattern_del rewrite it,
+# dropping comments and custom formatting. See patterns.toml.sample for the
+# documentation of each field.
+
+`
+
+// patternsToStringToml() converts the patterns back to toml.
+func patternsToStringToml(p opPatterns) (string, error) {
+	var buf bytes.Buffer
+	buf.WriteString(patternsFileHeader)
+	enc := toml.NewEncoder(&buf)
+	enc.Indent = ""
+	if err := enc.Encode(p); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// savePatterns() atomically replaces the patterns file on disk.
+func savePatterns(p opPatterns) error {
+	cfgdir, err := configDir()
+	if err != nil {
+		return err
+	}
+
+	sp, err := patternsToStringToml(p)
+	if err != nil {
+		return err
+	}
+	return safeWriteFile([]byte(sp), cfgdir, patternsFile)
+}
+
 // loadPatterns() reload the patterns file from the disk.
 func loadPatterns() (opPatterns, error) {
 	cfgdir, err := configDir()
@@ -186,26 +397,35 @@ func performMatch(pattern, data string) bool {
 }
 
 // performGroupMatch() performs a series of regex match operations with the
-// provided patterns/action and data.
-func performGroupMatch(patterns []opPatternAction, data string) (bool, opMatchAction) {
+// provided patterns/action and data. It returns the first matching pattern.
+// Patterns using normalization match either the data or its normalized form.
+func performGroupMatch(patterns []opPatternAction, data string) (bool, opPatternAction) {
 	if len(data) == 0 {
-		return false, opNoAction
+		return false, opPatternAction{}
 	}
+	normalized := normalizeText(data)
 
 	for _, ma := range patterns {
-		if len(ma.Pattern) == 0 {
-			continue
-		}
-		if performMatch(ma.Pattern, data) {
-			return true, actionFromString(ma.Action)
+		if ma.matches(data, normalized) {
+			return true, ma
 		}
 	}
-	return false, opNoAction
+	return false, opPatternAction{}
 }
 
 // matchPattern() performs the actual pattern matching using the data
 // we have and the list of patterns to match against.
 func (p *opPatterns) matchPattern(m opMatchPattern) (bool, opMatchAction) {
+	ok, rule := p.matchRule(m)
+	if !ok {
+		return false, opNoAction
+	}
+	return true, rule.matchAction()
+}
+
+// matchRule() returns the pattern (and associated action) matching the data
+// we have, if any.
+func (p *opPatterns) matchRule(m opMatchPattern) (bool, opPatternAction) {
 	if len(m.Message) > 0 {
 		// Common case; match against actual message.
 		return performGroupMatch(p.Message, m.Message)
@@ -222,36 +442,36 @@ func (p *opPatterns) matchPattern(m opMatchPattern) (bool, opMatchAction) {
 	// once a match happens, we already return, so pay attention
 	// when writing the pattern rules.
 	if len(m.Bio) > 0 {
-		if ret, action := performGroupMatch(p.Bio, m.Bio); ret {
-			return ret, action
+		if ret, rule := performGroupMatch(p.Bio, m.Bio); ret {
+			return ret, rule
 		}
 	}
 	if len(m.Username) > 0 {
-		if ret, action := performGroupMatch(p.Username, m.Username); ret {
-			return ret, action
+		if ret, rule := performGroupMatch(p.Username, m.Username); ret {
+			return ret, rule
 		}
 	}
 	if len(m.Nickname) > 0 {
-		if ret, action := performGroupMatch(p.Nickname, m.Nickname); ret {
-			return ret, action
+		if ret, rule := performGroupMatch(p.Nickname, m.Nickname); ret {
+			return ret, rule
 		}
 	}
 
-	return false, opNoAction
+	return false, opPatternAction{}
 }
 
 // MatchFromUpdate constructs a MatchPattern from the update message and call
 // matchPattern() to do the actual matching.  This is for gluing the bot with
 // the actual matching, while making the matching itself more testable.
-func (p *opPatterns) MatchFromUpdate(b *tgbotapi.BotAPI, u tgbotapi.Update) (bool, opMatchAction) {
+func (p *opPatterns) MatchFromUpdate(b *tgbotapi.BotAPI, u tgbotapi.Update) (bool, opPatternAction) {
 	if u.Message == nil || u.Message.Chat == nil {
-		return false, opNoAction
+		return false, opPatternAction{}
 	}
 
 	mp, err := getMatchPattern(b, u)
 	if err != nil {
-		return false, opNoAction
+		return false, opPatternAction{}
 	}
 
-	return p.matchPattern(mp)
+	return p.matchRule(mp)
 }
@@ -2,7 +2,13 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
 )
 
 const (
@@ -136,3 +142,163 @@ func TestPatternMatch(t *testing.T) {
 		}
 	}
 }
+
+func TestPatternActions(t *testing.T) {
+	const actionPatterns = `[[message]]
+pattern = "^delete me"
+action = "delete"
+
+[[message]]
+pattern = "^mute me"
+action = "mute"
+duration = "30m"
+
+[[message]]
+pattern = "^tempban me"
+action = "TempBan"
+duration = "48h"
+
+[[message]]
+pattern = "^warn me"
+action = "warn"
+
+[[message]]
+pattern = "^report me"
+action = "report"
+
+[[message]]
+pattern = "^no action"`
+
+	caseTests := []struct {
+		msg            string
+		expectedAction opMatchAction
+		expectedTime   time.Duration
+		deletes        bool
+		removes        bool
+	}{
+		{msg: "delete me", expectedAction: opDelete, deletes: true},
+		{msg: "mute me", expectedAction: opMute, expectedTime: 30 * time.Minute, deletes: true},
+		{msg: "tempban me", expectedAction: opTempBan, expectedTime: 48 * time.Hour, deletes: true, removes: true},
+		{msg: "warn me", expectedAction: opWarn, deletes: true},
+		{msg: "report me", expectedAction: opReport},
+		{msg: "no action", expectedAction: opNoAction},
+	}
+
+	p, err := stringTomlToPatterns(actionPatterns)
+	if err != nil {
+		t.Fatalf("Unable to parse patterns: %v", err)
+	}
+
+	for _, tt := range caseTests {
+		ok, rule := p.matchRule(opMatchPattern{Message: tt.msg})
+		if !ok {
+			t.Errorf("matchRule did not match %q", tt.msg)
+			continue
+		}
+		action := rule.matchAction()
+		if action != tt.expectedAction || rule.Duration.Duration != tt.expectedTime {
+			t.Errorf("matchRule handled %q incorrectly; expected action: %v, got: %v, expected duration: %v, got: %v", tt.msg, tt.expectedAction, action, tt.expectedTime, rule.Duration.Duration)
+		}
+		if action.deletesMessage() != tt.deletes || action.removesUser() != tt.removes {
+			t.Errorf("action %v: expected deletes=%v removes=%v, got deletes=%v removes=%v", action, tt.deletes, tt.removes, action.deletesMessage(), action.removesUser())
+		}
+	}
+}
+
+func TestPatternsRoundTrip(t *testing.T) {
+	p, err := stringTomlToPatterns(patterns)
+	if err != nil {
+		t.Fatalf("Unable to parse patterns: %v", err)
+	}
+
+	// IDs are assigned in field order and never reused.
+	if p.Message[0].ID != 1 || p.Nickname[1].ID != 6 || p.nextID() != 7 {
+		t.Errorf("unexpected IDs: message=%d, nickname=%d, next=%d", p.Message[0].ID, p.Nickname[1].ID, p.nextID())
+	}
+
+	added := p.clone()
+	added.Message = append(added.Message, opPatternAction{
+		ID:       added.newID(),
+		Pattern:  `free\s+crypto`,
+		Action:   "mute",
+		Duration: duration{90 * time.Minute},
+		AddedBy:  "Foo Bar (uid=42)",
+		AddedAt:  time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	})
+	if len(p.Message) != 1 {
+		t.Errorf("clone modified the original patterns")
+	}
+
+	sp, err := patternsToStringToml(added)
+	if err != nil {
+		t.Fatalf("Unable to encode patterns: %v", err)
+	}
+	got, err := stringTomlToPatterns(sp)
+	if err != nil {
+		t.Fatalf("Unable to parse encoded patterns %q: %v", sp, err)
+	}
+	if !reflect.DeepEqual(got, added) {
+		t.Errorf("round trip failed; expected: %+v, got: %+v", added, got)
+	}
+	if lines := got.testPatterns("buy FREE   crypto now"); len(lines) != 1 {
+		t.Errorf("testPatterns: expected one match, got: %v", lines)
+	}
+}
+
+func TestParsePatternAction(t *testing.T) {
+	caseTests := []struct {
+		s          string
+		wantAction string
+		wantTime   time.Duration
+		wantErr    bool
+	}{
+		{s: "ban", wantAction: "ban"},
+		{s: "TempBan:48h", wantAction: "tempban", wantTime: 48 * time.Hour},
+		{s: "mute:xyz", wantErr: true},
+		{s: "mute:-1h", wantErr: true},
+		{s: "explode", wantErr: true},
+	}
+
+	for _, tt := range caseTests {
+		action, d, err := parsePatternAction(tt.s)
+		if (err != nil) != tt.wantErr || action != tt.wantAction || d.Duration != tt.wantTime {
+			t.Errorf("parsePatternAction(%q): expected %q %v (error: %v), got %q %v (error: %v)", tt.s, tt.wantAction, tt.wantTime, tt.wantErr, action, d.Duration, err)
+		}
+	}
+}
+
+func TestReplacePatterns(t *testing.T) {
+	cfgdir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", cfgdir)
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	f := filepath.Join(cfgdir, opBotConfigDir, patternsFile)
+	if err := os.MkdirAll(filepath.Dir(f), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	x := opBot{patternStats: newPatternStats()}
+	p := opPatterns{Message: []opPatternAction{{ID: 1, Pattern: "spam"}}}
+
+	// Broken files are never overwritten.
+	broken := "# Important comment\n[[message]\npattern = \"x\"\n"
+	if err := os.WriteFile(f, []byte(broken), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	x.reloadMatchPatterns(nil, tgbotapi.Update{})
+	if err := x.replacePatterns(p); err == nil {
+		t.Errorf("replacePatterns: got no error after a failed load")
+	}
+	if buf, _ := os.ReadFile(f); string(buf) != broken {
+		t.Errorf("replacePatterns: broken file overwritten with %q", buf)
+	}
+
+	// Missing files are created.
+	os.Remove(f)
+	x.reloadMatchPatterns(nil, tgbotapi.Update{})
+	if err := x.replacePatterns(p); err != nil {
+		t.Fatalf("replacePatterns: got error %v for a missing file", err)
+	}
+	if len(x.patterns.Message) != 1 {
+		t.Errorf("replacePatterns: got patterns %+v, want one message pattern", x.patterns)
+	}
+}
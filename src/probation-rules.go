@@ -0,0 +1,176 @@
+// Kinds of content denied to users under probation, or to everyone.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+	"github.com/patrickmn/go-cache"
+)
+
+// contentKinds maps the kinds of content accepted in probation rules to a
+// function returning true if the message has that kind of content.
+var contentKinds = map[string]func(*tgbotapi.Message) bool{
+	"link":     func(m *tgbotapi.Message) bool { return len(linkHosts(m)) > 0 },
+	"photo":    func(m *tgbotapi.Message) bool { return m.Photo != nil },
+	"document": func(m *tgbotapi.Message) bool { return m.Document != nil && m.Animation == nil },
+	"sticker":  func(m *tgbotapi.Message) bool { return m.Sticker != nil },
+	"gif":      func(m *tgbotapi.Message) bool { return m.Animation != nil },
+	"contact":  func(m *tgbotapi.Message) bool { return m.Contact != nil },
+	"mention":  hasMention,
+	"forward":  isForwarded,
+
+	"audio":      func(m *tgbotapi.Message) bool { return m.Audio != nil },
+	"voice":      func(m *tgbotapi.Message) bool { return m.Voice != nil },
+	"video":      func(m *tgbotapi.Message) bool { return m.Video != nil },
+	"video_note": func(m *tgbotapi.Message) bool { return m.VideoNote != nil },
+	"game":       func(m *tgbotapi.Message) bool { return m.Game != nil },
+	"location":   func(m *tgbotapi.Message) bool { return m.Location != nil },
+	"venue":      func(m *tgbotapi.Message) bool { return m.Venue != nil },
+
+	// Not decoded by the Telegram library (see updates.go).
+	"poll":       func(m *tgbotapi.Message) bool { return extrasFor(m).Poll != nil },
+	"inline_bot": func(m *tgbotapi.Message) bool { return extrasFor(m).ViaBot != nil },
+}
+
+// defaultProbationRules are used by chats without probation rules in the
+// configuration: new users can only send text (and links, subject to the link
+// filters), and nobody can send audio, voice messages, round videos, games,
+// locations or venues.
+var defaultProbationRules = []probationRule{
+	{
+		Name:    "rich_media",
+		Content: []string{"photo", "document", "gif", "audio", "voice", "video", "video_note", "game"},
+		Warning: "only_text_messages",
+	},
+	{
+		Name:     "undesirable",
+		Content:  []string{"audio", "voice", "video_note", "game", "location", "venue"},
+		Everyone: true,
+	},
+}
+
+// hasMention returns true if the message text mentions a user.
+func hasMention(m *tgbotapi.Message) bool {
+	if m.Entities == nil {
+		return false
+	}
+	for _, e := range *m.Entities {
+		if e.Type == "mention" || e.Type == "text_mention" {
+			return true
+		}
+	}
+	return false
+}
+
+// name returns the name of the rule, used in logs and metrics.
+func (r probationRule) name() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return strings.Join(r.Content, "+")
+}
+
+// match returns the first kind of content in the rule found in the message.
+func (r probationRule) match(m *tgbotapi.Message) (string, bool) {
+	for _, kind := range r.Content {
+		if f, ok := contentKinds[kind]; ok && f(m) {
+			return kind, true
+		}
+	}
+	return "", false
+}
+
+// probationRules returns the probation rules of the chat, or the default rules
+// if the chat has none.
+func (c chatConfig) probationRules() []probationRule {
+	if c.ProbationRules == nil {
+		return defaultProbationRules
+	}
+	return c.ProbationRules
+}
+
+// validateProbationRules returns an error if any probation rule in the chats
+// uses an unknown kind of content.
+func validateProbationRules(chats []chatConfig) error {
+	for _, cc := range chats {
+		for _, r := range cc.ProbationRules {
+			for _, kind := range r.Content {
+				if _, ok := contentKinds[kind]; !ok {
+					var kinds []string
+					for k := range contentKinds {
+						kinds = append(kinds, k)
+					}
+					sort.Strings(kinds)
+					return fmt.Errorf("probation rule %q: unknown content %q (valid: %s)", r.name(), kind, strings.Join(kinds, ", "))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// matchProbationRule returns the first rule denying the message content, and
+// the kind of content denied. Rules for new users only apply if probation is
+// true.
+func matchProbationRule(rules []probationRule, m *tgbotapi.Message, probation bool) (probationRule, string, bool) {
+	for _, r := range rules {
+		if !r.Everyone && !probation {
+			continue
+		}
+		if kind, ok := r.match(m); ok {
+			return r, kind, true
+		}
+	}
+	return probationRule{}, "", false
+}
+
+// handledProbationRules deletes the message if its content is denied to the
+// author by the probation rules of the chat, and warns the author if the rule
+// has a warning. Returns the matching rule and true if the message was deleted.
+func (x *opBot) handledProbationRules(bot sendDeleteMessager, msg *tgbotapi.Message) (probationRule, bool) {
+	if msg == nil || msg.From == nil {
+		return probationRule{}, false
+	}
+
+	rules := x.config.forChat(msg.Chat.ID).probationRules()
+	rule, kind, ok := matchProbationRule(rules, msg, x.onProbation(msg.Chat.ID, msg.From.ID))
+	if !ok {
+		return probationRule{}, false
+	}
+
+	promRichMessageDeletedCount.Inc()
+	promProbationRuleCount.WithLabelValues(rule.name(), kind).Inc()
+	log.Printf("Deleting message %d from user %s (uid=%d) in chat %d: %s denied by probation rule %q.", msg.MessageID, formatName(*msg.From), msg.From.ID, msg.Chat.ID, kind, rule.name())
+
+	// We only send a warning if the user does not appear in the
+	// newUserWarningCache (which has a expiration of minutes). The idea is to
+	// prevent a repeat offender from causing the bot to flood the group.
+	strID := fmt.Sprintf("%d", msg.From.ID)
+	if _, found := x.newUserWarningCache.Get(strID); !found && rule.Warning != "" {
+		markup := tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(buttonURL(T("read_the_rules"), osProgramadoresRulesURL)),
+		)
+		reply, err := sendReplyWithMarkup(bot, msg.Chat.ID, msg.MessageID, T(rule.Warning), markup)
+		// We log errors but try to move ahead and still block the offending message.
+		if err != nil {
+			log.Printf("Error sending rules message: %v", err)
+		} else {
+			// Delete warning message.
+			selfDestructMessage(bot, reply.Chat.ID, reply.MessageID, 0)
+		}
+		x.newUserWarningCache.Set(strID, time.Now(), cache.DefaultExpiration)
+	}
+
+	x.quarantineMessage(msg, fmt.Sprintf("probation rule %q", rule.name()))
+	bot.DeleteMessage(tgbotapi.DeleteMessageConfig{
+		ChatID:    msg.Chat.ID,
+		MessageID: msg.MessageID,
+	})
+	return rule, true
+}
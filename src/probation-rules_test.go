@@ -0,0 +1,116 @@
+// Unit tests for the probation rules module.
+package main
+
+import (
+	"testing"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+)
+
+func TestMatchProbationRule(t *testing.T) {
+	rules := []probationRule{
+		{Name: "new_users", Content: []string{"link", "mention", "forward", "sticker"}, Warning: "only_text_messages"},
+		{Content: []string{"contact", "location"}, Everyone: true},
+	}
+
+	caseTests := []struct {
+		name      string
+		msg       tgbotapi.Message
+		probation bool
+		wantRule  string
+		wantKind  string
+		wantMatch bool
+	}{
+		{
+			name:      "Plain text",
+			msg:       tgbotapi.Message{Text: "hello"},
+			probation: true,
+		},
+		{
+			name:      "Link from new user",
+			msg:       tgbotapi.Message{Text: "see https://example.com"},
+			probation: true,
+			wantRule:  "new_users",
+			wantKind:  "link",
+			wantMatch: true,
+		},
+		{
+			name: "Link from regular user",
+			msg:  tgbotapi.Message{Text: "see https://example.com"},
+		},
+		{
+			name:      "Mention",
+			msg:       tgbotapi.Message{Text: "@someone", Entities: &[]tgbotapi.MessageEntity{{Type: "mention", Length: 8}}},
+			probation: true,
+			wantRule:  "new_users",
+			wantKind:  "mention",
+			wantMatch: true,
+		},
+		{
+			name:      "Forward",
+			msg:       tgbotapi.Message{Text: "hello", ForwardDate: 1},
+			probation: true,
+			wantRule:  "new_users",
+			wantKind:  "forward",
+			wantMatch: true,
+		},
+		{
+			name:      "Contact from regular user",
+			msg:       tgbotapi.Message{Contact: &tgbotapi.Contact{}},
+			wantRule:  "contact+location",
+			wantKind:  "contact",
+			wantMatch: true,
+		},
+		{
+			name:      "Photo not in the rules",
+			msg:       tgbotapi.Message{Photo: &[]tgbotapi.PhotoSize{}},
+			probation: true,
+		},
+	}
+
+	for _, tt := range caseTests {
+		rule, kind, ok := matchProbationRule(rules, &tt.msg, tt.probation)
+		if ok != tt.wantMatch || rule.name() != tt.wantRule || kind != tt.wantKind {
+			t.Errorf("%s: got rule %q, kind %q, match %v, want rule %q, kind %q, match %v", tt.name, rule.name(), kind, ok, tt.wantRule, tt.wantKind, tt.wantMatch)
+		}
+	}
+
+	// Default rules: new users can only send text, everybody is denied some
+	// kinds of content.
+	var cc chatConfig
+	photo := tgbotapi.Message{Photo: &[]tgbotapi.PhotoSize{}}
+	if _, _, ok := matchProbationRule(cc.probationRules(), &photo, false); ok {
+		t.Errorf("default rules: photo from regular user denied")
+	}
+	if r, _, ok := matchProbationRule(cc.probationRules(), &photo, true); !ok || r.Warning != "only_text_messages" {
+		t.Errorf("default rules: photo from new user got rule %+v, match %v", r, ok)
+	}
+	voice := tgbotapi.Message{Voice: &tgbotapi.Voice{}}
+	if r, _, ok := matchProbationRule(cc.probationRules(), &voice, false); !ok || !r.Everyone {
+		t.Errorf("default rules: voice from regular user got rule %+v, match %v", r, ok)
+	}
+
+	// Explicitly empty rules deny nothing.
+	cc.ProbationRules = []probationRule{}
+	if _, _, ok := matchProbationRule(cc.probationRules(), &voice, true); ok {
+		t.Errorf("empty rules: voice denied")
+	}
+}
+
+func TestValidateProbationRules(t *testing.T) {
+	caseTests := []struct {
+		content []string
+		wantErr bool
+	}{
+		{content: []string{"link", "gif", "inline_bot"}},
+		{content: []string{"poll"}},
+		{content: []string{"pictures"}, wantErr: true},
+		{content: []string{"photo", "document", "contact"}},
+	}
+	for _, tt := range caseTests {
+		chats := []chatConfig{{ProbationRules: []probationRule{{Content: tt.content}}}}
+		if err := validateProbationRules(chats); (err != nil) != tt.wantErr {
+			t.Errorf("validateProbationRules(%v): got error %v, want error: %v", tt.content, err, tt.wantErr)
+		}
+	}
+}
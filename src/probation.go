@@ -0,0 +1,434 @@
+// Persistent probation state of new users.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+)
+
+// File to store the probation state.
+const probationDB = "probation.json"
+
+// probationEntry holds the probation state of a user in a chat.
+type probationEntry struct {
+	// When the user joined the chat.
+	Joined time.Time `json:"joined"`
+	// When the user passed the captcha (zero if not yet, or no captcha).
+	CaptchaPassed time.Time `json:"captcha_passed,omitempty"`
+	// When the probation ends (zero if there is no time limit).
+	Expires time.Time `json:"expires"`
+	// Number of messages neither removed nor reported.
+	Messages int `json:"messages,omitempty"`
+	// Title of the chat, shown to the user.
+	Chat string `json:"chat,omitempty"`
+}
+
+// expired returns true if the probation time limit is over.
+func (pe *probationEntry) expired(now time.Time) bool {
+	return !pe.Expires.IsZero() && !now.Before(pe.Expires)
+}
+
+// expiration returns the end of a probation of the given duration starting
+// now. A zero duration means no time limit.
+func expiration(now time.Time, d time.Duration) time.Time {
+	if d == 0 {
+		return time.Time{}
+	}
+	return now.Add(d)
+}
+
+// probation holds the probation state of all users, by chat. Entries are
+// removed once the probation ends. Messages are counted on every clean
+// message, so the state is saved periodically instead of on every change.
+type probation struct {
+	sync.RWMutex
+	// Users maps "chatID:userID" to the probation state.
+	Users       map[string]*probationEntry
+	probationDB string
+	dirty       bool
+}
+
+// newProbation creates a new probation object.
+func newProbation() *probation {
+	return &probation{
+		Users:       map[string]*probationEntry{},
+		probationDB: probationDB,
+	}
+}
+
+// loadProbation loads the probation state from the disk, dropping users whose
+// probation already ended.
+func (p *probation) loadProbation() error {
+	p.Lock()
+	defer p.Unlock()
+
+	err := readJSONFromDataDir(&p.Users, p.probationDB)
+	if p.Users == nil {
+		p.Users = map[string]*probationEntry{}
+	}
+	p.gc(time.Now())
+	return err
+}
+
+// gc removes the users whose probation ended. Locks are assumed to be taken
+// care of by the caller.
+func (p *probation) gc(now time.Time) {
+	for key, pe := range p.Users {
+		if pe.expired(now) {
+			delete(p.Users, key)
+			p.dirty = true
+		}
+	}
+	promProbationUsers.Set(float64(len(p.Users)))
+}
+
+// autosave removes the users whose probation ended and saves the state
+// periodically, if it changed.
+func (p *probation) autosave(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			p.flush()
+		}
+	}()
+}
+
+// flush removes the users whose probation ended and saves the state, if it
+// changed.
+func (p *probation) flush() {
+	p.Lock()
+	defer p.Unlock()
+
+	p.gc(time.Now())
+	if !p.dirty {
+		return
+	}
+	if err := safeWriteJSON(p.Users, p.probationDB); err != nil {
+		log.Printf("Error saving probation state: %v", err)
+		return
+	}
+	p.dirty = false
+}
+
+// joined puts a user joining the chat under probation for the given time (zero
+// = no time limit). Users still under probation (E.g. joining again) keep
+// their state.
+func (p *probation) joined(chatID int64, userID int, now time.Time, d time.Duration) {
+	p.Lock()
+	defer p.Unlock()
+
+	key := chatUserKey(chatID, userID)
+	if pe, ok := p.Users[key]; ok && !pe.expired(now) {
+		return
+	}
+	p.Users[key] = &probationEntry{Joined: now, Expires: expiration(now, d)}
+	p.dirty = true
+}
+
+// passedCaptcha records that the user passed the captcha. The probation time
+// starts over from this moment.
+func (p *probation) passedCaptcha(chatID int64, userID int, now time.Time, d time.Duration) {
+	p.Lock()
+	defer p.Unlock()
+
+	key := chatUserKey(chatID, userID)
+	pe, ok := p.Users[key]
+	if !ok {
+		pe = &probationEntry{Joined: now}
+		p.Users[key] = pe
+	}
+	pe.CaptchaPassed = now
+	pe.Expires = expiration(now, d)
+	p.dirty = true
+}
+
+// cleanMessage counts a message from the user neither removed nor reported,
+// and ends the probation when the user has posted enough of them, and spent
+// the minimum time under probation. Returns true if the user graduated.
+func (p *probation) cleanMessage(chatID int64, userID int, title string, now time.Time, cfg graduationConfig) bool {
+	p.Lock()
+	defer p.Unlock()
+
+	key := chatUserKey(chatID, userID)
+	pe, ok := p.Users[key]
+	if !ok || pe.expired(now) {
+		return false
+	}
+	pe.Messages++
+	pe.Chat = title
+	p.dirty = true
+
+	if pe.Messages >= cfg.Messages && now.Sub(pe.Joined) >= cfg.MinTime.Duration {
+		delete(p.Users, key)
+		return true
+	}
+	return false
+}
+
+// reported discounts a message from the user reported to the admins, as it no
+// longer counts towards graduating from probation.
+func (p *probation) reported(chatID int64, userID int) {
+	p.Lock()
+	defer p.Unlock()
+
+	pe, ok := p.Users[chatUserKey(chatID, userID)]
+	if !ok || pe.Messages == 0 {
+		return
+	}
+	pe.Messages--
+	p.dirty = true
+}
+
+// get returns a copy of the probation state of the user in the chat.
+func (p *probation) get(chatID int64, userID int) (probationEntry, bool) {
+	p.RLock()
+	defer p.RUnlock()
+
+	pe, ok := p.Users[chatUserKey(chatID, userID)]
+	if !ok || pe.expired(time.Now()) {
+		return probationEntry{}, false
+	}
+	return *pe, true
+}
+
+// active returns true if the user is under probation in the chat.
+func (p *probation) active(chatID int64, userID int) bool {
+	p.RLock()
+	defer p.RUnlock()
+
+	pe, ok := p.Users[chatUserKey(chatID, userID)]
+	return ok && !pe.expired(time.Now())
+}
+
+// end ends the probation of the user in the chat. Returns false if the user
+// was not under probation.
+func (p *probation) end(chatID int64, userID int) bool {
+	p.Lock()
+	defer p.Unlock()
+
+	key := chatUserKey(chatID, userID)
+	if _, ok := p.Users[key]; !ok {
+		return false
+	}
+	delete(p.Users, key)
+	p.dirty = true
+	return true
+}
+
+// extend extends the probation of the user in the chat. Users not under
+// probation start a new one. Probations without a time limit stay so.
+func (p *probation) extend(chatID int64, userID int, now time.Time, d time.Duration) time.Time {
+	p.Lock()
+	defer p.Unlock()
+
+	key := chatUserKey(chatID, userID)
+	pe, ok := p.Users[key]
+	if !ok || pe.expired(now) {
+		pe = &probationEntry{Joined: now, Expires: now}
+		p.Users[key] = pe
+	}
+	if !pe.Expires.IsZero() {
+		pe.Expires = pe.Expires.Add(d)
+	}
+	p.dirty = true
+	return pe.Expires
+}
+
+// chats returns the IDs of the chats where the user is under probation.
+func (p *probation) chats(userID int) []int64 {
+	p.RLock()
+	defer p.RUnlock()
+
+	var ret []int64
+	suffix := fmt.Sprintf(":%d", userID)
+	for key := range p.Users {
+		if chat, ok := strings.CutSuffix(key, suffix); ok {
+			if id, err := strconv.ParseInt(chat, 10, 64); err == nil {
+				ret = append(ret, id)
+			}
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	return ret
+}
+
+// describe returns a description of the probation state of the user in the
+// chat.
+func (p *probation) describe(chatID int64, userID int) string {
+	p.RLock()
+	defer p.RUnlock()
+
+	pe, ok := p.Users[chatUserKey(chatID, userID)]
+	if !ok || pe.expired(time.Now()) {
+		return fmt.Sprintf("User %d is not under probation in chat %d.", userID, chatID)
+	}
+	captcha := "no captcha"
+	if !pe.CaptchaPassed.IsZero() {
+		captcha = "captcha passed " + pe.CaptchaPassed.Format("2006-01-02 15:04")
+	}
+	ends := "has no time limit"
+	if !pe.Expires.IsZero() {
+		ends = "ends " + pe.Expires.Format("2006-01-02 15:04")
+	}
+	return fmt.Sprintf("User %d in chat %d: joined %s, %s, %d clean messages, probation %s.", userID, chatID,
+		pe.Joined.Format("2006-01-02 15:04"), captcha, pe.Messages, ends)
+}
+
+// probationTime returns how long new users stay under probation in the chat
+// (zero = no time limit), and false if there is no probation. Chats where users
+// graduate by good behavior use the maximum time of the graduation settings.
+func (x *opBot) probationTime(chatID int64) (time.Duration, bool) {
+	if cfg := x.config.forChat(chatID).Graduation; cfg.Messages > 0 {
+		return cfg.MaxTime.Duration, true
+	}
+	d := x.config.NewUserProbationTime.Duration
+	return d, d > 0
+}
+
+// startProbation puts a user joining the chat under probation.
+func (x *opBot) startProbation(chatID int64, userID int) {
+	if d, ok := x.probationTime(chatID); ok {
+		x.probation.joined(chatID, userID, time.Now(), d)
+	}
+}
+
+// restartProbation restarts the probation time of a user who passed the
+// captcha in the chat.
+func (x *opBot) restartProbation(chatID int64, userID int) {
+	if d, ok := x.probationTime(chatID); ok {
+		x.probation.passedCaptcha(chatID, userID, time.Now(), d)
+	}
+}
+
+// countCleanMessage counts a message that survived moderation towards the
+// graduation of its author, in chats where users graduate by good behavior.
+func (x *opBot) countCleanMessage(msg *tgbotapi.Message) {
+	cfg := x.config.forChat(msg.Chat.ID).Graduation
+	if cfg.Messages <= 0 || msg.From == nil {
+		return
+	}
+	if x.probation.cleanMessage(msg.Chat.ID, msg.From.ID, msg.Chat.Title, time.Now(), cfg) {
+		promProbationGraduatedCount.Inc()
+		log.Printf("User %s (uid=%d) graduated from probation in chat %d.", formatName(*msg.From), msg.From.ID, msg.Chat.ID)
+	}
+}
+
+// reportHandler handles reports of messages to the admins. Reported messages
+// do not count towards graduating from probation, and lower the reputation of
+// their authors.
+func (x *opBot) reportHandler(bot tgbotInterface, update tgbotapi.Update) error {
+	msg := update.Message
+	if msg == nil || msg.Chat == nil || isPrivateChat(msg.Chat) || msg.ReplyToMessage == nil || msg.ReplyToMessage.From == nil {
+		return x.bans.banRequestHandler(bot, update)
+	}
+
+	// Only the first report of a message counts.
+	reply := msg.ReplyToMessage
+	_, reported := x.bans.banRequestInfo(fmt.Sprintf("%d:%d", reply.MessageID, msg.Chat.ID))
+	if !reported && reply.From.ID != msg.From.ID {
+		x.probation.reported(msg.Chat.ID, reply.From.ID)
+		x.reputation.reported(msg.Chat.ID, reply.From.ID)
+		x.classifier.reported(fmt.Sprintf("%d:%d", reply.MessageID, msg.Chat.ID), classifierText(reply), time.Now())
+	}
+	return x.bans.banRequestHandler(bot, update)
+}
+
+// probationStatusHandler shows users their own probation status in all chats.
+func (x *opBot) probationStatusHandler(bot tgbotInterface, update tgbotapi.Update) error {
+	msg := update.Message
+
+	var lines []string
+	for _, chatID := range x.probation.chats(msg.From.ID) {
+		pe, ok := x.probation.get(chatID, msg.From.ID)
+		if !ok {
+			continue
+		}
+		chat := markdownEscape(pe.Chat)
+		if chat == "" {
+			chat = fmt.Sprintf("%d", chatID)
+		}
+
+		cfg := x.config.forChat(chatID).Graduation
+		if cfg.Messages > 0 {
+			line := fmt.Sprintf(T("probation_status_messages"), chat, pe.Messages, cfg.Messages)
+			if minTime := pe.Joined.Add(cfg.MinTime.Duration); time.Now().Before(minTime) {
+				line += " " + fmt.Sprintf(T("probation_status_min_time"), minTime.Format("2006-01-02 15:04"))
+			}
+			lines = append(lines, line)
+			continue
+		}
+		lines = append(lines, fmt.Sprintf(T("probation_status_time"), chat, pe.Expires.Format("2006-01-02 15:04")))
+	}
+	if len(lines) == 0 {
+		lines = []string{T("probation_status_none")}
+	}
+	return sendLongReply(bot, msg.Chat.ID, msg.MessageID, lines)
+}
+
+// probationHandler shows, ends or extends the probation of a user. In a group,
+// it applies to the author of the message replied to, or to the given user
+// ID. In private, it applies to all chats where the user is under probation.
+// Usage: /probation [end|extend <duration>] [user_id].
+func (x *opBot) probationHandler(bot tgbotInterface, update tgbotapi.Update) error {
+	msg := update.Message
+	usage := fmt.Errorf("usage: /probation \\[end|extend <duration>] <user\\_id>, or in reply to a message")
+
+	args := strings.Fields(msg.CommandArguments())
+	op := ""
+	var d time.Duration
+	if len(args) > 0 && (args[0] == "end" || args[0] == "extend") {
+		op, args = args[0], args[1:]
+	}
+	if op == "extend" {
+		if len(args) == 0 {
+			return usage
+		}
+		var err error
+		if d, err = time.ParseDuration(args[0]); err != nil || d <= 0 {
+			return usage
+		}
+		args = args[1:]
+	}
+
+	var userID int
+	switch {
+	case len(args) == 1:
+		var err error
+		if userID, err = strconv.Atoi(args[0]); err != nil {
+			return usage
+		}
+	case len(args) == 0 && msg.ReplyToMessage != nil && msg.ReplyToMessage.From != nil:
+		userID = msg.ReplyToMessage.From.ID
+	default:
+		return usage
+	}
+
+	chats := []int64{msg.Chat.ID}
+	if isPrivateChat(msg.Chat) {
+		if chats = x.probation.chats(userID); len(chats) == 0 {
+			return fmt.Errorf("user %d is not under probation in any chat", userID)
+		}
+	}
+
+	var lines []string
+	for _, chatID := range chats {
+		switch op {
+		case "end":
+			if x.probation.end(chatID, userID) {
+				log.Printf("Probation of user %d in chat %d ended by %s", userID, chatID, formatName(*msg.From))
+			}
+		case "extend":
+			until := x.probation.extend(chatID, userID, time.Now(), d)
+			log.Printf("Probation of user %d in chat %d extended until %v by %s", userID, chatID, until, formatName(*msg.From))
+		}
+		lines = append(lines, x.probation.describe(chatID, userID))
+	}
+	return sendLongReply(bot, msg.Chat.ID, msg.MessageID, lines)
+}
@@ -0,0 +1,96 @@
+// Unit tests for the probation module.
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestProbation(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	now := time.Now()
+	p := newProbation()
+	p.joined(-1001, 100, now, time.Hour)
+	p.joined(-1002, 100, now, time.Hour)
+	p.joined(-1001, 200, now.Add(-2*time.Hour), time.Hour)
+	p.joined(-1001, 300, now, 0)
+
+	caseTests := []struct {
+		chatID int64
+		userID int
+		want   bool
+	}{
+		{chatID: -1001, userID: 100, want: true},
+		{chatID: -1002, userID: 100, want: true},
+		{chatID: -1003, userID: 100, want: false},
+		// Probation already over.
+		{chatID: -1001, userID: 200, want: false},
+		// No time limit.
+		{chatID: -1001, userID: 300, want: true},
+	}
+	for _, tt := range caseTests {
+		if got := p.active(tt.chatID, tt.userID); got != tt.want {
+			t.Errorf("active(%d, %d): got %v, want %v", tt.chatID, tt.userID, got, tt.want)
+		}
+	}
+
+	if got, want := p.chats(100), []int64{-1002, -1001}; !reflect.DeepEqual(got, want) {
+		t.Errorf("chats(100): got %v, want %v", got, want)
+	}
+
+	// Joining again does not reset the probation, passing the captcha does.
+	p.joined(-1001, 100, now.Add(30*time.Minute), time.Hour)
+	if got := p.Users[chatUserKey(-1001, 100)].Expires; !got.Equal(now.Add(time.Hour)) {
+		t.Errorf("joined again: got expiration %v, want %v", got, now.Add(time.Hour))
+	}
+	p.passedCaptcha(-1001, 100, now.Add(30*time.Minute), time.Hour)
+	pe := p.Users[chatUserKey(-1001, 100)]
+	if !pe.Joined.Equal(now) || !pe.Expires.Equal(now.Add(90*time.Minute)) {
+		t.Errorf("passedCaptcha: got %+v, want joined %v, expiration %v", pe, now, now.Add(90*time.Minute))
+	}
+
+	if until := p.extend(-1002, 100, now, time.Hour); !until.Equal(now.Add(2 * time.Hour)) {
+		t.Errorf("extend: got %v, want %v", until, now.Add(2*time.Hour))
+	}
+	if !p.end(-1002, 100) || p.active(-1002, 100) {
+		t.Errorf("end: user still under probation")
+	}
+	if p.end(-1002, 100) {
+		t.Errorf("end: got true for a user not under probation")
+	}
+
+	// Users graduate after enough clean messages and the minimum time.
+	// Reported messages do not count.
+	cfg := graduationConfig{Messages: 2, MinTime: duration{time.Hour}}
+	if p.cleanMessage(-1001, 300, "Group", now, cfg) {
+		t.Errorf("cleanMessage: graduated after one message")
+	}
+	p.reported(-1001, 300)
+	if p.cleanMessage(-1001, 300, "Group", now, cfg) {
+		t.Errorf("cleanMessage: graduated with a reported message")
+	}
+	if p.cleanMessage(-1001, 300, "Group", now.Add(time.Minute), cfg) {
+		t.Errorf("cleanMessage: graduated before the minimum time")
+	}
+	if pe, _ := p.get(-1001, 300); pe.Messages != 2 || pe.Chat != "Group" {
+		t.Errorf("cleanMessage: got %+v, want 2 messages in chat \"Group\"", pe)
+	}
+	if !p.cleanMessage(-1001, 300, "Group", now.Add(time.Hour), cfg) || p.active(-1001, 300) {
+		t.Errorf("cleanMessage: user did not graduate")
+	}
+	if p.cleanMessage(-1001, 200, "Group", now, graduationConfig{Messages: 1}) {
+		t.Errorf("cleanMessage: user not under probation graduated")
+	}
+
+	// The state survives a reload, once flushed.
+	p.flush()
+	reloaded := newProbation()
+	if err := reloaded.loadProbation(); err != nil {
+		t.Fatalf("loadProbation: %v", err)
+	}
+	if !reloaded.active(-1001, 100) || reloaded.active(-1002, 100) || len(reloaded.Users) != 1 {
+		t.Errorf("loadProbation: got %v, want only user 100 in chat -1001", reloaded.Users)
+	}
+}
@@ -15,6 +15,19 @@ var (
 			Help: "Total count of messages",
 		},
 	)
+	promEditedMessageCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "opbot_edited_messages_total",
+			Help: "Total count of edited messages",
+		},
+	)
+	promEditedMessageModeratedCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "opbot_edited_messages_moderated_total",
+			Help: "Number of edited messages removed or reported, by filter",
+		},
+		[]string{"filter"},
+	)
 	promJoinCount = prometheus.NewCounter(
 		prometheus.CounterOpts{
 			Name: "opbot_joins_total",
@@ -42,7 +55,7 @@ var (
 	promRichMessageDeletedCount = prometheus.NewCounter(
 		prometheus.CounterOpts{
 			Name: "opbot_rich_messages_deleted_total",
-			Help: "Number of rich messages deleted from new users",
+			Help: "Number of messages deleted by the probation rules",
 		},
 	)
 	promPatternMessageDeletedCount = prometheus.NewCounter(
@@ -57,11 +70,209 @@ var (
 			Help: "Number of users kicked or banned by message pattern matching",
 		},
 	)
+	promPatternActionCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "opbot_pattern_actions_total",
+			Help: "Number of actions taken by pattern matching, by action",
+		},
+		[]string{"action"},
+	)
+	promPatternHitCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "opbot_pattern_hits_total",
+			Help: "Number of matches, by pattern ID",
+		},
+		[]string{"id"},
+	)
+	promPatternLastMatch = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "opbot_pattern_last_match_timestamp_seconds",
+			Help: "Time of the last match (UNIX timestamp), by pattern ID",
+		},
+		[]string{"id"},
+	)
+	promPatternUnbannedCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "opbot_pattern_unbanned_total",
+			Help: "Number of users removed by a pattern later unbanned by an admin, by pattern ID",
+		},
+		[]string{"id"},
+	)
+	promLinkBlockedCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "opbot_links_blocked_total",
+			Help: "Number of messages removed by the link filter, by reason",
+		},
+		[]string{"reason"},
+	)
+	promScriptFilterCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "opbot_script_filter_matches_total",
+			Help: "Number of messages and nicknames matched by the script filters, by source and mode",
+		},
+		[]string{"source", "mode"},
+	)
+	promFloodCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "opbot_floods_total",
+			Help: "Number of message bursts removed by the flood control, by kind of message",
+		},
+		[]string{"kind"},
+	)
+	promDuplicateWaveCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "opbot_duplicate_waves_total",
+			Help: "Number of waves of similar messages posted by different users",
+		},
+	)
+	promDuplicateMessageDeletedCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "opbot_duplicate_messages_deleted_total",
+			Help: "Number of messages deleted as part of a wave of similar messages",
+		},
+	)
+	promRaidLockdownCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "opbot_raid_lockdowns_total",
+			Help: "Number of lockdowns started, automatically or manually",
+		},
+		[]string{"trigger"},
+	)
+	promRaidJoinCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "opbot_raid_joins_total",
+			Help: "Number of users joining chats in lockdown",
+		},
+	)
+	promGlobalBanCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "opbot_global_bans_total",
+			Help: "Number of users added to the global banlist",
+		},
+	)
+	promGlobalBanJoinCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "opbot_global_ban_joins_total",
+			Help: "Number of globally banned users banned at join time",
+		},
+	)
+	promGlobalBanListSize = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "opbot_global_banlist_size",
+			Help: "Number of users in the global banlist",
+		},
+	)
+	promProbationRuleCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "opbot_probation_rule_matches_total",
+			Help: "Number of messages deleted by each probation rule, by kind of content",
+		},
+		[]string{"rule", "kind"},
+	)
+	promProbationGraduatedCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "opbot_probation_graduated_total",
+			Help: "Number of users graduating from probation by good behavior",
+		},
+	)
+	promForwardAllowedCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "opbot_forwards_allowed_total",
+			Help: "Number of forwarded messages kept because of the forward allowlist",
+		},
+	)
+	promSenderChatCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "opbot_sender_chat_messages_total",
+			Help: "Number of messages sent on behalf of chats, by kind and action taken",
+		},
+		[]string{"kind", "action"},
+	)
+	promNewBotCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "opbot_bot_joins_total",
+			Help: "Number of bots added to the chats, by outcome",
+		},
+		[]string{"outcome"},
+	)
+	promBotInviterCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "opbot_bot_inviter_actions_total",
+			Help: "Number of actions taken against users adding too many bots",
+		},
+		[]string{"action"},
+	)
+	promImageBlockedCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "opbot_images_blocked_total",
+			Help: "Number of photos matching the image blocklist",
+		},
+	)
+	promImageBlocklistSize = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "opbot_image_blocklist_size",
+			Help: "Number of images in the image blocklist",
+		},
+	)
+	promClassifierSamplesCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "opbot_classifier_samples_total",
+			Help: "Number of samples learned by the spam classifier, by class",
+		},
+		[]string{"class"},
+	)
+	promClassifierActionCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "opbot_classifier_actions_total",
+			Help: "Number of actions taken on messages classified as spam",
+		},
+		[]string{"action"},
+	)
+	promQuarantinedCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "opbot_quarantined_messages_total",
+			Help: "Number of messages removed automatically and kept in the quarantine",
+		},
+	)
+	promQuarantineRestoredCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "opbot_quarantine_restored_total",
+			Help: "Number of quarantined messages restored by admins",
+		},
+	)
+	promServiceMessageDeletedCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "opbot_service_messages_deleted_total",
+			Help: "Number of join and leave service messages deleted",
+		},
+		[]string{"kind"},
+	)
+	promJoinSpamLeaveCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "opbot_join_spam_leave_total",
+			Help: "Number of users banned for posting and leaving right after joining",
+		},
+	)
+	promImpostorCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "opbot_impostors_total",
+			Help: "Number of users restricted for impersonating the admins",
+		},
+		[]string{"trigger"},
+	)
+	promProbationUsers = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "opbot_probation_users",
+			Help: "Number of users under probation, in all chats",
+		},
+	)
 )
 
 func init() {
 	prometheus.MustRegister(
 		promMessageCount,
+		promEditedMessageCount,
+		promEditedMessageModeratedCount,
 		promJoinCount,
 		promCaptchaCount,
 		promCaptchaValidatedCount,
@@ -69,6 +280,36 @@ func init() {
 		promRichMessageDeletedCount,
 		promPatternMessageDeletedCount,
 		promPatternKickBannedCount,
+		promPatternActionCount,
+		promPatternHitCount,
+		promPatternLastMatch,
+		promPatternUnbannedCount,
+		promLinkBlockedCount,
+		promScriptFilterCount,
+		promFloodCount,
+		promDuplicateWaveCount,
+		promDuplicateMessageDeletedCount,
+		promRaidLockdownCount,
+		promRaidJoinCount,
+		promGlobalBanCount,
+		promGlobalBanJoinCount,
+		promGlobalBanListSize,
+		promProbationRuleCount,
+		promProbationGraduatedCount,
+		promProbationUsers,
+		promForwardAllowedCount,
+		promSenderChatCount,
+		promNewBotCount,
+		promBotInviterCount,
+		promImageBlockedCount,
+		promImageBlocklistSize,
+		promClassifierSamplesCount,
+		promClassifierActionCount,
+		promQuarantinedCount,
+		promQuarantineRestoredCount,
+		promServiceMessageDeletedCount,
+		promJoinSpamLeaveCount,
+		promImpostorCount,
 	)
 
 	// Add handlers.
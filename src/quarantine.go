@@ -0,0 +1,350 @@
+// Quarantine of messages removed automatically, so admins can check for false
+// positives and restore them.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+)
+
+const (
+	// File to store the quarantine.
+	quarantineDB = "quarantine.json"
+
+	// Default maximum number of messages in the quarantine, and how long they
+	// are kept.
+	defaultQuarantineSize = 1000
+	defaultQuarantineTime = 7 * 24 * time.Hour
+
+	// Number of messages in each page of /quarantine.
+	quarantinePageSize = 10
+)
+
+// quarantineEntry holds a message removed automatically.
+type quarantineEntry struct {
+	ID   int       `json:"id"`
+	Time time.Time `json:"time"`
+	// Chat the message was posted to.
+	ChatID    int64  `json:"chat_id"`
+	ChatTitle string `json:"chat_title,omitempty"`
+	// Author of the message.
+	User tgbotapi.User `json:"user"`
+	// Why the message was removed.
+	Reason string `json:"reason"`
+	// Text of the message (captions are kept with the media).
+	Text string `json:"text,omitempty"`
+	// Media in the message (file IDs and captions) in a message of its own,
+	// as used by createMediaMessage.
+	Media *tgbotapi.Message `json:"media,omitempty"`
+	// Set once an admin restores the message.
+	Restored bool `json:"restored,omitempty"`
+}
+
+// quarantineState holds the state of the quarantine saved to the disk.
+type quarantineState struct {
+	LastID  int               `json:"last_id"`
+	Entries []quarantineEntry `json:"entries"`
+}
+
+// quarantine holds the messages removed automatically, oldest first. Messages
+// are added on every removal, so the quarantine is saved periodically instead
+// of on every change.
+type quarantine struct {
+	sync.RWMutex
+	quarantineState
+	quarantineDB string
+	dirty        bool
+}
+
+// newQuarantine creates a new quarantine object.
+func newQuarantine() *quarantine {
+	return &quarantine{
+		quarantineDB: quarantineDB,
+	}
+}
+
+// loadQuarantine loads the quarantine from the disk.
+func (q *quarantine) loadQuarantine() error {
+	q.Lock()
+	defer q.Unlock()
+	return readJSONFromDataDir(&q.quarantineState, q.quarantineDB)
+}
+
+// autosave saves the quarantine periodically, if it changed.
+func (q *quarantine) autosave(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			q.flush()
+		}
+	}()
+}
+
+// flush saves the quarantine, if it changed.
+func (q *quarantine) flush() {
+	q.Lock()
+	defer q.Unlock()
+	if !q.dirty {
+		return
+	}
+	if err := safeWriteJSON(q.quarantineState, q.quarantineDB); err != nil {
+		log.Printf("Error saving quarantine: %v", err)
+		return
+	}
+	q.dirty = false
+}
+
+// quarantineMedia returns a message holding only the media in the message,
+// or nil if there is none.
+func quarantineMedia(msg *tgbotapi.Message) *tgbotapi.Message {
+	media := &tgbotapi.Message{
+		Sticker:  msg.Sticker,
+		Audio:    msg.Audio,
+		Document: msg.Document,
+		Video:    msg.Video,
+		Venue:    msg.Venue,
+		Location: msg.Location,
+		Contact:  msg.Contact,
+		Caption:  msg.Caption,
+	}
+	if msg.Photo != nil && len(*msg.Photo) > 0 {
+		media.Photo = msg.Photo
+	}
+	if _, ok, _ := createMediaMessage(media, 0, nil); !ok {
+		return nil
+	}
+	return media
+}
+
+// add adds the entry to the quarantine, dropping the entries over the size
+// limit or older than maxAge.
+func (q *quarantine) add(e quarantineEntry, size int, maxAge time.Duration) {
+	q.Lock()
+	defer q.Unlock()
+
+	q.LastID++
+	e.ID = q.LastID
+	q.Entries = append(q.Entries, e)
+
+	first := 0
+	for first < len(q.Entries) && (len(q.Entries)-first > size || e.Time.Sub(q.Entries[first].Time) > maxAge) {
+		first++
+	}
+	q.Entries = append([]quarantineEntry{}, q.Entries[first:]...)
+	q.dirty = true
+}
+
+// get returns the entry with the given ID, unless older than maxAge.
+func (q *quarantine) get(id int, maxAge time.Duration) (quarantineEntry, bool) {
+	q.RLock()
+	defer q.RUnlock()
+
+	for _, e := range q.Entries {
+		if e.ID == id && !e.expired(maxAge) {
+			return e, true
+		}
+	}
+	return quarantineEntry{}, false
+}
+
+// restored marks the entry as restored. Returns false if the entry does not
+// exist or was already restored.
+func (q *quarantine) restored(id int) bool {
+	q.Lock()
+	defer q.Unlock()
+
+	for i, e := range q.Entries {
+		if e.ID != id {
+			continue
+		}
+		if e.Restored {
+			return false
+		}
+		q.Entries[i].Restored = true
+		q.dirty = true
+		return true
+	}
+	return false
+}
+
+// list returns the entries accepted by the filter, newest first. Entries older
+// than maxAge are skipped.
+func (q *quarantine) list(maxAge time.Duration, filter func(quarantineEntry) bool) []quarantineEntry {
+	q.RLock()
+	defer q.RUnlock()
+
+	var entries []quarantineEntry
+	for i := len(q.Entries) - 1; i >= 0; i-- {
+		if !q.Entries[i].expired(maxAge) && filter(q.Entries[i]) {
+			entries = append(entries, q.Entries[i])
+		}
+	}
+	return entries
+}
+
+// expired returns true if the entry is older than maxAge. Entries are only
+// dropped when new ones are added, so they may outlive maxAge in quiet times.
+func (e quarantineEntry) expired(maxAge time.Duration) bool {
+	return time.Since(e.Time) > maxAge
+}
+
+// describe returns a markdown safe description of the quarantined message.
+func (e quarantineEntry) describe() string {
+	sample := e.Text
+	if e.Media != nil {
+		kind := "media"
+		if e.Media.Photo != nil {
+			kind = "photo"
+		}
+		sample = strings.TrimSpace(fmt.Sprintf("[%s] %s %s", kind, e.Media.Caption, sample))
+	}
+	if runes := []rune(sample); len(runes) > patternStatsMaxSampleLen {
+		sample = string(runes[:patternStatsMaxSampleLen]) + "..."
+	}
+	chat := e.ChatTitle
+	if chat == "" {
+		chat = strconv.FormatInt(e.ChatID, 10)
+	}
+	restored := ""
+	if e.Restored {
+		restored = " (restored)"
+	}
+	return fmt.Sprintf("#%d %s %s, %s (uid=%d), %s%s:\n%s",
+		e.ID, e.Time.Format("2006-01-02 15:04"), markdownEscape(chat), formatName(e.User), e.User.ID,
+		markdownEscape(e.Reason), restored, markdownEscape(sample))
+}
+
+// quarantineMessage keeps a copy of a message about to be removed
+// automatically, so admins can restore it later.
+func (x *opBot) quarantineMessage(msg *tgbotapi.Message, reason string) {
+	if msg == nil || msg.Chat == nil || msg.From == nil || isPrivateChat(msg.Chat) {
+		return
+	}
+	x.addToQuarantine(quarantineEntry{
+		Time:      time.Now(),
+		ChatID:    msg.Chat.ID,
+		ChatTitle: msg.Chat.Title,
+		User:      *msg.From,
+		Reason:    reason,
+		Text:      msg.Text,
+		Media:     quarantineMedia(msg),
+	})
+}
+
+// quarantineTime returns how long messages are kept in the quarantine.
+func (x *opBot) quarantineTime() time.Duration {
+	if x.config.QuarantineTime.Duration <= 0 {
+		return defaultQuarantineTime
+	}
+	return x.config.QuarantineTime.Duration
+}
+
+// addToQuarantine adds the entry to the quarantine, using the limits in the
+// configuration.
+func (x *opBot) addToQuarantine(e quarantineEntry) {
+	size := x.config.QuarantineSize
+	if size <= 0 {
+		size = defaultQuarantineSize
+	}
+	x.quarantine.add(e, size, x.quarantineTime())
+	promQuarantinedCount.Inc()
+}
+
+// restoreQuarantined posts the quarantined message again in its chat, with a
+// header naming the author.
+func restoreQuarantined(bot sender, e quarantineEntry) error {
+	text := fmt.Sprintf(T("quarantine_restored"), formatName(e.User))
+	if e.Text != "" {
+		text += "\n\n" + markdownEscape(e.Text)
+	}
+	if _, err := sendMessage(bot, e.ChatID, text); err != nil {
+		return err
+	}
+	if e.Media == nil {
+		return nil
+	}
+	media, ok, err := createMediaMessage(e.Media, e.ChatID, nil)
+	if err != nil || !ok {
+		return err
+	}
+	_, err = bot.Send(media)
+	return err
+}
+
+// quarantineHandler lists the messages removed automatically from the chats
+// administered by the user, newest first, and restores them. Usage:
+// /quarantine [page], or /quarantine restore <id>.
+func (x *opBot) quarantineHandler(bot tgbotInterface, update tgbotapi.Update) error {
+	msg := update.Message
+
+	// Admins only see (and restore) messages from their own chats.
+	admins := map[int64]bool{}
+	isChatAdmin := func(chatID int64) bool {
+		admin, ok := admins[chatID]
+		if !ok {
+			admin, _ = isAdmin(bot, chatID, msg.From.ID)
+			admins[chatID] = admin
+		}
+		return admin
+	}
+
+	args := strings.Fields(msg.CommandArguments())
+	switch {
+	case len(args) == 2 && args[0] == "restore":
+		id, err := strconv.Atoi(strings.TrimPrefix(args[1], "#"))
+		if err != nil {
+			return fmt.Errorf("usage: /quarantine restore <id>")
+		}
+		e, ok := x.quarantine.get(id, x.quarantineTime())
+		if !ok || !isChatAdmin(e.ChatID) {
+			return fmt.Errorf("message #%d not found in the quarantine", id)
+		}
+		if e.Restored {
+			return fmt.Errorf("message #%d was already restored", id)
+		}
+		// Only mark the message as restored once it is back in the chat,
+		// so failures can be retried.
+		if err := restoreQuarantined(bot, e); err != nil {
+			return err
+		}
+		x.quarantine.restored(id)
+		promQuarantineRestoredCount.Inc()
+		log.Printf("Quarantined message #%d from %s (uid=%d) restored to chat %d by %s", id, formatName(e.User), e.User.ID, e.ChatID, formatName(*msg.From))
+		_, err = sendReply(bot, msg.Chat.ID, msg.MessageID, fmt.Sprintf("Message #%d restored.", id))
+		return err
+
+	case len(args) <= 1:
+		page := 1
+		if len(args) == 1 {
+			var err error
+			if page, err = strconv.Atoi(args[0]); err != nil || page < 1 {
+				return fmt.Errorf("usage: /quarantine \\[page], or /quarantine restore <id>")
+			}
+		}
+		entries := x.quarantine.list(x.quarantineTime(), func(e quarantineEntry) bool { return isChatAdmin(e.ChatID) })
+		pages := max(1, (len(entries)+quarantinePageSize-1)/quarantinePageSize)
+		if page > pages {
+			return fmt.Errorf("page %d not found (%d pages)", page, pages)
+		}
+
+		lines := []string{fmt.Sprintf("*Quarantine* (page %d of %d):", page, pages)}
+		for _, e := range entries[min((page-1)*quarantinePageSize, len(entries)):min(page*quarantinePageSize, len(entries))] {
+			lines = append(lines, e.describe())
+		}
+		if len(entries) == 0 {
+			lines = append(lines, "No messages.")
+		}
+		if page < pages {
+			lines = append(lines, fmt.Sprintf("Next page: /quarantine %d", page+1))
+		}
+		lines = append(lines, "Use /quarantine restore <id> to post a message again.")
+		return sendLongReply(bot, msg.Chat.ID, msg.MessageID, lines)
+	}
+	return fmt.Errorf("usage: /quarantine \\[page], or /quarantine restore <id>")
+}
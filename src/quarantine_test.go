@@ -0,0 +1,79 @@
+// Unit tests for the quarantine module.
+package main
+
+import (
+	"testing"
+	"time"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+)
+
+func TestQuarantine(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	q := newQuarantine()
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		q.add(quarantineEntry{Time: now.Add(time.Duration(i-5) * time.Hour), ChatID: chatID, Reason: "test"}, 4, 24*time.Hour)
+	}
+	// Over the size limit, so the oldest entry is gone.
+	if got := q.list(24*time.Hour, func(quarantineEntry) bool { return true }); len(got) != 4 || got[0].ID != 5 || got[3].ID != 2 {
+		t.Errorf("add: got %+v, want entries 5 to 2", got)
+	}
+
+	// Entries older than the maximum age are gone.
+	q.add(quarantineEntry{Time: now.Add(22 * time.Hour), ChatID: chatID + 1, Reason: "test"}, 4, 24*time.Hour)
+	if got := q.list(24*time.Hour, func(quarantineEntry) bool { return true }); len(got) != 3 || got[0].ID != 6 || got[2].ID != 4 {
+		t.Errorf("add: got %+v, want entries 6 to 4", got)
+	}
+	if got := q.list(24*time.Hour, func(e quarantineEntry) bool { return e.ChatID == chatID }); len(got) != 2 {
+		t.Errorf("list: got %d entries for chat %d, want 2", len(got), chatID)
+	}
+
+	// Entries older than the maximum age are not listed, even before newer
+	// entries arrive.
+	if got := q.list(90*time.Minute, func(quarantineEntry) bool { return true }); len(got) != 2 || got[1].ID != 5 {
+		t.Errorf("list: got %+v, want entries 6 and 5", got)
+	}
+	if _, ok := q.get(4, 90*time.Minute); ok {
+		t.Errorf("get(4): got an entry older than the maximum age")
+	}
+
+	if !q.restored(5) {
+		t.Errorf("restored(5): got false, want true")
+	}
+	if q.restored(5) || q.restored(1) {
+		t.Errorf("restored: entries restored twice, or restored after removal")
+	}
+
+	// The quarantine survives a reload, once flushed.
+	q.flush()
+	reloaded := newQuarantine()
+	if err := reloaded.loadQuarantine(); err != nil {
+		t.Fatalf("loadQuarantine: %v", err)
+	}
+	if e, ok := reloaded.get(5, 24*time.Hour); !ok || !e.Restored || reloaded.LastID != 6 {
+		t.Errorf("loadQuarantine: got entry %+v, %v, last ID %d, want entry 5 restored, last ID 6", e, ok, reloaded.LastID)
+	}
+}
+
+func TestQuarantineMedia(t *testing.T) {
+	photo := []tgbotapi.PhotoSize{{FileID: "small", Width: 90}, {FileID: "large", Width: 800}}
+	caseTests := []struct {
+		msg       *tgbotapi.Message
+		wantMedia bool
+	}{
+		{msg: &tgbotapi.Message{Text: "plain text"}, wantMedia: false},
+		{msg: &tgbotapi.Message{Photo: &photo, Caption: "caption"}, wantMedia: true},
+		{msg: &tgbotapi.Message{Sticker: &tgbotapi.Sticker{FileID: "sticker"}}, wantMedia: true},
+	}
+	for _, tt := range caseTests {
+		media := quarantineMedia(tt.msg)
+		if got := media != nil; got != tt.wantMedia {
+			t.Errorf("quarantineMedia(%+v): got media = %v, want %v", tt.msg, got, tt.wantMedia)
+		}
+		if media != nil && media.Caption != tt.msg.Caption {
+			t.Errorf("quarantineMedia(%+v): got caption %q, want %q", tt.msg, media.Caption, tt.msg.Caption)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+// Raid detection and lockdown mode.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+)
+
+const (
+	// Defaults for the raid detection settings.
+	defaultRaidWindow      = 1 * time.Minute
+	defaultRaidQuietPeriod = 10 * time.Minute
+	defaultRaidRestrict    = 1 * time.Hour
+
+	// Captcha modes during a lockdown.
+	raidCaptchaPrivate  = "private"
+	raidCaptchaRestrict = "restrict"
+)
+
+// raidState holds the recent joins and the lockdown state of a chat.
+type raidState struct {
+	joins    []time.Time
+	lastJoin time.Time
+	lockdown bool
+	since    time.Time
+	// Lockdowns started by an admin only end when an admin says so.
+	manual bool
+	// Number of users joining during the lockdown.
+	count int
+}
+
+// raids holds the raid state of all chats. Joins are handled by the main loop,
+// but lockdowns end from a timer, so access is locked.
+type raids struct {
+	sync.Mutex
+	chats map[int64]*raidState
+}
+
+// newRaids creates a new raids object.
+func newRaids() *raids {
+	return &raids{chats: map[int64]*raidState{}}
+}
+
+// state returns the state of the chat. Locks are assumed to be taken care of
+// by the caller.
+func (r *raids) state(chatID int64) *raidState {
+	rs, ok := r.chats[chatID]
+	if !ok {
+		rs = &raidState{}
+		r.chats[chatID] = rs
+	}
+	return rs
+}
+
+// window returns the time window used to count joins.
+func (c raidConfig) window() time.Duration {
+	if c.Window.Duration > 0 {
+		return c.Window.Duration
+	}
+	return defaultRaidWindow
+}
+
+// quietPeriod returns how long a chat must go without joins to leave the
+// lockdown.
+func (c raidConfig) quietPeriod() time.Duration {
+	if c.QuietPeriod.Duration > 0 {
+		return c.QuietPeriod.Duration
+	}
+	return defaultRaidQuietPeriod
+}
+
+// join registers a user joining the chat at the given time. Returns true if
+// the chat is in lockdown, and whether this join started it.
+func (r *raids) join(chatID int64, now time.Time, cfg raidConfig) (bool, bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	rs := r.state(chatID)
+	rs.lastJoin = now
+
+	var joins []time.Time
+	for _, t := range rs.joins {
+		if now.Sub(t) < cfg.window() {
+			joins = append(joins, t)
+		}
+	}
+	rs.joins = append(joins, now)
+
+	if rs.lockdown {
+		rs.count++
+		return true, false
+	}
+	if cfg.Joins <= 0 || len(rs.joins) < cfg.Joins {
+		return false, false
+	}
+	rs.lockdown, rs.manual, rs.since, rs.count = true, false, now, 1
+	return true, true
+}
+
+// inLockdown returns true if the chat is in lockdown.
+func (r *raids) inLockdown(chatID int64) bool {
+	r.Lock()
+	defer r.Unlock()
+	rs, ok := r.chats[chatID]
+	return ok && rs.lockdown
+}
+
+// set starts or ends the lockdown of a chat. Returns false if the chat was
+// already in the requested state. Asking for a manual lockdown during an
+// automatic one keeps it on until explicitly ended.
+func (r *raids) set(chatID int64, lockdown, manual bool) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	rs := r.state(chatID)
+	if rs.lockdown == lockdown {
+		if lockdown && manual {
+			rs.manual = true
+		}
+		return false
+	}
+	rs.lockdown, rs.manual, rs.since, rs.count = lockdown, manual, time.Now(), 0
+	return true
+}
+
+// quiet ends the lockdown of the chat if it was started automatically and
+// nobody joined during the quiet period. Otherwise, it returns how long until
+// the quiet period ends (or zero if there is no need to check again).
+func (r *raids) quiet(chatID int64, now time.Time, period time.Duration) (bool, time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+
+	rs := r.state(chatID)
+	if !rs.lockdown || rs.manual {
+		return false, 0
+	}
+	if elapsed := now.Sub(rs.lastJoin); elapsed < period {
+		return false, period - elapsed
+	}
+	rs.lockdown = false
+	return true, 0
+}
+
+// summary returns a description of the lockdown state of the chat.
+func (r *raids) summary(chatID int64) string {
+	r.Lock()
+	defer r.Unlock()
+
+	rs := r.state(chatID)
+	if !rs.lockdown {
+		return "Lockdown is off."
+	}
+	how := "automatically"
+	if rs.manual {
+		how = "by an admin"
+	}
+	return fmt.Sprintf("Lockdown is on, started %s at %s. %d users joined since then.", how, rs.since.Format("2006-01-02 15:04"), rs.count)
+}
+
+// watchRaid registers a new user joining the chat and handles the user if the
+// chat is in lockdown. Returns true if the user was handled, and no captcha or
+// welcome message should be posted in the chat.
+func (x *opBot) watchRaid(bot tgbotInterface, chatID int64, user tgbotapi.User) bool {
+	cfg := x.config.forChat(chatID).Raid
+	lockdown, started := x.raids.join(chatID, time.Now(), cfg)
+	if started {
+		promRaidLockdownCount.WithLabelValues("automatic").Inc()
+		log.Printf("Too many users joining chat %d. Starting lockdown.", chatID)
+		notifyChatAdmins(bot, chatID, []string{fmt.Sprintf("Raid detected: %d users joined chat %d in less than %v. Lockdown started.", cfg.Joins, chatID, cfg.window())})
+		x.scheduleLockdownEnd(bot, chatID, cfg.quietPeriod())
+	}
+	if !lockdown {
+		return false
+	}
+	promRaidJoinCount.Inc()
+
+	if cfg.KickJoins {
+		log.Printf("Kicking user %s (uid=%d) joining chat %d during lockdown.", formatName(user), user.ID, chatID)
+		kickUser(bot, chatID, user.ID)
+		unBanUser(bot, chatID, user.ID)
+		return true
+	}
+
+	// Try to send the captcha in private, so the chat is not flooded with
+	// captcha images. Bots can only message users who started a conversation
+	// with them first, and most new users never did, so this usually fails.
+	if captchaEnabled(x) && cfg.Captcha != raidCaptchaRestrict {
+		if err := x.sendCaptcha(bot, int64(user.ID), 0, user); err == nil {
+			x.pendingCaptcha.setChat(user.ID, chatID)
+			x.captchaReaper(bot, chatID, user)
+			return true
+		}
+		x.pendingCaptcha.del(user.ID)
+	}
+
+	// Silently restrict the user instead.
+	restrict := cfg.RestrictTime.Duration
+	if restrict <= 0 {
+		restrict = defaultRaidRestrict
+	}
+	log.Printf("Restricting user %s (uid=%d) joining chat %d during lockdown for %v.", formatName(user), user.ID, chatID, restrict)
+	if err := muteUserUntil(bot, chatID, user.ID, time.Now().Add(restrict)); err != nil {
+		log.Printf("Unable to restrict user %s (uid=%d): %v", formatName(user), user.ID, err)
+	}
+	// The user still owes a captcha, sent once the restriction is over and
+	// the user speaks for the first time.
+	if captchaEnabled(x) {
+		x.markAsPendingCaptcha(user, chatID, captchaNotSent)
+	}
+	return true
+}
+
+// scheduleLockdownEnd checks the chat after the quiet period, and ends the
+// lockdown if nobody joined in the meantime.
+func (x *opBot) scheduleLockdownEnd(bot tgbotInterface, chatID int64, period time.Duration) {
+	time.AfterFunc(period, func() {
+		ended, wait := x.raids.quiet(chatID, time.Now(), period)
+		switch {
+		case ended:
+			log.Printf("No users joined chat %d for %v. Ending lockdown.", chatID, period)
+			notifyChatAdmins(bot, chatID, []string{fmt.Sprintf("No users joined chat %d for %v. Lockdown ended.", chatID, period)})
+		case wait > 0:
+			x.scheduleLockdownEnd(bot, chatID, wait)
+		}
+	})
+}
+
+// lockdownHandler shows, starts or ends the lockdown of the chat. Usage:
+// /lockdown [on|off].
+func (x *opBot) lockdownHandler(bot tgbotInterface, update tgbotapi.Update) error {
+	msg := update.Message
+	if isPrivateChat(msg.Chat) {
+		return fmt.Errorf("use /lockdown in the group")
+	}
+
+	var lockdown bool
+	switch strings.ToLower(strings.TrimSpace(msg.CommandArguments())) {
+	case "":
+		_, err := sendReply(bot, msg.Chat.ID, msg.MessageID, x.raids.summary(msg.Chat.ID))
+		return err
+	case "on":
+		lockdown = true
+	case "off":
+		lockdown = false
+	default:
+		return fmt.Errorf("usage: /lockdown \\[on|off]")
+	}
+
+	if !x.raids.set(msg.Chat.ID, lockdown, true) {
+		_, err := sendReply(bot, msg.Chat.ID, msg.MessageID, x.raids.summary(msg.Chat.ID))
+		return err
+	}
+
+	state := "ended"
+	if lockdown {
+		state = "started"
+		promRaidLockdownCount.WithLabelValues("manual").Inc()
+	}
+	log.Printf("Lockdown of chat %d %s by %s", msg.Chat.ID, state, formatName(*msg.From))
+	notifyChatAdmins(bot, msg.Chat.ID, []string{fmt.Sprintf("Lockdown of %s %s by %s.", markdownEscape(msg.Chat.Title), state, formatName(*msg.From))})
+	return nil
+}
+
+// notifyChatAdmins sends the lines to all (human) administrators of the chat,
+// in private.
+func notifyChatAdmins(bot tgbotInterface, chatID int64, lines []string) {
+	admins, err := bot.GetChatAdministrators(tgbotapi.ChatConfig{ChatID: chatID})
+	if err != nil {
+		log.Printf("Unable to get the administrators of chat %d: %v", chatID, err)
+		return
+	}
+	for _, admin := range admins {
+		if admin.User == nil || admin.User.IsBot {
+			continue
+		}
+		if err := sendLongReply(bot, int64(admin.User.ID), 0, lines); err != nil {
+			log.Printf("Unable to notify admin %s (uid=%d): %v", formatName(*admin.User), admin.User.ID, err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Unit tests for the raid module.
+package main
+
+import (
+	"testing"
+	"time"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRaids(t *testing.T) {
+	cfg := raidConfig{Joins: 3, Window: duration{time.Minute}}
+	r := newRaids()
+	start := time.Now()
+
+	caseTests := []struct {
+		offset       time.Duration // Time of the join, relative to start.
+		chatID       int64
+		wantLockdown bool
+		wantStarted  bool
+	}{
+		{offset: 0, chatID: 1},
+		{offset: 10 * time.Second, chatID: 1},
+		// Joins in other chats do not count.
+		{offset: 20 * time.Second, chatID: 2},
+		// The first join falls out of the window.
+		{offset: 61 * time.Second, chatID: 1},
+		{offset: 62 * time.Second, chatID: 1, wantLockdown: true, wantStarted: true},
+		{offset: 63 * time.Second, chatID: 1, wantLockdown: true},
+	}
+
+	for _, tt := range caseTests {
+		lockdown, started := r.join(tt.chatID, start.Add(tt.offset), cfg)
+		if lockdown != tt.wantLockdown || started != tt.wantStarted {
+			t.Errorf("join(%d at %v): got lockdown=%v started=%v, want %v %v", tt.chatID, tt.offset, lockdown, started, tt.wantLockdown, tt.wantStarted)
+		}
+	}
+
+	// The lockdown only ends after the quiet period.
+	quiet := 5 * time.Minute
+	if ended, wait := r.quiet(1, start.Add(63*time.Second+time.Minute), quiet); ended || wait != 4*time.Minute {
+		t.Errorf("quiet: got ended=%v wait=%v, want false 4m", ended, wait)
+	}
+	if ended, _ := r.quiet(1, start.Add(63*time.Second+quiet), quiet); !ended || r.inLockdown(1) {
+		t.Errorf("quiet: lockdown did not end after the quiet period")
+	}
+
+	// Manual lockdowns never end automatically.
+	if !r.set(2, true, true) || r.set(2, true, true) {
+		t.Errorf("set: expected to start the lockdown only once")
+	}
+	if ended, wait := r.quiet(2, start.Add(time.Hour), quiet); ended || wait != 0 || !r.inLockdown(2) {
+		t.Errorf("quiet: manual lockdown ended automatically")
+	}
+
+	// A manual request turns an automatic lockdown into a manual one.
+	r.join(1, start.Add(2*time.Hour), cfg)
+	r.join(1, start.Add(2*time.Hour+time.Second), cfg)
+	r.join(1, start.Add(2*time.Hour+2*time.Second), cfg)
+	if !r.inLockdown(1) || r.set(1, true, true) {
+		t.Errorf("set: expected an automatic lockdown already in place")
+	}
+	if ended, _ := r.quiet(1, start.Add(3*time.Hour), quiet); ended || !r.inLockdown(1) {
+		t.Errorf("quiet: lockdown made manual ended automatically")
+	}
+}
+
+func TestWatchRaidRestrict(t *testing.T) {
+	x := opBot{
+		config:         botConfig{Chats: []chatConfig{{ID: chatID, Raid: raidConfig{Captcha: raidCaptchaRestrict}}}},
+		raids:          newRaids(),
+		pendingCaptcha: newPendingCaptchaType(),
+		captchaTime:    time.Minute,
+	}
+	x.raids.set(chatID, true, true)
+
+	bot := &MockTelebot{}
+	bot.On("RestrictChatMember", mock.Anything).Return(tgbotapi.APIResponse{Ok: true}, nil).Once()
+
+	user := tgbotapi.User{ID: userID}
+	if !x.watchRaid(bot, chatID, user) {
+		t.Fatalf("watchRaid: user joining during lockdown not handled")
+	}
+	// Restricted users still owe a captcha, sent on their first message.
+	captcha, ok := x.pendingCaptcha.get(userID)
+	if !ok || captcha.code != captchaNotSent || captcha.chatID != chatID {
+		t.Errorf("watchRaid: got pending captcha %+v (%v), want an unsent captcha for chat %d", captcha, ok, chatID)
+	}
+	bot.AssertExpectations(t)
+}
@@ -0,0 +1,308 @@
+// User reputation, built from the tenure, activity and moderation history of
+// each user in each chat.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+)
+
+const (
+	// File to store the reputation records.
+	reputationDB = "reputation.json"
+
+	// Points given for each week in the chat, up to repTenureMax.
+	repTenurePerWeek = 2
+	repTenureMax     = 20
+	// Points given for every repMessagesStep messages, up to repMessagesMax.
+	repMessagesStep = 10
+	repMessagesMax  = 20
+	// Points for each report confirmed by the admins (filed by the user).
+	repConfirmedReport = 5
+	// Points for each captcha failure, report against the user and warning.
+	repCaptchaFailure = -5
+	repReported       = -10
+	repWarning        = -5
+
+	// Records of users not seen in a chat for this long are removed.
+	repRecordMaxAge = 365 * 24 * time.Hour
+)
+
+// repRecord holds the history of a user in a chat.
+type repRecord struct {
+	// When the user joined the chat (zero if the bot did not see it).
+	Joined time.Time `json:"joined,omitempty"`
+	// When the bot first saw the user in the chat.
+	FirstSeen time.Time `json:"first_seen"`
+	// When the bot last saw the user in the chat (joining, posting, etc).
+	LastSeen time.Time `json:"last_seen,omitempty"`
+	// Last known username, so admins can look users up by @username.
+	UserName string `json:"username,omitempty"`
+	// Number of messages posted.
+	Messages int `json:"messages,omitempty"`
+	// Number of failed captchas.
+	CaptchaFailures int `json:"captcha_failures,omitempty"`
+	// Number of messages from the user reported to the admins.
+	Reported int `json:"reported,omitempty"`
+	// Number of reports filed by the user and confirmed by the admins.
+	ConfirmedReports int `json:"confirmed_reports,omitempty"`
+	// Number of warnings received.
+	Warnings int `json:"warnings,omitempty"`
+}
+
+// reputation holds the reputation records of all users, by chat. Records
+// change all the time (on every message and join), so they are saved
+// periodically instead of on every change.
+type reputation struct {
+	sync.RWMutex
+	// Users maps "chatID:userID" to the user record.
+	Users        map[string]*repRecord
+	reputationDB string
+	dirty        bool
+}
+
+// newReputation creates a new reputation object.
+func newReputation() *reputation {
+	return &reputation{
+		Users:        map[string]*repRecord{},
+		reputationDB: reputationDB,
+	}
+}
+
+// loadReputation loads the reputation records from the disk.
+func (r *reputation) loadReputation() error {
+	r.Lock()
+	defer r.Unlock()
+
+	err := readJSONFromDataDir(&r.Users, r.reputationDB)
+	if r.Users == nil {
+		r.Users = map[string]*repRecord{}
+	}
+	return err
+}
+
+// save saves the reputation records to the disk. Locks are assumed to be
+// taken care of by the caller.
+func (r *reputation) save() {
+	if err := safeWriteJSON(r.Users, r.reputationDB); err != nil {
+		log.Printf("Error saving reputation records: %v", err)
+		return
+	}
+	r.dirty = false
+}
+
+// autosave saves the records periodically, if they changed.
+func (r *reputation) autosave(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			r.flush()
+		}
+	}()
+}
+
+// flush removes stale records and saves the records, if they changed.
+func (r *reputation) flush() {
+	r.Lock()
+	defer r.Unlock()
+	r.expire(time.Now())
+	if r.dirty {
+		r.save()
+	}
+}
+
+// expire removes the records of users not seen in the chat for
+// repRecordMaxAge. Locks are assumed to be taken care of by the caller.
+func (r *reputation) expire(now time.Time) {
+	for key, rec := range r.Users {
+		last := rec.LastSeen
+		if last.IsZero() {
+			last = rec.FirstSeen
+		}
+		if now.Sub(last) > repRecordMaxAge {
+			delete(r.Users, key)
+			r.dirty = true
+		}
+	}
+}
+
+// record returns the record of the user in the chat, creating it if needed,
+// and marks the user as seen. Locks are assumed to be taken care of by the
+// caller.
+func (r *reputation) record(chatID int64, userID int, now time.Time) *repRecord {
+	key := chatUserKey(chatID, userID)
+	rec, ok := r.Users[key]
+	if !ok {
+		rec = &repRecord{FirstSeen: now}
+		r.Users[key] = rec
+	}
+	if now.After(rec.LastSeen) {
+		rec.LastSeen = now
+	}
+	r.dirty = true
+	return rec
+}
+
+// update applies the function to the record of the user in the chat. The
+// records are saved by autosave.
+func (r *reputation) update(chatID int64, userID int, f func(*repRecord)) {
+	r.Lock()
+	defer r.Unlock()
+	f(r.record(chatID, userID, time.Now()))
+}
+
+// joined records a user joining the chat. Users joining again keep the
+// original join date.
+func (r *reputation) joined(chatID int64, user tgbotapi.User, now time.Time) {
+	r.update(chatID, user.ID, func(rec *repRecord) {
+		if rec.Joined.IsZero() {
+			rec.Joined = now
+		}
+		rec.UserName = user.UserName
+	})
+}
+
+// message counts a message posted by the user in the chat.
+func (r *reputation) message(chatID int64, user tgbotapi.User, now time.Time) {
+	r.Lock()
+	defer r.Unlock()
+
+	rec := r.record(chatID, user.ID, now)
+	rec.Messages++
+	rec.UserName = user.UserName
+}
+
+// captchaFailed records a captcha failure of the user in the chat.
+func (r *reputation) captchaFailed(chatID int64, userID int) {
+	r.update(chatID, userID, func(rec *repRecord) { rec.CaptchaFailures++ })
+}
+
+// reported records a message from the user reported to the admins.
+func (r *reputation) reported(chatID int64, userID int) {
+	r.update(chatID, userID, func(rec *repRecord) { rec.Reported++ })
+}
+
+// confirmedReport records a report filed by the user and confirmed by the
+// admins.
+func (r *reputation) confirmedReport(chatID int64, userID int) {
+	r.update(chatID, userID, func(rec *repRecord) { rec.ConfirmedReports++ })
+}
+
+// warned records a warning received by the user in the chat.
+func (r *reputation) warned(chatID int64, userID int) {
+	r.update(chatID, userID, func(rec *repRecord) { rec.Warnings++ })
+}
+
+// score returns the reputation score of the user in the chat, and how it was
+// computed. Unknown users have a score of zero.
+func (r *reputation) score(chatID int64, userID int, now time.Time) scoreResult {
+	r.RLock()
+	defer r.RUnlock()
+
+	var result scoreResult
+	rec, ok := r.Users[chatUserKey(chatID, userID)]
+	if !ok {
+		return result
+	}
+
+	since := rec.Joined
+	if since.IsZero() || rec.FirstSeen.Before(since) {
+		since = rec.FirstSeen
+	}
+	weeks := int(now.Sub(since) / (7 * 24 * time.Hour))
+	result.add(fmt.Sprintf("%d weeks in the chat", weeks), min(weeks*repTenurePerWeek, repTenureMax))
+	result.add(fmt.Sprintf("%d messages", rec.Messages), min(rec.Messages/repMessagesStep, repMessagesMax))
+	result.add(fmt.Sprintf("%d confirmed reports", rec.ConfirmedReports), rec.ConfirmedReports*repConfirmedReport)
+	result.add(fmt.Sprintf("%d captcha failures", rec.CaptchaFailures), rec.CaptchaFailures*repCaptchaFailure)
+	result.add(fmt.Sprintf("%d times reported", rec.Reported), rec.Reported*repReported)
+	result.add(fmt.Sprintf("%d warnings", rec.Warnings), rec.Warnings*repWarning)
+	return result
+}
+
+// find returns the chats where the user has a record, and the user ID. The
+// user is given as "@username" or a numeric ID.
+func (r *reputation) find(who string) ([]int64, int) {
+	r.RLock()
+	defer r.RUnlock()
+
+	userID, err := strconv.Atoi(who)
+	username, byName := strings.CutPrefix(who, "@")
+	if err != nil && !byName {
+		return nil, 0
+	}
+
+	var chats []int64
+	for key, rec := range r.Users {
+		chat, user, ok := strings.Cut(key, ":")
+		if !ok {
+			continue
+		}
+		uid, err := strconv.Atoi(user)
+		if err != nil {
+			continue
+		}
+		if byName && !strings.EqualFold(rec.UserName, username) || !byName && uid != userID {
+			continue
+		}
+		if id, err := strconv.ParseInt(chat, 10, 64); err == nil {
+			chats = append(chats, id)
+			userID = uid
+		}
+	}
+	sort.Slice(chats, func(i, j int) bool { return chats[i] < chats[j] })
+	return chats, userID
+}
+
+// reputationScore returns the reputation score of the user in the chat.
+func (x *opBot) reputationScore(chatID int64, userID int) int {
+	return x.reputation.score(chatID, userID, time.Now()).Total
+}
+
+// confirmReport raises the reputation of the users who reported a message
+// removed by the admins.
+func (x *opBot) confirmReport(report banRequest) {
+	for reporter := range report.Reporters {
+		x.reputation.confirmedReport(report.ChatID, int(reporter))
+	}
+}
+
+// repHandler shows how the reputation score of a user was computed. In a
+// group, it applies to the chat. In private, to all chats where the bot has
+// seen the user. Usage: /rep <@username|user_id>, or in reply to a message.
+func (x *opBot) repHandler(bot tgbotInterface, update tgbotapi.Update) error {
+	msg := update.Message
+
+	var chats []int64
+	var userID int
+	switch who := strings.TrimSpace(msg.CommandArguments()); {
+	case who != "":
+		chats, userID = x.reputation.find(who)
+	case msg.ReplyToMessage != nil && msg.ReplyToMessage.From != nil:
+		chats, userID = []int64{msg.Chat.ID}, msg.ReplyToMessage.From.ID
+	default:
+		return fmt.Errorf("usage: /rep <@username|user\\_id>, or in reply to a message")
+	}
+	if !isPrivateChat(msg.Chat) {
+		chats = []int64{msg.Chat.ID}
+	}
+	if userID == 0 {
+		return fmt.Errorf("user not found")
+	}
+
+	var lines []string
+	for _, chatID := range chats {
+		result := x.reputation.score(chatID, userID, time.Now())
+		lines = append(lines, fmt.Sprintf("User %d in chat %d: %s", userID, chatID, markdownEscape(result.String())))
+	}
+	if len(lines) == 0 {
+		lines = []string{fmt.Sprintf("No reputation records for user %d.", userID)}
+	}
+	return sendLongReply(bot, msg.Chat.ID, msg.MessageID, lines)
+}
@@ -0,0 +1,91 @@
+// Unit tests for the reputation module.
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+)
+
+func TestReputation(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	now := time.Now()
+	user := tgbotapi.User{ID: 100, UserName: "veteran"}
+
+	r := newReputation()
+	r.joined(-1001, user, now.Add(-30*24*time.Hour))
+	for i := 0; i < 55; i++ {
+		r.message(-1001, user, now)
+	}
+	r.confirmedReport(-1001, 100)
+	r.warned(-1001, 100)
+	r.message(-1002, user, now)
+	r.captchaFailed(-1002, 100)
+	r.reported(-1002, 100)
+
+	caseTests := []struct {
+		chatID    int64
+		userID    int
+		wantTotal int
+		wantItems int
+	}{
+		// 4 weeks (+8), 55 messages (+5), one confirmed report (+5) and
+		// one warning (-5).
+		{chatID: -1001, userID: 100, wantTotal: 13, wantItems: 4},
+		// One captcha failure (-5) and one report (-10).
+		{chatID: -1002, userID: 100, wantTotal: -15, wantItems: 2},
+		// Unknown user.
+		{chatID: -1001, userID: 200, wantTotal: 0, wantItems: 0},
+	}
+	for _, tt := range caseTests {
+		got := r.score(tt.chatID, tt.userID, now)
+		if got.Total != tt.wantTotal || len(got.Items) != tt.wantItems {
+			t.Errorf("score(%d, %d): got %v, want total %d with %d items", tt.chatID, tt.userID, got, tt.wantTotal, tt.wantItems)
+		}
+	}
+
+	// Tenure and activity points are capped.
+	long := newReputation()
+	long.reputationDB = "other_reputation.json"
+	long.joined(-1001, user, now.Add(-365*24*time.Hour))
+	for i := 0; i < 1000; i++ {
+		long.message(-1001, user, now)
+	}
+	if got, want := long.score(-1001, 100, now).Total, repTenureMax+repMessagesMax; got != want {
+		t.Errorf("score: got %d for an old and active user, want %d", got, want)
+	}
+
+	// Users can be found by username or ID.
+	for _, who := range []string{"@Veteran", "100"} {
+		chats, userID := r.find(who)
+		if want := []int64{-1002, -1001}; !reflect.DeepEqual(chats, want) || userID != 100 {
+			t.Errorf("find(%q): got chats %v, user %d, want chats %v, user 100", who, chats, userID, want)
+		}
+	}
+	if chats, _ := r.find("@nobody"); len(chats) != 0 {
+		t.Errorf("find(@nobody): got chats %v, want none", chats)
+	}
+
+	// Records of users not seen for a long time are removed.
+	r.message(-1003, tgbotapi.User{ID: 300}, now.Add(-2*repRecordMaxAge))
+	r.expire(now)
+	if _, ok := r.Users[chatUserKey(-1003, 300)]; ok {
+		t.Errorf("expire: stale record of user 300 not removed")
+	}
+	if _, ok := r.Users[chatUserKey(-1001, 100)]; !ok {
+		t.Errorf("expire: record of user 100 removed")
+	}
+
+	// Records are only saved when flushed.
+	r.flush()
+	reloaded := newReputation()
+	if err := reloaded.loadReputation(); err != nil {
+		t.Fatalf("loadReputation: %v", err)
+	}
+	if got, want := reloaded.score(-1001, 100, now), r.score(-1001, 100, now); !reflect.DeepEqual(got, want) {
+		t.Errorf("loadReputation: got score %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,260 @@
+// Weighted spam scoring.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+	"github.com/patrickmn/go-cache"
+)
+
+// Number of scored messages kept for the /score command.
+const scoreLogSize = 100
+
+// scoreItem is one of the reasons a message got points.
+type scoreItem struct {
+	Reason string
+	Points int
+}
+
+// scoreResult holds the total score of a message and its breakdown.
+type scoreResult struct {
+	Items []scoreItem
+	Total int
+}
+
+// add adds points to the score. Reasons worth no points are ignored.
+func (r *scoreResult) add(reason string, points int) {
+	if points == 0 {
+		return
+	}
+	r.Items = append(r.Items, scoreItem{reason, points})
+	r.Total += points
+}
+
+// String returns the score breakdown in a single line.
+func (r scoreResult) String() string {
+	var parts []string
+	for _, item := range r.Items {
+		parts = append(parts, fmt.Sprintf("%s %+d", item.Reason, item.Points))
+	}
+	if len(parts) == 0 {
+		parts = []string{"nothing"}
+	}
+	return fmt.Sprintf("%d (%s)", r.Total, strings.Join(parts, ", "))
+}
+
+// scoredMessage holds the score of a message moderated in scoring mode.
+type scoredMessage struct {
+	ID        int
+	Time      time.Time
+	ChatID    int64
+	MessageID int
+	User      tgbotapi.User
+	Sample    string
+	Result    scoreResult
+	Action    opMatchAction
+}
+
+// scoreRules adds the weight of every pattern matching the data to the
+// score, and returns the matching patterns.
+func (p *opPatterns) scoreRules(m opMatchPattern, result *scoreResult) []opPatternAction {
+	var rules []opPatternAction
+	for _, name := range patternFields {
+		data := m.field(name)
+		if data == "" {
+			continue
+		}
+		normalized := normalizeText(data)
+		for _, pa := range *p.field(name) {
+			if pa.matches(data, normalized) {
+				rules = append(rules, pa)
+				result.add(fmt.Sprintf("pattern #%d (%s)", pa.ID, name), pa.weight())
+			}
+		}
+	}
+	return rules
+}
+
+// action returns the threshold with the highest score not above the total,
+// if any.
+func (c scoreConfig) action(total int) (scoreThreshold, bool) {
+	thresholds := append([]scoreThreshold{}, c.Thresholds...)
+	sort.Slice(thresholds, func(i, j int) bool { return thresholds[i].Score > thresholds[j].Score })
+
+	for _, t := range thresholds {
+		if total >= t.Score {
+			return t, true
+		}
+	}
+	return scoreThreshold{}, false
+}
+
+// scoreMatchPattern returns the data matched against the patterns in scoring
+// mode. Besides the message contents, the name of the author is matched
+// against the nickname and username patterns.
+func scoreMatchPattern(msg *tgbotapi.Message) opMatchPattern {
+	m := opMatchPattern{
+		Nickname: strings.TrimSpace(msg.From.FirstName + " " + msg.From.LastName),
+		Username: msg.From.UserName,
+		Message:  msg.Text,
+	}
+	if msg.Caption != "" {
+		m.Message = msg.Caption
+	}
+	if msg.Sticker != nil {
+		m.Sticker = msg.Sticker.SetName
+	}
+	return m
+}
+
+// hasProfilePhoto returns true if the user has a profile photo. Results are
+// cached, and errors count as having a photo.
+func (x *opBot) hasProfilePhoto(bot getUserProfilePhotoser, userID int) bool {
+	strID := fmt.Sprintf("%d", userID)
+	if v, found := x.profilePhotoCache.Get(strID); found {
+		return v.(bool)
+	}
+
+	photos, err := bot.GetUserProfilePhotos(tgbotapi.UserProfilePhotosConfig{UserID: userID, Limit: 1})
+	if err != nil {
+		log.Printf("Unable to get profile photos for uid=%d: %v", userID, err)
+		return true
+	}
+	ret := photos.TotalCount > 0
+	x.profilePhotoCache.Set(strID, ret, cache.DefaultExpiration)
+	return ret
+}
+
+// firstMessage returns true if this is the first message we see from a user
+// under probation in the chat, and remembers the user posted.
+func (x *opBot) firstMessage(msg *tgbotapi.Message) bool {
+	if !x.onProbation(msg.Chat.ID, msg.From.ID) {
+		return false
+	}
+	key := fmt.Sprintf("%d:%d", msg.Chat.ID, msg.From.ID)
+	if _, found := x.postedCache.Get(key); found {
+		return false
+	}
+	x.postedCache.Set(key, time.Now(), cache.DefaultExpiration)
+	return true
+}
+
+// scoreMessage returns the score of a message and the patterns matching it.
+func (x *opBot) scoreMessage(bot getUserProfilePhotoser, msg *tgbotapi.Message, cfg scoreConfig, first bool) ([]opPatternAction, scoreResult) {
+	var result scoreResult
+	rules := x.patterns.scoreRules(scoreMatchPattern(msg), &result)
+
+	if msg.From.UserName == "" {
+		result.add("no username", cfg.NoUsername)
+	}
+	if cfg.NoProfilePhoto != 0 && !x.hasProfilePhoto(bot, msg.From.ID) {
+		result.add("no profile photo", cfg.NoProfilePhoto)
+	}
+	if cfg.NewAccountID > 0 && msg.From.ID >= cfg.NewAccountID {
+		result.add("new account", cfg.NewAccount)
+	}
+	if first && len(linkHosts(msg)) > 0 {
+		result.add("link in first message", cfg.FirstMessageLink)
+	}
+	return rules, result
+}
+
+// handledScoring scores the message and performs the action configured for
+// the score, if any.
+func (x *opBot) handledScoring(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, cfg scoreConfig) (opMatchAction, error) {
+	if msg.From == nil {
+		return opNoAction, nil
+	}
+
+	rules, result := x.scoreMessage(bot, msg, cfg, x.firstMessage(msg))
+	threshold, ok := cfg.action(result.Total)
+	if !ok {
+		return opNoAction, nil
+	}
+
+	// As with patterns, a threshold without a (valid) action simply deletes
+	// the message.
+	action := actionFromString(threshold.Action)
+	if action == opNoAction {
+		action = opDelete
+	}
+	log.Printf("Message %d from user %s (uid=%d) in chat %d scored %v, action: %v", msg.MessageID, formatName(*msg.From), msg.From.ID, msg.Chat.ID, result, action)
+
+	victim := 0
+	if action.removesUser() {
+		victim = msg.From.ID
+	}
+	sample := messageSample(msg)
+	for _, rule := range rules {
+		x.patternStats.record(rule.ID, sample, victim)
+	}
+	x.logScore(msg, result, action)
+
+	return action, x.performPatternAction(bot, msg, action, threshold.Duration.Duration, fmt.Sprintf("score %d", result.Total))
+}
+
+// logScore keeps the score of a moderated message, so admins can see it later.
+func (x *opBot) logScore(msg *tgbotapi.Message, result scoreResult, action opMatchAction) {
+	x.scoreLogID++
+	x.scoreLog = append(x.scoreLog, scoredMessage{
+		ID:        x.scoreLogID,
+		Time:      time.Now(),
+		ChatID:    msg.Chat.ID,
+		MessageID: msg.MessageID,
+		User:      *msg.From,
+		Sample:    messageSample(msg),
+		Result:    result,
+		Action:    action,
+	})
+	if len(x.scoreLog) > scoreLogSize {
+		x.scoreLog = x.scoreLog[len(x.scoreLog)-scoreLogSize:]
+	}
+}
+
+// describe returns a markdown safe description of the scored message.
+func (s scoredMessage) describe() string {
+	sample := s.Sample
+	if runes := []rune(sample); len(runes) > patternStatsMaxSampleLen {
+		sample = string(runes[:patternStatsMaxSampleLen]) + "..."
+	}
+	return fmt.Sprintf("#%d %s chat %d, %s (uid=%d), %s: %s\n%s",
+		s.ID, s.Time.Format("2006-01-02 15:04"), s.ChatID, formatName(s.User), s.User.ID,
+		strings.ToLower(s.Action.String()), markdownEscape(s.Result.String()), markdownEscape(sample))
+}
+
+// scoreHandler shows the score breakdown of moderated messages. In a group,
+// in reply to a message, it shows the score of that message instead.
+func (x *opBot) scoreHandler(bot tgbotInterface, update tgbotapi.Update) error {
+	msg := update.Message
+
+	if reply := msg.ReplyToMessage; reply != nil && reply.From != nil {
+		for _, s := range x.scoreLog {
+			if s.ChatID == reply.Chat.ID && s.MessageID == reply.MessageID {
+				_, err := sendReply(bot, msg.Chat.ID, msg.MessageID, s.describe())
+				return err
+			}
+		}
+		_, result := x.scoreMessage(bot, reply, x.config.forChat(reply.Chat.ID).Score, false)
+		_, err := sendReply(bot, msg.Chat.ID, msg.MessageID, "Score: "+markdownEscape(result.String()))
+		return err
+	}
+
+	if !isPrivateChat(msg.Chat) {
+		return fmt.Errorf("usage: reply to a message with /score, or use /score in private")
+	}
+
+	var lines []string
+	for i := len(x.scoreLog) - 1; i >= 0; i-- {
+		lines = append(lines, x.scoreLog[i].describe())
+	}
+	if len(lines) == 0 {
+		lines = []string{"No scored messages."}
+	}
+	return sendLongReply(bot, msg.Chat.ID, msg.MessageID, lines)
+}
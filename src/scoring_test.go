@@ -0,0 +1,124 @@
+// Unit tests for the scoring module.
+package main
+
+import (
+	"testing"
+	"time"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+	"github.com/patrickmn/go-cache"
+)
+
+func TestScoreAction(t *testing.T) {
+	cfg := scoreConfig{
+		Thresholds: []scoreThreshold{
+			{Score: 30, Action: "ban"},
+			{Score: 10, Action: "report"},
+			{Score: 20, Action: "delete"},
+		},
+	}
+
+	caseTests := []struct {
+		total  int
+		want   string
+		wantOk bool
+	}{
+		{total: 0},
+		{total: 9},
+		{total: 10, want: "report", wantOk: true},
+		{total: 25, want: "delete", wantOk: true},
+		{total: 100, want: "ban", wantOk: true},
+	}
+
+	for _, tt := range caseTests {
+		th, ok := cfg.action(tt.total)
+		if ok != tt.wantOk || th.Action != tt.want {
+			t.Errorf("action(%d): got %q (%v), want %q (%v)", tt.total, th.Action, ok, tt.want, tt.wantOk)
+		}
+	}
+}
+
+func TestScoreMessage(t *testing.T) {
+	patterns := opPatterns{
+		Message: []opPatternAction{
+			{ID: 1, Pattern: "crypto", Action: "ban"},
+			{ID: 2, Pattern: "free", Weight: 5},
+			{ID: 3, Pattern: "never"},
+		},
+		Nickname: []opPatternAction{
+			{ID: 4, Pattern: "^invest", Weight: 7},
+		},
+	}
+	cfg := scoreConfig{
+		NoUsername:       1,
+		NoProfilePhoto:   2,
+		NewAccount:       4,
+		NewAccountID:     5000,
+		FirstMessageLink: 8,
+	}
+
+	caseTests := []struct {
+		message *tgbotapi.Message
+		photos  int  // Number of profile photos of the user.
+		first   bool // First message of a new user.
+		want    int
+		wantIDs []int
+	}{
+		{
+			// All patterns (including the nickname) add their weight.
+			message: &tgbotapi.Message{
+				From: &tgbotapi.User{ID: 100, FirstName: "Investor", UserName: "inv"},
+				Text: "Free crypto!",
+			},
+			photos:  1,
+			want:    defaultPatternWeight + 5 + 7,
+			wantIDs: []int{1, 2, 4},
+		},
+		{
+			// Nothing matches, but all the signals count.
+			message: &tgbotapi.Message{
+				From: &tgbotapi.User{ID: 5001, FirstName: "John"},
+				Text: "Look at www.example.com",
+			},
+			first: true,
+			want:  1 + 2 + 4 + 8,
+		},
+		{
+			// Links only count in the first message.
+			message: &tgbotapi.Message{
+				From: &tgbotapi.User{ID: 101, FirstName: "John", UserName: "john"},
+				Text: "Look at www.example.com",
+			},
+			photos: 1,
+		},
+	}
+
+	for _, tt := range caseTests {
+		bot := &MockTelebot{}
+		bot.On("GetUserProfilePhotos", tgbotapi.UserProfilePhotosConfig{UserID: tt.message.From.ID, Limit: 1}).Return(tgbotapi.UserProfilePhotos{TotalCount: tt.photos}, nil)
+
+		b := opBot{
+			patterns:          patterns,
+			profilePhotoCache: cache.New(time.Hour, time.Hour),
+		}
+		rules, result := b.scoreMessage(bot, tt.message, cfg, tt.first)
+
+		if result.Total != tt.want {
+			t.Errorf("scoreMessage(%q): got score %v, want %d", tt.message.Text, result, tt.want)
+		}
+		var ids []int
+		for _, rule := range rules {
+			ids = append(ids, rule.ID)
+		}
+		if len(ids) != len(tt.wantIDs) {
+			t.Errorf("scoreMessage(%q): got patterns %v, want %v", tt.message.Text, ids, tt.wantIDs)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tt.wantIDs[i] {
+				t.Errorf("scoreMessage(%q): got patterns %v, want %v", tt.message.Text, ids, tt.wantIDs)
+				break
+			}
+		}
+	}
+}
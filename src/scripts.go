@@ -0,0 +1,181 @@
+// Filters based on the Unicode scripts (writing systems) used in messages.
+
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+)
+
+// Texts with fewer letters than this are ignored by the script filters, unless
+// the filter says otherwise. Nicknames are much shorter than messages, so they
+// have a lower default.
+const (
+	defaultScriptMinLetters         = 10
+	defaultScriptNicknameMinLetters = 3
+)
+
+// scriptNames holds the names of all Unicode scripts, sorted, so lookups
+// always return the same result.
+var scriptNames = func() []string {
+	var names []string
+	for name := range unicode.Scripts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}()
+
+// runeScript returns the name of the Unicode script of the rune, or an empty
+// string if the rune does not belong to any script.
+func runeScript(r rune) string {
+	// Fast path for the most common case.
+	if unicode.Is(unicode.Latin, r) {
+		return "Latin"
+	}
+	for _, name := range scriptNames {
+		if unicode.Is(unicode.Scripts[name], r) {
+			return name
+		}
+	}
+	return ""
+}
+
+// scriptShares returns the share (from 0 to 1) of each script among the
+// letters in the text, and the number of letters. Anything other than letters
+// (digits, punctuation, emoji, etc) is ignored.
+func scriptShares(text string) (map[string]float64, int) {
+	counts := map[string]int{}
+	letters := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		counts[runeScript(r)]++
+	}
+
+	shares := map[string]float64{}
+	for name, n := range counts {
+		shares[name] = float64(n) / float64(letters)
+	}
+	return shares, letters
+}
+
+// match returns true if the combined share of the filter scripts in the text
+// (a nickname, if nickname is true) is above the threshold, along with the
+// share (in percent).
+func (f scriptFilter) match(text string, nickname bool) (bool, int) {
+	minLetters := f.MinLetters
+	if minLetters == 0 {
+		minLetters = defaultScriptMinLetters
+		if nickname {
+			minLetters = defaultScriptNicknameMinLetters
+		}
+	}
+	shares, letters := scriptShares(text)
+	if letters < minLetters {
+		return false, 0
+	}
+
+	var share float64
+	for _, name := range f.Scripts {
+		share += shares[name]
+	}
+	percent := int(share * 100)
+	return percent > f.Percent, percent
+}
+
+// matchScriptFilter returns the first filter matching the text, and the share
+// (in percent) of the filter scripts in it. Filters for users under probation
+// only apply if probation is true, and only filters for nicknames apply if
+// nickname is true.
+func matchScriptFilter(filters []scriptFilter, text string, probation, nickname bool) (scriptFilter, int, bool) {
+	for _, f := range filters {
+		if (f.ProbationOnly && !probation) || (nickname && !f.Nickname) {
+			continue
+		}
+		if ok, percent := f.match(text, nickname); ok {
+			return f, percent, true
+		}
+	}
+	return scriptFilter{}, 0, false
+}
+
+// mode returns the mode of the filter, for logs and metrics.
+func (f scriptFilter) mode() string {
+	if f.Shadow {
+		return "shadow"
+	}
+	return "enforce"
+}
+
+// action returns the action of the filter. Filters without a (valid) action
+// delete the message.
+func (f scriptFilter) action() opMatchAction {
+	action := actionFromString(f.Action)
+	if action == opNoAction {
+		return opDelete
+	}
+	return action
+}
+
+// handledScriptFilter checks the message against the script filters of the
+// chat and performs the action of the first matching filter. Returns true if
+// the message was removed.
+func (x *opBot) handledScriptFilter(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) bool {
+	text := msg.Text
+	if msg.Caption != "" {
+		text = msg.Caption
+	}
+
+	cfg := x.config.forChat(msg.Chat.ID)
+	f, percent, ok := matchScriptFilter(cfg.ScriptFilters, text, x.onProbation(msg.Chat.ID, msg.From.ID), false)
+	if !ok {
+		return false
+	}
+
+	promScriptFilterCount.WithLabelValues("message", f.mode()).Inc()
+	log.Printf("Script filter (%s mode) matched message %d from user %s (uid=%d): %d%% %s", f.mode(), msg.MessageID, formatName(*msg.From), msg.From.ID, percent, strings.Join(f.Scripts, "/"))
+	if f.Shadow {
+		return false
+	}
+
+	// Messages are handled exactly like a pattern match.
+	action := f.action()
+	if err := x.performPatternAction(bot, msg, action, f.Duration.Duration, "script filter "+strings.Join(f.Scripts, "/")); err != nil {
+		log.Printf("Error handling script filter match: %v", err)
+	}
+	return action.deletesMessage()
+}
+
+// handledScriptNickname checks the nickname of a new user against the script
+// filters of the chat. Returns true if the user was removed from the chat.
+func (x *opBot) handledScriptNickname(bot *tgbotapi.BotAPI, chatID int64, user tgbotapi.User) bool {
+	nickname := strings.TrimSpace(user.FirstName + " " + user.LastName)
+
+	// New users are always under probation.
+	cfg := x.config.forChat(chatID)
+	f, percent, ok := matchScriptFilter(cfg.ScriptFilters, nickname, true, true)
+	if !ok {
+		return false
+	}
+
+	promScriptFilterCount.WithLabelValues("nickname", f.mode()).Inc()
+	log.Printf("Script filter (%s mode) matched nickname of new user %s (uid=%d): %d%% %s", f.mode(), formatName(user), user.ID, percent, strings.Join(f.Scripts, "/"))
+	if f.Shadow {
+		return false
+	}
+
+	// There is no message to delete, so only actions on the user apply.
+	action := f.action()
+	promPatternActionCount.WithLabelValues(strings.ToLower(action.String())).Inc()
+	if err := x.performUserAction(bot, chatID, user, action, f.Duration.Duration); err != nil {
+		log.Printf("Error handling script filter match on nickname: %v", err)
+	}
+	return action.removesUser()
+}
@@ -0,0 +1,84 @@
+// Unit tests for the scripts module.
+package main
+
+import (
+	"testing"
+)
+
+func TestMatchScriptFilter(t *testing.T) {
+	filters := []scriptFilter{
+		{Scripts: []string{"Han", "Cyrillic"}, Percent: 60, ProbationOnly: true, Action: "warn"},
+		{Scripts: []string{"Arabic"}, Percent: 50, Nickname: true, MinLetters: 3, Action: "ban"},
+		{Scripts: []string{"Han"}, Percent: 50, ProbationOnly: true, Nickname: true, Action: "kick"},
+	}
+
+	caseTests := []struct {
+		text       string
+		probation  bool
+		nickname   bool
+		wantAction string
+		wantOk     bool
+	}{
+		{
+			// Portuguese and English are fine.
+			text:      "Alguém sabe como usar goroutines em Go? Thanks!",
+			probation: true,
+		},
+		{
+			// Mostly Chinese, from a new user.
+			text:       "加入我们的群组赚大钱 now",
+			probation:  true,
+			wantAction: "warn",
+			wantOk:     true,
+		},
+		{
+			// Combined Han and Cyrillic are above the threshold.
+			text:       "Привет 加入我们的群组 hello",
+			probation:  true,
+			wantAction: "warn",
+			wantOk:     true,
+		},
+		{
+			// Same message from a user past probation.
+			text: "加入我们的群组赚大钱 now",
+		},
+		{
+			// Too short to tell.
+			text:      "你好",
+			probation: true,
+		},
+		{
+			// Digits, punctuation and emoji do not count.
+			text:      "1234567890 !!!! 🚀🚀🚀 加入",
+			probation: true,
+		},
+		{
+			// Only nickname filters apply to nicknames.
+			text:       "محمد علي",
+			nickname:   true,
+			probation:  true,
+			wantAction: "ban",
+			wantOk:     true,
+		},
+		{
+			// Nicknames are short, so fewer letters are needed.
+			text:       "王小明 李",
+			nickname:   true,
+			probation:  true,
+			wantAction: "kick",
+			wantOk:     true,
+		},
+		{
+			text:      "李",
+			nickname:  true,
+			probation: true,
+		},
+	}
+
+	for _, tt := range caseTests {
+		f, percent, ok := matchScriptFilter(filters, tt.text, tt.probation, tt.nickname)
+		if ok != tt.wantOk || f.Action != tt.wantAction {
+			t.Errorf("matchScriptFilter(%q): got %q (%d%%, %v), want %q (%v)", tt.text, f.Action, percent, ok, tt.wantAction, tt.wantOk)
+		}
+	}
+}
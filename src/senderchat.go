@@ -0,0 +1,252 @@
+// Messages sent on behalf of channels and anonymous admins (sender_chat).
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+	"github.com/patrickmn/go-cache"
+)
+
+// Messages sent on behalf of chats are recognized by the placeholder users
+// Telegram puts in the from field. The sender_chat field itself is not decoded
+// by the Telegram library in use (see updates.go).
+const (
+	// Automatic forwards of posts from the linked channel.
+	telegramServiceUserID = 777000
+	// Messages sent on behalf of a channel (@Channel_Bot).
+	channelBotUserID = 136817688
+	// Messages sent by anonymous admins of the group (@GroupAnonymousBot).
+	groupAnonymousBotUserID = 1087968824
+)
+
+// isPlaceholderUser returns true if the user ID is one of the placeholders
+// Telegram uses for messages sent on behalf of chats.
+func isPlaceholderUser(userID int) bool {
+	switch userID {
+	case telegramServiceUserID, channelBotUserID, groupAnonymousBotUserID:
+		return true
+	}
+	return false
+}
+
+const (
+	// Kinds of messages sent on behalf of chats.
+	senderLinkedChannel  = "linked_channel"
+	senderChannel        = "channel"
+	senderAnonymousAdmin = "anonymous_admin"
+
+	// Policies for messages sent on behalf of channels.
+	senderChatAllow  = "allow"
+	senderChatDelete = "delete"
+	senderChatLinked = "linked"
+
+	// File to store the stats of messages sent on behalf of chats.
+	senderChatStatsDB = "sender_chat_stats.csv"
+
+	// How long to keep the channel linked to each chat.
+	linkedChatCacheTime = time.Hour
+)
+
+// senderChatKind returns the kind of chat the message was sent on behalf of,
+// or an empty string for messages sent by regular users.
+func senderChatKind(msg *tgbotapi.Message) string {
+	if msg == nil || msg.From == nil {
+		return ""
+	}
+	switch msg.From.ID {
+	case telegramServiceUserID:
+		return senderLinkedChannel
+	case channelBotUserID:
+		return senderChannel
+	case groupAnonymousBotUserID:
+		return senderAnonymousAdmin
+	}
+	return ""
+}
+
+// senderChatID returns the ID of the chat the message was sent on behalf of,
+// or zero if unknown. Anonymous admins post as the group.
+func senderChatID(msg *tgbotapi.Message, kind string) int64 {
+	switch {
+	case kind == senderAnonymousAdmin:
+		return msg.Chat.ID
+	case extrasFor(msg).SenderChat != nil:
+		return extrasFor(msg).SenderChat.ID
+	case msg.ForwardFromChat != nil:
+		return msg.ForwardFromChat.ID
+	}
+	return 0
+}
+
+// senderChatUser returns the user standing for the channel a message was sent
+// on behalf of, so that moderation keeps the state of each channel apart
+// instead of sharing the placeholder user. Its ID is the ID of the channel,
+// which is negative and never clashes with user IDs. Returns false if the
+// channel is unknown.
+func senderChatUser(msg *tgbotapi.Message, kind string) (tgbotapi.User, bool) {
+	id := senderChatID(msg, kind)
+	if id == 0 {
+		return tgbotapi.User{}, false
+	}
+	user := tgbotapi.User{ID: int(id), FirstName: fmt.Sprintf("channel %d", id)}
+	if sc := extrasFor(msg).SenderChat; sc != nil && sc.ID == id {
+		if sc.Title != "" {
+			user.FirstName = sc.Title
+		}
+		user.UserName = sc.UserName
+	}
+	return user, true
+}
+
+// isSenderChatUser returns true if the user stands for a channel (see
+// senderChatUser).
+func isSenderChatUser(user tgbotapi.User) bool {
+	return user.ID < 0
+}
+
+// senderChatAllowed returns true if the policy allows messages of this kind.
+// Linked is true for messages sent on behalf of the channel linked to the
+// chat.
+func senderChatAllowed(policy, kind string, linked bool) bool {
+	switch policy {
+	case senderChatDelete:
+		return false
+	case senderChatLinked:
+		return kind == senderLinkedChannel || linked
+	}
+	return true
+}
+
+// linkedChatID returns the ID of the channel linked to the chat (zero if
+// none), caching it for a while.
+func (x *opBot) linkedChatID(bot *tgbotapi.BotAPI, chatID int64) (int64, error) {
+	key := strconv.FormatInt(chatID, 10)
+	if v, ok := x.linkedChats.Get(key); ok {
+		return v.(int64), nil
+	}
+
+	v := url.Values{}
+	v.Add("chat_id", key)
+	resp, err := bot.MakeRequest("getChat", v)
+	if err != nil {
+		return 0, err
+	}
+	// The Telegram library in use does not decode linked_chat_id.
+	var chat struct {
+		LinkedChatID int64 `json:"linked_chat_id"`
+	}
+	if err := json.Unmarshal(resp.Result, &chat); err != nil {
+		return 0, err
+	}
+	x.linkedChats.Set(key, chat.LinkedChatID, cache.DefaultExpiration)
+	return chat.LinkedChatID, nil
+}
+
+// sentAsLinkedChannel returns true if the message was sent on behalf of the
+// channel linked to the chat (E.g. by a member posting as the channel).
+func (x *opBot) sentAsLinkedChannel(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) bool {
+	sc := extrasFor(msg).SenderChat
+	if sc == nil {
+		return false
+	}
+	linked, err := x.linkedChatID(bot, msg.Chat.ID)
+	if err != nil {
+		log.Printf("Unable to get the channel linked to chat %d: %v", msg.Chat.ID, err)
+		return false
+	}
+	return linked != 0 && sc.ID == linked
+}
+
+// validateSenderChat checks the sender_chat policy of all chats.
+func validateSenderChat(chats []chatConfig) error {
+	for _, cc := range chats {
+		switch cc.SenderChat {
+		case "", senderChatAllow, senderChatDelete, senderChatLinked:
+		default:
+			return fmt.Errorf("chat %d: unknown sender_chat policy %q (valid: %s, %s, %s)", cc.ID, cc.SenderChat, senderChatAllow, senderChatDelete, senderChatLinked)
+		}
+	}
+	return nil
+}
+
+// saveSenderChatStats saves information on a message sent on behalf of a chat
+// as CSV: message ID, UNIX timestamp, kind, sender chat ID, message length.
+func saveSenderChatStats(w io.Writer, msg *tgbotapi.Message, kind string) (string, error) {
+	if w == nil {
+		return "", fmt.Errorf("%s", T("stats_error_nil_writer"))
+	}
+	line := fmt.Sprintf("%d,%d,%s,%d,%d\n", msg.MessageID, msg.Date, kind, senderChatID(msg, kind), len(msg.Text)+len(msg.Caption))
+	_, err := fmt.Fprint(w, line)
+	return line, err
+}
+
+// banSenderChat bans a channel identity from the chat. Its users can no longer
+// post on behalf of the channel.
+func banSenderChat(bot *tgbotapi.BotAPI, chatID, senderChatID int64) error {
+	v := url.Values{}
+	v.Add("chat_id", strconv.FormatInt(chatID, 10))
+	v.Add("sender_chat_id", strconv.FormatInt(senderChatID, 10))
+	_, err := bot.MakeRequest("banChatSenderChat", v)
+	return err
+}
+
+// handledSenderChat applies the sender_chat policy of the chat to messages
+// sent on behalf of channels. Returns true if the message was deleted, or if
+// it comes from the linked channel, in which case no other processing
+// applies. Other allowed messages go through the same moderation
+// as messages from users, with the channel taking the place of the author (see
+// senderChatUser). Messages from anonymous admins are only counted, as they
+// are handled like admins.
+func (x *opBot) handledSenderChat(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) bool {
+	kind := senderChatKind(msg)
+	if kind == "" {
+		return false
+	}
+
+	if msg.Chat.UserName == osProgramadoresGroup {
+		if saved, err := saveSenderChatStats(x.senderChatStatsWriter, msg, kind); err != nil {
+			log.Println(T("stats_error_saving"), err.Error(), saved)
+		}
+	}
+
+	if kind == senderAnonymousAdmin {
+		promSenderChatCount.WithLabelValues(kind, "allow").Inc()
+		return false
+	}
+
+	cfg := x.config.forChat(msg.Chat.ID)
+	linked := kind == senderChannel && x.sentAsLinkedChannel(bot, msg)
+	if senderChatAllowed(cfg.SenderChat, kind, linked) {
+		promSenderChatCount.WithLabelValues(kind, "allow").Inc()
+		return kind == senderLinkedChannel || linked
+	}
+
+	promSenderChatCount.WithLabelValues(kind, "delete").Inc()
+	log.Printf("Deleting message %d sent on behalf of a chat (%s) in chat %d, policy %q.", msg.MessageID, kind, msg.Chat.ID, cfg.SenderChat)
+	x.quarantineMessage(msg, "sent on behalf of a chat ("+kind+")")
+	deleteMessage(bot, msg.Chat.ID, msg.MessageID)
+
+	if !cfg.SenderChatBan {
+		return true
+	}
+	id := senderChatID(msg, kind)
+	if id == 0 {
+		log.Printf("Unable to ban the channel identity of message %d in chat %d: unknown channel.", msg.MessageID, msg.Chat.ID)
+		return true
+	}
+	if err := banSenderChat(bot, msg.Chat.ID, id); err != nil {
+		log.Printf("Unable to ban channel %d from chat %d: %v", id, msg.Chat.ID, err)
+		return true
+	}
+	promSenderChatCount.WithLabelValues(kind, "ban").Inc()
+	log.Printf("Banned channel %d from chat %d.", id, msg.Chat.ID)
+	return true
+}
@@ -0,0 +1,163 @@
+// Unit tests for the sender_chat module.
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+)
+
+func TestSenderChat(t *testing.T) {
+	caseTests := []struct {
+		name       string
+		msg        *tgbotapi.Message
+		wantKind   string
+		wantAllow  bool
+		wantLinked bool
+	}{
+		{
+			name: "Regular user",
+			msg:  &tgbotapi.Message{From: &tgbotapi.User{ID: userID}},
+		},
+		{
+			name:       "Linked channel",
+			msg:        &tgbotapi.Message{From: &tgbotapi.User{ID: telegramServiceUserID}},
+			wantKind:   senderLinkedChannel,
+			wantAllow:  true,
+			wantLinked: true,
+		},
+		{
+			name:      "Channel",
+			msg:       &tgbotapi.Message{From: &tgbotapi.User{ID: channelBotUserID, IsBot: true}},
+			wantKind:  senderChannel,
+			wantAllow: true,
+		},
+	}
+	for _, tt := range caseTests {
+		if got := senderChatKind(tt.msg); got != tt.wantKind {
+			t.Errorf("senderChatKind(%s): got %q, want %q", tt.name, got, tt.wantKind)
+		}
+		kind := senderChatKind(tt.msg)
+		if kind == "" {
+			continue
+		}
+		if got := senderChatAllowed(senderChatAllow, kind, false); got != tt.wantAllow {
+			t.Errorf("senderChatAllowed(allow, %s): got %v, want %v", tt.name, got, tt.wantAllow)
+		}
+		if got := senderChatAllowed(senderChatLinked, kind, false); got != tt.wantLinked {
+			t.Errorf("senderChatAllowed(linked, %s): got %v, want %v", tt.name, got, tt.wantLinked)
+		}
+		if senderChatAllowed(senderChatDelete, kind, false) {
+			t.Errorf("senderChatAllowed(delete, %s): got true, want false", tt.name)
+		}
+	}
+
+	// Members posting as the linked channel come through @Channel_Bot.
+	if !senderChatAllowed(senderChatLinked, senderChannel, true) {
+		t.Errorf("senderChatAllowed(linked): got false for a post as the linked channel")
+	}
+	if senderChatAllowed(senderChatDelete, senderChannel, true) {
+		t.Errorf("senderChatAllowed(delete): got true for a post as the linked channel")
+	}
+
+	if err := validateSenderChat([]chatConfig{{SenderChat: "linked"}, {}}); err != nil {
+		t.Errorf("validateSenderChat: got error %v for valid policies", err)
+	}
+	if err := validateSenderChat([]chatConfig{{SenderChat: "ban"}}); err == nil {
+		t.Errorf("validateSenderChat: got no error for an unknown policy")
+	}
+}
+
+func TestSaveSenderChatStats(t *testing.T) {
+	caseTests := []struct {
+		msg  *tgbotapi.Message
+		kind string
+		want string
+	}{
+		{
+			msg: &tgbotapi.Message{
+				MessageID:       10,
+				Date:            1000,
+				Chat:            &tgbotapi.Chat{ID: chatID},
+				Text:            "hello",
+				ForwardFromChat: &tgbotapi.Chat{ID: -1005},
+			},
+			kind: senderLinkedChannel,
+			want: "10,1000,linked_channel,-1005,5\n",
+		},
+		{
+			msg: &tgbotapi.Message{
+				MessageID: 11,
+				Date:      1001,
+				Chat:      &tgbotapi.Chat{ID: chatID},
+				Caption:   "photo",
+			},
+			kind: senderChannel,
+			want: "11,1001,channel,0,5\n",
+		},
+		{
+			msg: &tgbotapi.Message{
+				MessageID: 12,
+				Date:      1002,
+				Chat:      &tgbotapi.Chat{ID: chatID},
+			},
+			kind: senderAnonymousAdmin,
+			want: "12,1002,anonymous_admin,1234,0\n",
+		},
+		{
+			// Channel known from the raw update.
+			msg: &tgbotapi.Message{
+				MessageID: 13,
+				Date:      1003,
+				Chat:      &tgbotapi.Chat{ID: chatID},
+				Text:      "buy",
+			},
+			kind: senderChannel,
+			want: "13,1003,channel,-1006,3\n",
+		},
+	}
+	if err := recordMessageExtras([]byte(`{"update_id": 1, "message": {"message_id": 13, "chat": {"id": 1234}, "sender_chat": {"id": -1006}}}`)); err != nil {
+		t.Fatalf("recordMessageExtras: %v", err)
+	}
+	for _, tt := range caseTests {
+		var buf bytes.Buffer
+		if _, err := saveSenderChatStats(&buf, tt.msg, tt.kind); err != nil {
+			t.Fatalf("saveSenderChatStats: %v", err)
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("saveSenderChatStats: got %q, want %q", got, tt.want)
+		}
+	}
+
+	if _, err := saveSenderChatStats(nil, &tgbotapi.Message{}, senderChannel); err == nil {
+		t.Errorf("saveSenderChatStats: got no error with a nil writer")
+	}
+}
+
+func TestSenderChatUser(t *testing.T) {
+	if err := recordMessageExtras([]byte(`{"update_id": 1, "message": {"message_id": 14, "chat": {"id": 1234}, "sender_chat": {"id": -1007, "type": "channel", "title": "Spam News", "username": "spamnews"}}}`)); err != nil {
+		t.Fatalf("recordMessageExtras: %v", err)
+	}
+	placeholder := &tgbotapi.User{ID: channelBotUserID, IsBot: true}
+
+	msg := &tgbotapi.Message{MessageID: 14, From: placeholder, Chat: &tgbotapi.Chat{ID: chatID}}
+	user, ok := senderChatUser(msg, senderChannel)
+	if !ok || user.ID != -1007 || user.FirstName != "Spam News" || user.UserName != "spamnews" || !isSenderChatUser(user) {
+		t.Errorf("senderChatUser: got %+v (%v), want channel -1007 \"Spam News\"", user, ok)
+	}
+
+	// Channels are told apart even without the extra fields.
+	msg = &tgbotapi.Message{MessageID: 15, From: placeholder, Chat: &tgbotapi.Chat{ID: chatID}, ForwardFromChat: &tgbotapi.Chat{ID: -1008}}
+	if user, ok := senderChatUser(msg, senderChannel); !ok || user.ID != -1008 {
+		t.Errorf("senderChatUser: got %+v (%v), want channel -1008", user, ok)
+	}
+
+	msg = &tgbotapi.Message{MessageID: 16, From: placeholder, Chat: &tgbotapi.Chat{ID: chatID}}
+	if _, ok := senderChatUser(msg, senderChannel); ok {
+		t.Errorf("senderChatUser: got a channel for a message without sender chat")
+	}
+	if isSenderChatUser(tgbotapi.User{ID: userID}) {
+		t.Errorf("isSenderChatUser: got true for a regular user")
+	}
+}
@@ -0,0 +1,129 @@
+// Cleanup of the join and leave service messages posted by Telegram.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+)
+
+const (
+	// Kinds of service messages handled.
+	serviceJoin  = "join"
+	serviceLeave = "leave"
+
+	// How often the join and leave counts are posted.
+	serviceCountInterval = 24 * time.Hour
+)
+
+// serviceMessageKind returns the kind of service message (join or leave), or
+// an empty string if the message is not a join or leave service message.
+func serviceMessageKind(msg *tgbotapi.Message) string {
+	switch {
+	case msg == nil:
+		return ""
+	case msg.NewChatMembers != nil && len(*msg.NewChatMembers) > 0:
+		return serviceJoin
+	case msg.LeftChatMember != nil:
+		return serviceLeave
+	}
+	return ""
+}
+
+// joinLeaveCount holds the number of joins and leaves in a chat.
+type joinLeaveCount struct {
+	Title  string
+	Joins  int
+	Leaves int
+}
+
+// serviceCounts holds the number of joins and leaves in each chat since they
+// were last posted. The counts are not saved, so they start over when the bot
+// restarts.
+type serviceCounts struct {
+	sync.Mutex
+	counts map[int64]*joinLeaveCount
+}
+
+// newServiceCounts creates a new serviceCounts object.
+func newServiceCounts() *serviceCounts {
+	return &serviceCounts{
+		counts: map[int64]*joinLeaveCount{},
+	}
+}
+
+// add counts the joins and leaves in the service message.
+func (sc *serviceCounts) add(msg *tgbotapi.Message, kind string) {
+	sc.Lock()
+	defer sc.Unlock()
+
+	c, ok := sc.counts[msg.Chat.ID]
+	if !ok {
+		c = &joinLeaveCount{}
+		sc.counts[msg.Chat.ID] = c
+	}
+	c.Title = msg.Chat.Title
+	switch kind {
+	case serviceJoin:
+		c.Joins += len(*msg.NewChatMembers)
+	case serviceLeave:
+		c.Leaves++
+	}
+}
+
+// reset returns the counts of all chats and starts over.
+func (sc *serviceCounts) reset() map[int64]*joinLeaveCount {
+	sc.Lock()
+	defer sc.Unlock()
+
+	counts := sc.counts
+	sc.counts = map[int64]*joinLeaveCount{}
+	return counts
+}
+
+// handledServiceMessage counts the join and leave service messages, and
+// removes them from chats configured to do so (right away, or after a delay).
+// Returns true if the message will be removed.
+func (x *opBot) handledServiceMessage(bot deleteMessager, msg *tgbotapi.Message) bool {
+	kind := serviceMessageKind(msg)
+	if kind == "" {
+		return false
+	}
+
+	cfg := x.config.forChat(msg.Chat.ID).ServiceMessages
+	if cfg.DailyCount {
+		x.serviceCounts.add(msg, kind)
+	}
+	if !cfg.Delete {
+		return false
+	}
+
+	promServiceMessageDeletedCount.WithLabelValues(kind).Inc()
+	if d := cfg.DeleteAfter.Duration; d > 0 {
+		selfDestructMessage(bot, msg.Chat.ID, msg.MessageID, d)
+		return true
+	}
+	deleteMessage(bot, msg.Chat.ID, msg.MessageID)
+	return true
+}
+
+// serviceCountReporter posts the number of joins and leaves in the last 24
+// hours to each chat configured to do so, once a day.
+func (x *opBot) serviceCountReporter(bot sender) {
+	go func() {
+		for range time.Tick(serviceCountInterval) {
+			for chatID, c := range x.serviceCounts.reset() {
+				if !x.config.forChat(chatID).ServiceMessages.DailyCount {
+					continue
+				}
+				if _, err := sendMessage(bot, chatID, fmt.Sprintf(T("service_daily_count"), c.Joins, c.Leaves)); err != nil {
+					log.Printf("Unable to post join and leave counts to chat %d (%s): %v", chatID, c.Title, err)
+				}
+			}
+		}
+	}()
+}
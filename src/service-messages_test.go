@@ -0,0 +1,58 @@
+// Unit tests for the service messages module.
+package main
+
+import (
+	"testing"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+)
+
+func TestHandledServiceMessage(t *testing.T) {
+	x := opBot{
+		config: botConfig{
+			Chats: []chatConfig{
+				{ID: chatID, ServiceMessages: serviceMessageConfig{Delete: true, DailyCount: true}},
+				{ID: chatID + 1, ServiceMessages: serviceMessageConfig{DailyCount: true}},
+			},
+		},
+		serviceCounts: newServiceCounts(),
+	}
+	joined := &[]tgbotapi.User{{ID: userID}, {ID: userID + 1}}
+
+	caseTests := []struct {
+		msg         *tgbotapi.Message
+		wantKind    string
+		wantHandled bool
+	}{
+		{msg: &tgbotapi.Message{MessageID: 1, Chat: &tgbotapi.Chat{ID: chatID}, NewChatMembers: joined}, wantKind: serviceJoin, wantHandled: true},
+		{msg: &tgbotapi.Message{MessageID: 2, Chat: &tgbotapi.Chat{ID: chatID}, LeftChatMember: &tgbotapi.User{ID: userID}}, wantKind: serviceLeave, wantHandled: true},
+		{msg: &tgbotapi.Message{MessageID: 3, Chat: &tgbotapi.Chat{ID: chatID}, Text: "hello"}},
+		// Counted, but not deleted.
+		{msg: &tgbotapi.Message{MessageID: 4, Chat: &tgbotapi.Chat{ID: chatID + 1}, LeftChatMember: &tgbotapi.User{ID: userID}}, wantKind: serviceLeave},
+	}
+
+	mockTelebot := &MockTelebot{}
+	for _, tt := range caseTests {
+		if tt.wantHandled {
+			mockTelebot.On("DeleteMessage", tgbotapi.DeleteMessageConfig{ChatID: tt.msg.Chat.ID, MessageID: tt.msg.MessageID}).Return(tgbotapi.APIResponse{}, nil).Once()
+		}
+		if got := serviceMessageKind(tt.msg); got != tt.wantKind {
+			t.Errorf("serviceMessageKind(%d): got %q, want %q", tt.msg.MessageID, got, tt.wantKind)
+		}
+		if got := x.handledServiceMessage(mockTelebot, tt.msg); got != tt.wantHandled {
+			t.Errorf("handledServiceMessage(%d): got %v, want %v", tt.msg.MessageID, got, tt.wantHandled)
+		}
+	}
+	mockTelebot.AssertExpectations(t)
+
+	counts := x.serviceCounts.reset()
+	if c := counts[chatID]; c == nil || c.Joins != 2 || c.Leaves != 1 {
+		t.Errorf("counts for chat %d: got %+v, want 2 joins and 1 leave", chatID, c)
+	}
+	if c := counts[chatID+1]; c == nil || c.Joins != 0 || c.Leaves != 1 {
+		t.Errorf("counts for chat %d: got %+v, want 1 leave", chatID+1, c)
+	}
+	if len(x.serviceCounts.reset()) != 0 {
+		t.Errorf("reset: counts not cleared")
+	}
+}
@@ -14,14 +14,14 @@ const (
 	statsDB = "stats.csv"
 )
 
-// initStats opens the stats file for logging the information.
-func initStats() (io.WriteCloser, error) {
+// initStats opens a stats file for logging the information.
+func initStats(file string) (io.WriteCloser, error) {
 	datadir, err := dataDir()
 	if err != nil {
 		return nil, err
 	}
 
-	f, err := os.OpenFile(filepath.Join(datadir, statsDB), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+	f, err := os.OpenFile(filepath.Join(datadir, file), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,177 @@
+// Reception of updates, keeping the message fields the Telegram library drops.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+	"github.com/patrickmn/go-cache"
+)
+
+// How long the extra fields of each message are kept. Messages are processed
+// right after they arrive, but edits may come later.
+const messageExtrasTime = time.Hour
+
+// Updates requested from Telegram. Updates on chat members are only sent if
+// explicitly requested.
+const allowedUpdates = `["message","edited_message","callback_query","chat_member"]`
+
+// messageExtras holds the fields of a message the Telegram library in use
+// does not decode.
+type messageExtras struct {
+	MessageID int `json:"message_id"`
+	Chat      struct {
+		ID int64 `json:"id"`
+	} `json:"chat"`
+
+	// Polls (we only care about their presence).
+	Poll *struct {
+		ID string `json:"id"`
+	} `json:"poll"`
+
+	// Inline bot used to send the message.
+	ViaBot *tgbotapi.User `json:"via_bot"`
+
+	// Chat the message was sent on behalf of.
+	SenderChat *tgbotapi.Chat `json:"sender_chat"`
+}
+
+// rawUpdate holds the parts of an update decoded into messageExtras.
+type rawUpdate struct {
+	Message       *messageExtras `json:"message"`
+	EditedMessage *messageExtras `json:"edited_message"`
+}
+
+// Extra fields of recent messages, by chat and message ID.
+var recentMessageExtras = cache.New(messageExtrasTime, messageExtrasTime)
+
+// messageKey returns the key of a message in recentMessageExtras.
+func messageKey(chatID int64, messageID int) string {
+	return fmt.Sprintf("%d:%d", chatID, messageID)
+}
+
+// extrasFor returns the extra fields of the message. Fields are nil if the
+// message does not have them, or if it is unknown.
+func extrasFor(msg *tgbotapi.Message) messageExtras {
+	if msg == nil || msg.Chat == nil {
+		return messageExtras{}
+	}
+	if v, ok := recentMessageExtras.Get(messageKey(msg.Chat.ID, msg.MessageID)); ok {
+		return v.(messageExtras)
+	}
+	return messageExtras{}
+}
+
+// recordMessageExtras decodes the extra fields of the messages in an update.
+// Only messages with extra fields are recorded.
+func recordMessageExtras(raw json.RawMessage) error {
+	var u rawUpdate
+	if err := json.Unmarshal(raw, &u); err != nil {
+		return err
+	}
+	for _, m := range []*messageExtras{u.Message, u.EditedMessage} {
+		if m == nil {
+			continue
+		}
+		key := messageKey(m.Chat.ID, m.MessageID)
+		if m.Poll == nil && m.ViaBot == nil && m.SenderChat == nil {
+			recentMessageExtras.Delete(key)
+			continue
+		}
+		recentMessageExtras.Set(key, *m, cache.DefaultExpiration)
+	}
+	return nil
+}
+
+// decodeUpdates decodes the result of a getUpdates request, recording the
+// extra fields of the messages. Updates that cannot be decoded are logged and
+// skipped. It returns the decoded updates, and the ID of the last update
+// seen (zero if none), so the offset moves past the skipped ones too.
+func decodeUpdates(result json.RawMessage) ([]tgbotapi.Update, int, error) {
+	var raws []json.RawMessage
+	if err := json.Unmarshal(result, &raws); err != nil {
+		return nil, 0, err
+	}
+
+	var updates []tgbotapi.Update
+	lastID := 0
+	for _, raw := range raws {
+		var id struct {
+			UpdateID int `json:"update_id"`
+		}
+		// Best effort: the ID is still found in most broken updates.
+		json.Unmarshal(raw, &id)
+		if id.UpdateID > lastID {
+			lastID = id.UpdateID
+		}
+
+		var update tgbotapi.Update
+		if err := json.Unmarshal(raw, &update); err != nil {
+			log.Printf("Skipping update %d that cannot be decoded: %v: %s", id.UpdateID, err, raw)
+			continue
+		}
+		if err := recordMessageExtras(raw); err != nil {
+			log.Printf("Unable to decode the extra fields of update %d: %v", update.UpdateID, err)
+		}
+		updates = append(updates, update)
+	}
+	return updates, lastID, nil
+}
+
+// getUpdates works like GetUpdates in the Telegram library, but also records
+// the extra fields of the messages received, and skips updates that cannot
+// be decoded. It also returns the ID of the last update received.
+func getUpdates(bot *tgbotapi.BotAPI, config tgbotapi.UpdateConfig) ([]tgbotapi.Update, int, error) {
+	v := url.Values{}
+	if config.Offset != 0 {
+		v.Add("offset", strconv.Itoa(config.Offset))
+	}
+	if config.Limit > 0 {
+		v.Add("limit", strconv.Itoa(config.Limit))
+	}
+	if config.Timeout > 0 {
+		v.Add("timeout", strconv.Itoa(config.Timeout))
+	}
+	v.Add("allowed_updates", allowedUpdates)
+
+	resp, err := bot.MakeRequest("getUpdates", v)
+	if err != nil {
+		return nil, 0, err
+	}
+	return decodeUpdates(resp.Result)
+}
+
+// updatesChan works like GetUpdatesChan in the Telegram library, using
+// getUpdates to fetch the updates.
+func updatesChan(bot *tgbotapi.BotAPI, config tgbotapi.UpdateConfig) tgbotapi.UpdatesChannel {
+	ch := make(chan tgbotapi.Update, bot.Buffer)
+
+	go func() {
+		for {
+			updates, lastID, err := getUpdates(bot, config)
+			if err != nil {
+				log.Printf("Failed to get updates, retrying in 3 seconds: %v", err)
+				time.Sleep(3 * time.Second)
+				continue
+			}
+			for _, update := range updates {
+				if update.UpdateID >= config.Offset {
+					config.Offset = update.UpdateID + 1
+					ch <- update
+				}
+			}
+			// Skipped updates are confirmed as well.
+			if lastID >= config.Offset {
+				config.Offset = lastID + 1
+			}
+		}
+	}()
+
+	return ch
+}
@@ -0,0 +1,64 @@
+// Unit tests for the updates module.
+package main
+
+import (
+	"testing"
+
+	tgbotapi "github.com/osprogramadores/telegram-bot-api"
+)
+
+func TestDecodeUpdates(t *testing.T) {
+	result := `[
+		{"update_id": 1, "message": {"message_id": 10, "chat": {"id": 5678}, "poll": {"id": "abc", "question": "Free crypto?"}}},
+		{"update_id": 2, "message": {"message_id": 11, "chat": {"id": 5678}, "via_bot": {"id": 42, "is_bot": true, "username": "spambot"}}},
+		{"update_id": 3, "message": {"message_id": 12, "chat": {"id": 5678}, "sender_chat": {"id": -1005, "type": "channel"}}},
+		{"update_id": 4, "edited_message": {"message_id": 13, "chat": {"id": 5678}, "text": "hello"}},
+		{"update_id": 5, "callback_query": {"id": "1"}},
+		{"update_id": 6, "message": {"message_id": "bogus", "chat": {"id": 5678}}}
+	]`
+	updates, lastID, err := decodeUpdates([]byte(result))
+	if err != nil {
+		t.Fatalf("decodeUpdates: got error %v", err)
+	}
+	// Undecodable updates are skipped, but still seen.
+	if len(updates) != 5 || lastID != 6 {
+		t.Errorf("decodeUpdates: got %d updates, last ID %d, want 5 and 6", len(updates), lastID)
+	}
+
+	caseTests := []struct {
+		msgID          int
+		wantPoll       bool
+		wantInlineBot  bool
+		wantSenderChat int64
+	}{
+		{msgID: 10, wantPoll: true},
+		{msgID: 11, wantInlineBot: true},
+		{msgID: 12, wantSenderChat: -1005},
+		{msgID: 13},
+		{msgID: 14},
+	}
+	for _, tt := range caseTests {
+		msg := &tgbotapi.Message{MessageID: tt.msgID, Chat: &tgbotapi.Chat{ID: 5678}}
+		if got := contentKinds["poll"](msg); got != tt.wantPoll {
+			t.Errorf("message %d: got poll %v, want %v", tt.msgID, got, tt.wantPoll)
+		}
+		if got := contentKinds["inline_bot"](msg); got != tt.wantInlineBot {
+			t.Errorf("message %d: got inline_bot %v, want %v", tt.msgID, got, tt.wantInlineBot)
+		}
+		var got int64
+		if sc := extrasFor(msg).SenderChat; sc != nil {
+			got = sc.ID
+		}
+		if got != tt.wantSenderChat {
+			t.Errorf("message %d: got sender chat %d, want %d", tt.msgID, got, tt.wantSenderChat)
+		}
+	}
+
+	// Edits removing the extra fields are recorded too.
+	if err := recordMessageExtras([]byte(`{"update_id": 7, "edited_message": {"message_id": 10, "chat": {"id": 5678}, "text": "hi"}}`)); err != nil {
+		t.Fatalf("recordMessageExtras: got error %v", err)
+	}
+	if contentKinds["poll"](&tgbotapi.Message{MessageID: 10, Chat: &tgbotapi.Chat{ID: 5678}}) {
+		t.Errorf("edited message 10: got poll, want none")
+	}
+}
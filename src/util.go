@@ -12,6 +12,10 @@ import (
 	tgbotapi "github.com/osprogramadores/telegram-bot-api"
 )
 
+// maxMessageSize is the maximum size of a message we send in a single request.
+// Telegram limits messages to 4096 characters, so leave some room for safety.
+const maxMessageSize = 4000
+
 // safeWriteJSON saves `data' (in json) to file.
 func safeWriteJSON(data interface{}, file string) error {
 	buf, err := json.Marshal(data)
@@ -22,25 +26,30 @@ func safeWriteJSON(data interface{}, file string) error {
 	if err != nil {
 		return err
 	}
+	return safeWriteFile(buf, datadir, file)
+}
 
-	tmpfile, err := os.CreateTemp(datadir, "safe-write")
+// safeWriteFile atomically replaces `file' under `dir' with the contents of
+// `buf', by writing to a temporary file first and renaming it.
+func safeWriteFile(buf []byte, dir, file string) error {
+	tmpfile, err := os.CreateTemp(dir, "safe-write")
 	if err != nil {
-		log.Printf("safeWriteJSON: error creating temp file to save data: %v", err)
+		log.Printf("safeWriteFile: error creating temp file to save data: %v", err)
 		return err
 	}
 	defer os.Remove(tmpfile.Name())
 
 	if _, err = tmpfile.Write(buf); err != nil {
-		log.Printf("safeWriteJSON: error writing data to temp file: %v", err)
+		log.Printf("safeWriteFile: error writing data to temp file: %v", err)
 		return err
 	}
 
 	if err = tmpfile.Close(); err != nil {
-		log.Printf("safeWriteJSON: error closing temp file with data: %v", err)
+		log.Printf("safeWriteFile: error closing temp file with data: %v", err)
 		return err
 	}
 
-	f := filepath.Join(datadir, file)
+	f := filepath.Join(dir, file)
 	return os.Rename(tmpfile.Name(), f)
 }
 
@@ -128,6 +137,28 @@ func sendReply(bot sender, chatid int64, messageid int, text string) (tgbotapi.M
 	return bot.Send(msg)
 }
 
+// sendLongReply sends the lines as replies to a specific MessageID, splitting
+// them across as many messages as needed to respect Telegram's size limits.
+func sendLongReply(bot sender, chatid int64, messageid int, lines []string) error {
+	var chunk []string
+	size := 0
+	for _, line := range lines {
+		if size+len(line)+1 > maxMessageSize && len(chunk) > 0 {
+			if _, err := sendReply(bot, chatid, messageid, strings.Join(chunk, "\n")); err != nil {
+				return err
+			}
+			chunk, size = nil, 0
+		}
+		chunk = append(chunk, line)
+		size += len(line) + 1
+	}
+	if len(chunk) == 0 {
+		return nil
+	}
+	_, err := sendReply(bot, chatid, messageid, strings.Join(chunk, "\n"))
+	return err
+}
+
 // sendMessage sends a message to a specific ChatID (channel, group, etc).
 func sendMessage(bot sender, chatid int64, text string) (tgbotapi.Message, error) {
 	msg := tgbotapi.NewMessage(chatid, text)
@@ -291,3 +322,8 @@ func createMediaMessage(message *tgbotapi.Message, destination int64, markup *tg
 	}
 	return chattable, true, nil
 }
+
+// chatUserKey returns the key used to store the state of a user in a chat.
+func chatUserKey(chatID int64, userID int) string {
+	return fmt.Sprintf("%d:%d", chatID, userID)
+}